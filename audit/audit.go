@@ -0,0 +1,247 @@
+/*
+Copyright (c) 2021 Intel Corporation
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+// Package audit keeps an append-only log of the frames removed by the
+// retention of ImageStore, rotated by size.
+package audit
+
+import (
+	isConfigMgr "IEdgeInsights/ImageStore/isconfigmgr"
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Reasons of a removal
+const (
+	ReasonExpired      string = "expired"
+	ReasonArchived     string = "archived"
+	ReasonEvicted      string = "evicted"
+	ReasonDiskPressure string = "disk_pressure"
+	ReasonArchiveAged  string = "archive_expired"
+)
+
+// Defaults of the audit log config
+const (
+	defaultPath     = "/data/.imagestore/audit.log"
+	defaultMaxBytes = 10 * 1024 * 1024
+	defaultMaxFiles = 5
+)
+
+// Record is the audit entry of a removed frame
+type Record struct {
+	Handle  string    `json:"img_handle"`
+	Size    int64     `json:"size"`
+	Age     string    `json:"age"`
+	Rule    string    `json:"rule,omitempty"`
+	Reason  string    `json:"reason"`
+	Removed time.Time `json:"removed"`
+}
+
+// NewRecord - function to create the record of a frame removed now
+func NewRecord(handle string, size int64, stored time.Time, rule string, reason string) Record {
+	now := time.Now().UTC()
+	return Record{
+		Handle:  handle,
+		Size:    size,
+		Age:     now.Sub(stored).Round(time.Second).String(),
+		Rule:    rule,
+		Reason:  reason,
+		Removed: now,
+	}
+}
+
+// Log is an append-only log of JSON records. When the log file exceeds
+// maxBytes it is renamed to path.1, path.1 to path.2 and so on, keeping
+// maxFiles rotated files.
+type Log struct {
+	mutex    sync.Mutex
+	path     string
+	maxBytes int64
+	maxFiles int
+	file     *os.File
+	size     int64
+}
+
+// Open - function to open or create the audit log at path.
+//
+// Parameters:
+// 1. path : string
+//    Refers to the log file, its directory is created if needed.
+// 2. maxBytes : int64
+//    Refers to the size the log file is rotated at.
+// 3. maxFiles : int
+//    Refers to the number of rotated files kept.
+//
+// Returns:
+// 1. *Log
+//    Returns the audit log.
+// 2. error
+//    Returns an error object if the log file can not be opened.
+func Open(path string, maxBytes int64, maxFiles int) (*Log, error) {
+	if maxBytes <= 0 || maxFiles < 0 {
+		return nil, fmt.Errorf("invalid audit log rotation: maxBytes %d, maxFiles %d", maxBytes, maxFiles)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0750); err != nil {
+		return nil, err
+	}
+	log := &Log{path: path, maxBytes: maxBytes, maxFiles: maxFiles}
+	if err := log.open(); err != nil {
+		return nil, err
+	}
+	return log, nil
+}
+
+// FromConfig - function to open the audit log of the audit log config, the
+// missing values taking their defaults: "/data/.imagestore/audit.log",
+// rotated at 10 MiB keeping 5 rotated files.
+func FromConfig(config *isConfigMgr.AuditLog) (*Log, error) {
+	path := config.Path
+	if path == "" {
+		path = defaultPath
+	}
+	maxBytes := config.MaxBytes
+	if maxBytes == 0 {
+		maxBytes = defaultMaxBytes
+	}
+	maxFiles := defaultMaxFiles
+	if config.MaxFiles != nil {
+		maxFiles = *config.MaxFiles
+	}
+	return Open(path, maxBytes, maxFiles)
+}
+
+func (log *Log) open() error {
+	file, err := os.OpenFile(log.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0640)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	log.file = file
+	log.size = info.Size()
+	return nil
+}
+
+// Append - function to append the records and sync them to disk
+func (log *Log) Append(records ...Record) error {
+	if len(records) == 0 {
+		return nil
+	}
+	log.mutex.Lock()
+	defer log.mutex.Unlock()
+
+	for _, record := range records {
+		line, err := json.Marshal(record)
+		if err != nil {
+			return err
+		}
+		line = append(line, '\n')
+
+		if log.size > 0 && log.size+int64(len(line)) > log.maxBytes {
+			if err := log.rotate(); err != nil {
+				return err
+			}
+		}
+		n, err := log.file.Write(line)
+		log.size += int64(n)
+		if err != nil {
+			return err
+		}
+	}
+	return log.file.Sync()
+}
+
+// rotate renames the log files and opens a new log file
+func (log *Log) rotate() error {
+	if err := log.file.Close(); err != nil {
+		return err
+	}
+	if log.maxFiles == 0 {
+		os.Remove(log.path)
+	} else {
+		os.Remove(log.rotated(log.maxFiles))
+		for i := log.maxFiles - 1; i >= 1; i-- {
+			os.Rename(log.rotated(i), log.rotated(i+1))
+		}
+		if err := os.Rename(log.path, log.rotated(1)); err != nil {
+			return err
+		}
+	}
+	return log.open()
+}
+
+func (log *Log) rotated(i int) string {
+	return fmt.Sprintf("%s.%d", log.path, i)
+}
+
+// Lookup - function to find the records of a frame, oldest first. The log
+// files are scanned, so this is meant for occasional queries only.
+func (log *Log) Lookup(handle string) ([]Record, error) {
+	log.mutex.Lock()
+	defer log.mutex.Unlock()
+
+	records := make([]Record, 0)
+	paths := make([]string, 0, log.maxFiles+1)
+	for i := log.maxFiles; i >= 1; i-- {
+		paths = append(paths, log.rotated(i))
+	}
+	paths = append(paths, log.path)
+
+	for _, path := range paths {
+		file, err := os.Open(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			var record Record
+			// Skipping lines torn by a crash
+			if json.Unmarshal(scanner.Bytes(), &record) == nil && record.Handle == handle {
+				records = append(records, record)
+			}
+		}
+		err = scanner.Err()
+		file.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return records, nil
+}
+
+// Close - function to close the log file
+func (log *Log) Close() error {
+	log.mutex.Lock()
+	defer log.mutex.Unlock()
+	return log.file.Close()
+}
@@ -0,0 +1,122 @@
+/*
+Copyright (c) 2021 Intel Corporation
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package audit
+
+import (
+	isConfigMgr "IEdgeInsights/ImageStore/isconfigmgr"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestAppendLookupRotate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "audit", "audit.log")
+	log, err := Open(path, 512, 2)
+	if err != nil {
+		t.Fatalf("Opening the audit log failed: %v", err)
+	}
+
+	stored := time.Now().Add(-time.Hour)
+	for i := 0; i < 20; i++ {
+		handle := "frame"
+		if i%2 == 1 {
+			handle = "other"
+		}
+		if err := log.Append(NewRecord(handle, int64(i), stored, "default", ReasonExpired)); err != nil {
+			t.Fatalf("Appending failed: %v", err)
+		}
+	}
+	log.Close()
+
+	if _, err := os.Stat(path + ".2"); err != nil {
+		t.Errorf("Log was not rotated: %v", err)
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("More rotated files kept than maxFiles")
+	}
+
+	// Reopening appends to the existing log
+	log, err = Open(path, 512, 2)
+	if err != nil {
+		t.Fatalf("Reopening the audit log failed: %v", err)
+	}
+	defer log.Close()
+
+	records, err := log.Lookup("frame")
+	if err != nil {
+		t.Fatalf("Lookup failed: %v", err)
+	}
+	if len(records) == 0 {
+		t.Fatalf("No records found")
+	}
+	last := records[len(records)-1]
+	if last.Size != 18 || last.Rule != "default" || last.Reason != ReasonExpired || last.Age != "1h0m0s" {
+		t.Errorf("Unexpected last record %+v", last)
+	}
+	for i := 1; i < len(records); i++ {
+		if records[i].Size <= records[i-1].Size {
+			t.Errorf("Records not in order: %+v", records)
+		}
+	}
+
+	if records, _ := log.Lookup("missing"); len(records) != 0 {
+		t.Errorf("Records found for a handle never removed: %+v", records)
+	}
+}
+
+func TestFromConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "audit.log")
+	log, err := FromConfig(&isConfigMgr.AuditLog{Path: path})
+	if err != nil {
+		t.Fatalf("FromConfig() failed: %v", err)
+	}
+	if log.maxBytes != defaultMaxBytes || log.maxFiles != defaultMaxFiles {
+		t.Errorf("Rotating at %d bytes keeping %d files, expected the defaults", log.maxBytes, log.maxFiles)
+	}
+	log.Close()
+
+	// Zero rotated files is valid, a negative count is not
+	noFiles, negative := 0, -1
+	if log, err := FromConfig(&isConfigMgr.AuditLog{Path: path, MaxFiles: &noFiles}); err != nil || log.maxFiles != 0 {
+		t.Errorf("FromConfig() without rotated files = %v", err)
+	} else {
+		log.Close()
+	}
+	if _, err := FromConfig(&isConfigMgr.AuditLog{Path: path, MaxFiles: &negative}); err == nil {
+		t.Errorf("Negative maxFiles accepted")
+	}
+}
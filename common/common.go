@@ -22,25 +22,162 @@ SOFTWARE.
 
 package common
 
+import "time"
+
 //Used to signify trhe code for store command
 
 // ImageHandle - attribute in the request to imagestore server
 const ImageHandle string = "img_handle"
+// ImageHandles - attribute in the read response holding the handles of all
+// the blobs of a multi-blob frame
+const ImageHandles string = "img_handles"
 // Command - attribute in the request to imagestore server
 const Command string = "command"
 // StoreCode - attribute in the request to imagestore
 const StoreCode string = "store"
 // ReadCode - attribute in the request to imagestore server
 const ReadCode string = "read"
+// Topic - optional attribute in the store request to imagestore server
+const Topic string = "topic"
+// Sync - optional attribute in the store request to imagestore server
+const Sync string = "sync"
+// Width - optional attribute in the read request to resize the frame
+const Width string = "width"
+// Height - optional attribute in the read request to resize the frame
+const Height string = "height"
+// Fit - optional attribute in the read request, one of "contain", "cover" or "fill"
+const Fit string = "fit"
+// Crop - optional attribute in the read request, map of "x", "y", "width" and "height"
+const Crop string = "crop"
 // Error - attribute in the response by imagestore server
 const Error string = "error"
+// SimilarCode - attribute in the request to imagestore server
+const SimilarCode string = "similar"
+// Count - optional attribute in the similar request, number of matches
+const Count string = "count"
+// Hash - optional attribute in the similar request, hash algorithm
+const Hash string = "hash"
+// Start - optional attribute in the similar request, RFC3339 time
+const Start string = "start"
+// End - optional attribute in the similar request, RFC3339 time
+const End string = "end"
+// Matches - attribute in the similar response by imagestore server
+const Matches string = "matches"
+// Distance - attribute of a match in the similar response
+const Distance string = "distance"
+// Stored - attribute of a match in the similar response, RFC3339 time
+const Stored string = "stored"
+// MetricsCode - attribute in the request to imagestore server
+const MetricsCode string = "metrics"
+// PinCode - attribute in the request to imagestore server
+const PinCode string = "pin"
+// UnpinCode - attribute in the request to imagestore server
+const UnpinCode string = "unpin"
+// ListPinnedCode - attribute in the request to imagestore server
+const ListPinnedCode string = "list_pinned"
+// Reason - optional attribute in the pin request
+const Reason string = "reason"
+// Pinned - attribute in the list_pinned response by imagestore server
+const Pinned string = "pinned"
+// RetentionReportCode - attribute in the request to imagestore server
+const RetentionReportCode string = "retention_report"
+// RetentionTime - optional attribute in the retention_report request
+const RetentionTime string = "retention_time"
+// AuditLookupCode - attribute in the request to imagestore server
+const AuditLookupCode string = "audit_lookup"
+// Records - attribute in the audit_lookup response by imagestore server
+const Records string = "records"
+// Size - attribute in the audit_lookup response by imagestore server
+const Size string = "size"
+// Age - attribute in the audit_lookup response by imagestore server
+const Age string = "age"
+// Rule - attribute in the audit_lookup response by imagestore server
+const Rule string = "rule"
+// Removed - attribute in the audit_lookup response by imagestore server
+const Removed string = "removed"
+// SubscribeCode - attribute in the request to imagestore server
+const SubscribeCode string = "subscribe"
+// UnsubscribeCode - attribute in the request to imagestore server
+const UnsubscribeCode string = "unsubscribe"
+// SubscriptionsCode - attribute in the request to imagestore server
+const SubscriptionsCode string = "subscriptions"
+// Interface - optional attribute in the subscribe request, the name of the
+// subscriber interface
+const Interface string = "interface"
+// Subscriptions - attribute in the subscriptions response by imagestore server
+const Subscriptions string = "subscriptions"
+// Stats - attribute in the subscriptions response by imagestore server
+const Stats string = "stats"
+// Health - attribute in the subscriptions response by imagestore server
+const Health string = "health"
+// Healthy - attribute of the health in the subscriptions response
+const Healthy string = "healthy"
+// ConsecutiveErrors - attribute of the health in the subscriptions response
+const ConsecutiveErrors string = "consecutive_errors"
+// Reconnects - attribute of the health in the subscriptions response
+const Reconnects string = "reconnects"
+// LastMessage - attribute of the health in the subscriptions response, RFC3339 time
+const LastMessage string = "last_message"
+// LastError - attribute of the health in the subscriptions response
+const LastError string = "last_error"
 // MinioPort - Minio service port
 const MinioPort string = "9000"
 // MinioHost - Minio service ip 
 const MinioHost string = "127.0.0.1"
+// MetaTopic - object metadata holding the topic of a frame
+const MetaTopic string = "Topic"
+// MetaAHash - object metadata holding the aHash of a frame
+const MetaAHash string = "Ahash"
+// MetaDHash - object metadata holding the dHash of a frame
+const MetaDHash string = "Dhash"
+// MetaPHash - object metadata holding the pHash of a frame
+const MetaPHash string = "Phash"
+// MetaPinned - object metadata set to "true" on frames exempt from retention
+const MetaPinned string = "Pinned"
+// MetaPinReason - object metadata holding why a frame was pinned
+const MetaPinReason string = "Pin-Reason"
+// MetaFrameMetadata - object metadata holding the JSON encoded metadata the
+// frame was published with
+const MetaFrameMetadata string = "Frame-Metadata"
+// MetaFrameMetadataTruncated - object metadata set to "true" when fields of
+// the frame metadata were shortened or dropped to fit the object metadata
+const MetaFrameMetadataTruncated string = "Frame-Metadata-Truncated"
+// MetaCaptured - object metadata holding the RFC 3339 capture time of a frame
+const MetaCaptured string = "Captured"
+// DefaultCaptureTimestampKey - default attribute of the published frame
+// metadata holding the capture time of the frame
+const DefaultCaptureTimestampKey string = "timestamp"
+// MetaBlobHandles - object metadata holding the JSON array of the handles of
+// all the blobs of a multi-blob frame, saved on the first blob
+const MetaBlobHandles string = "Blob-Handles"
+// MetaImageHandle - object metadata holding the image handle a frame was
+// published with, when it is stored under a key template
+const MetaImageHandle string = "Img-Handle"
+// DefaultBlobHandleFormat - default handle of the additional blobs of a
+// multi-blob frame
+const DefaultBlobHandleFormat string = "{img_handle}_{index}"
 // DevMode - dev_mode of type bool
 var DevMode bool
 // Writer - writer of type interface
 type Writer interface {
 	Store(value []byte, keyname string) (string, error)
+	StoreFrame(value []byte, keyname string, frameMetadata map[string]interface{}, captured time.Time) (string, error)
+	StoreFrames(values [][]byte, keynames []string, handles []string, frameMetadata map[string]interface{}, captured time.Time) ([]string, error)
+}
+// ObjectInfo - details of a stored object
+type ObjectInfo struct {
+	Key          string
+	Size         int64
+	LastModified time.Time
+	ContentType  string
+	Metadata     map[string]string
+	Err          error
+}
+// StoreResult - outcome of an asynchronous write to the storage
+type StoreResult struct {
+	Key      string
+	Size     int64
+	Checksum string // hex SHA-256 of the data
+	Metadata map[string]string
+	Err      error
 }
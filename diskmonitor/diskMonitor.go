@@ -0,0 +1,171 @@
+/*
+Copyright (c) 2021 Intel Corporation
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package diskmonitor
+
+import (
+	isConfigMgr "IEdgeInsights/ImageStore/isconfigmgr"
+	metrics "IEdgeInsights/ImageStore/metrics"
+	"errors"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// Defaults of the disk pressure config
+const (
+	defaultPath          = "/data"
+	defaultCheckInterval = "10s"
+)
+
+// DiskMonitor - DiskMonitor of type struct, watches the free space of the
+// volume holding the minio data
+type DiskMonitor struct {
+	path            string
+	lowFreePercent  float64
+	highFreePercent float64
+	interval        time.Duration
+	underPressure   int32
+	evictions       chan int64
+	// statfs returns the total and free bytes of the volume, replaced by
+	// the tests
+	statfs func(path string) (int64, int64, error)
+}
+
+// NewDiskMonitor - function to initialize a new DiskMonitor. Disk pressure
+// starts when the free space drops below lowFreePercent of the volume and
+// lasts until it is back above highFreePercent.
+func NewDiskMonitor(path string, lowFreePercent float64, highFreePercent float64, interval time.Duration) *DiskMonitor {
+	return &DiskMonitor{
+		path:            path,
+		lowFreePercent:  lowFreePercent,
+		highFreePercent: highFreePercent,
+		interval:        interval,
+		evictions:       make(chan int64, 1),
+		statfs:          volumeSpace,
+	}
+}
+
+// FromConfig - function to create a DiskMonitor from the disk pressure
+// config, the missing path and check interval taking their defaults
+func FromConfig(config *isConfigMgr.DiskPressure) (*DiskMonitor, error) {
+	if config.LowFreePercent >= config.HighFreePercent {
+		return nil, errors.New("lowFreePercent must be below highFreePercent")
+	}
+	if config.LowFreePercent < 0 || config.HighFreePercent > 100 {
+		return nil, errors.New("lowFreePercent and highFreePercent must be between 0 and 100")
+	}
+
+	path := config.Path
+	if path == "" {
+		path = defaultPath
+	}
+
+	intervalStr := config.CheckInterval
+	if intervalStr == "" {
+		intervalStr = defaultCheckInterval
+	}
+	interval, err := time.ParseDuration(intervalStr)
+	if err != nil {
+		return nil, err
+	}
+	if interval <= 0 {
+		return nil, errors.New("checkInterval must be positive")
+	}
+
+	return NewDiskMonitor(path, config.LowFreePercent, config.HighFreePercent, interval), nil
+}
+
+// volumeSpace - function returning the total and free bytes of the volume
+// holding path, the free bytes being the ones available to unprivileged
+// users
+func volumeSpace(path string) (int64, int64, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(path, &stat); err != nil {
+		return 0, 0, err
+	}
+	return int64(stat.Blocks) * int64(stat.Bsize), int64(stat.Bavail) * int64(stat.Bsize), nil
+}
+
+// Evictions - function returning the channel of emergency eviction requests,
+// each holding the number of bytes to free
+func (monitor *DiskMonitor) Evictions() <-chan int64 {
+	return monitor.evictions
+}
+
+// UnderPressure - function reporting whether the volume is low on free space
+func (monitor *DiskMonitor) UnderPressure() bool {
+	return atomic.LoadInt32(&monitor.underPressure) == 1
+}
+
+// Start - function to start checking the free space on every interval
+func (monitor *DiskMonitor) Start() {
+	glog.Infof("Monitoring free space of %s, low watermark %.1f%%, high watermark %.1f%%",
+		monitor.path, monitor.lowFreePercent, monitor.highFreePercent)
+	go func() {
+		ticker := time.NewTicker(monitor.interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			monitor.check()
+		}
+	}()
+}
+
+// check - function to update the pressure state and request an eviction
+// while under pressure
+func (monitor *DiskMonitor) check() {
+	total, free, err := monitor.statfs(monitor.path)
+	if err != nil {
+		glog.Errorf("Failed to get free space of %s: %v", monitor.path, err)
+		return
+	}
+	if total <= 0 {
+		return
+	}
+	metrics.Set("disk_free_bytes", free)
+
+	freePercent := float64(free) * 100 / float64(total)
+	pressure := freePercent < monitor.lowFreePercent ||
+		(monitor.UnderPressure() && freePercent < monitor.highFreePercent)
+
+	if pressure && !monitor.UnderPressure() {
+		glog.Warningf("Disk pressure on %s: %.1f%% free, below the low watermark of %.1f%%",
+			monitor.path, freePercent, monitor.lowFreePercent)
+		atomic.StoreInt32(&monitor.underPressure, 1)
+		metrics.Set("disk_pressure", 1)
+	} else if !pressure && monitor.UnderPressure() {
+		glog.Infof("Disk pressure on %s relieved: %.1f%% free", monitor.path, freePercent)
+		atomic.StoreInt32(&monitor.underPressure, 0)
+		metrics.Set("disk_pressure", 0)
+	}
+
+	if pressure {
+		needed := int64(monitor.highFreePercent*float64(total)/100) - free
+		// Skipping the request if the previous one is still pending
+		select {
+		case monitor.evictions <- needed:
+		default:
+		}
+	}
+}
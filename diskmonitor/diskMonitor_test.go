@@ -0,0 +1,142 @@
+/*
+Copyright (c) 2021 Intel Corporation
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package diskmonitor
+
+import (
+	isConfigMgr "IEdgeInsights/ImageStore/isconfigmgr"
+	"errors"
+	"testing"
+	"time"
+)
+
+// newTestMonitor returns a monitor of a 1000 bytes volume whose free bytes
+// are set by the test
+func newTestMonitor() (*DiskMonitor, *int64) {
+	free := new(int64)
+	monitor := NewDiskMonitor("/data", 10, 20, time.Minute)
+	monitor.statfs = func(path string) (int64, int64, error) {
+		return 1000, *free, nil
+	}
+	return monitor, free
+}
+
+// pendingEviction returns the pending eviction request, -1 if none
+func pendingEviction(monitor *DiskMonitor) int64 {
+	select {
+	case needed := <-monitor.Evictions():
+		return needed
+	default:
+		return -1
+	}
+}
+
+func TestWatermarks(t *testing.T) {
+	monitor, free := newTestMonitor()
+	tests := []struct {
+		free     int64
+		pressure bool
+		needed   int64
+	}{
+		{500, false, -1},
+		// Below the low watermark, freeing up to the high watermark
+		{50, true, 150},
+		// Between the watermarks, the pressure lasts
+		{150, true, 50},
+		// Above the high watermark
+		{250, false, -1},
+		// Between the watermarks, no pressure yet
+		{150, false, -1},
+	}
+	for _, test := range tests {
+		*free = test.free
+		monitor.check()
+		if monitor.UnderPressure() != test.pressure {
+			t.Errorf("%d bytes free: under pressure %v, expected %v", test.free, monitor.UnderPressure(), test.pressure)
+		}
+		if needed := pendingEviction(monitor); needed != test.needed {
+			t.Errorf("%d bytes free: eviction of %d bytes requested, expected %d", test.free, needed, test.needed)
+		}
+	}
+}
+
+func TestPendingEviction(t *testing.T) {
+	monitor, free := newTestMonitor()
+	*free = 50
+
+	// The checks do not block while the previous request is pending
+	done := make(chan struct{})
+	go func() {
+		monitor.check()
+		*free = 0
+		monitor.check()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("check blocked on a pending eviction")
+	}
+	if needed := pendingEviction(monitor); needed != 150 {
+		t.Errorf("Pending eviction of %d bytes, expected the first request of 150", needed)
+	}
+	if needed := pendingEviction(monitor); needed != -1 {
+		t.Errorf("Second eviction of %d bytes queued", needed)
+	}
+}
+
+func TestStatfsError(t *testing.T) {
+	monitor, _ := newTestMonitor()
+	monitor.statfs = func(path string) (int64, int64, error) {
+		return 0, 0, errors.New("no such volume")
+	}
+	monitor.check()
+	if monitor.UnderPressure() || pendingEviction(monitor) != -1 {
+		t.Errorf("Failed check caused disk pressure")
+	}
+}
+
+func TestFromConfig(t *testing.T) {
+	tests := []struct {
+		name   string
+		config isConfigMgr.DiskPressure
+		valid  bool
+	}{
+		{"defaults", isConfigMgr.DiskPressure{LowFreePercent: 10, HighFreePercent: 20}, true},
+		{"full range", isConfigMgr.DiskPressure{LowFreePercent: 0, HighFreePercent: 100, CheckInterval: "5s"}, true},
+		{"equal watermarks", isConfigMgr.DiskPressure{LowFreePercent: 20, HighFreePercent: 20}, false},
+		{"inverted watermarks", isConfigMgr.DiskPressure{LowFreePercent: 30, HighFreePercent: 20}, false},
+		{"negative low watermark", isConfigMgr.DiskPressure{LowFreePercent: -10, HighFreePercent: 20}, false},
+		{"high watermark above 100", isConfigMgr.DiskPressure{LowFreePercent: 10, HighFreePercent: 120}, false},
+		{"bad interval", isConfigMgr.DiskPressure{LowFreePercent: 10, HighFreePercent: 20, CheckInterval: "often"}, false},
+		{"zero interval", isConfigMgr.DiskPressure{LowFreePercent: 10, HighFreePercent: 20, CheckInterval: "0s"}, false},
+	}
+	for _, test := range tests {
+		monitor, err := FromConfig(&test.config)
+		if test.valid && (err != nil || monitor == nil) {
+			t.Errorf("%s: unexpected error %v", test.name, err)
+		}
+		if !test.valid && err == nil {
+			t.Errorf("%s: accepted", test.name)
+		}
+	}
+}
@@ -0,0 +1,204 @@
+/*
+Copyright (c) 2021 Intel Corporation
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+// Package events publishes the outcome of the writes and the retention
+// deletions of ImageStore on the message bus, so downstream services know
+// when a handle is persisted without polling.
+package events
+
+import (
+	eiimsgbus "EIIMessageBus/eiimsgbus"
+	common "IEdgeInsights/ImageStore/common"
+	metrics "IEdgeInsights/ImageStore/metrics"
+	"fmt"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// Types of the events
+const (
+	TypeStored      string = "stored"
+	TypeStoreFailed string = "store_failed"
+	TypeDeleted     string = "deleted"
+)
+
+// Event is an event published on the message bus
+type Event struct {
+	Type      string
+	Handle    string
+	Key       string
+	Topic     string
+	Size      int64
+	Checksum  string
+	Reason    string
+	Error     string
+	Timestamp time.Time
+}
+
+// toMap - function to get the message of the event, the empty fields left out
+func (event Event) toMap() map[string]interface{} {
+	msg := map[string]interface{}{
+		"type":       event.Type,
+		"img_handle": event.Handle,
+		"size":       event.Size,
+		"timestamp":  event.Timestamp.UTC().Format(time.RFC3339Nano),
+	}
+	optional := map[string]string{
+		"key":      event.Key,
+		"topic":    event.Topic,
+		"checksum": event.Checksum,
+		"reason":   event.Reason,
+		"error":    event.Error,
+	}
+	for name, value := range optional {
+		if value != "" {
+			msg[name] = value
+		}
+	}
+	return msg
+}
+
+// FromStoreResult - function to get the stored or store_failed event of a
+// write. The handle and topic are read from the object metadata.
+func FromStoreResult(result common.StoreResult) Event {
+	event := Event{
+		Type:     TypeStored,
+		Handle:   result.Key,
+		Key:      result.Key,
+		Topic:    result.Metadata[common.MetaTopic],
+		Size:     result.Size,
+		Checksum: result.Checksum,
+	}
+	if handle, ok := result.Metadata[common.MetaImageHandle]; ok {
+		event.Handle = handle
+	}
+	if result.Err != nil {
+		event.Type = TypeStoreFailed
+		event.Error = result.Err.Error()
+	}
+	return event
+}
+
+// Sink publishes the messages, e.g. an eiimsgbus.Publisher
+type Sink interface {
+	Publish(msg interface{}) error
+}
+
+// Publisher sends the events to the sink from its own goroutine, so a slow
+// message bus does not delay the writes. The events emitted while the
+// buffer is full are dropped.
+type Publisher struct {
+	sink   Sink
+	events chan Event
+	done   chan struct{}
+	// Closes the message bus connection of the sink, may be nil
+	closeSink func()
+}
+
+// NewPublisher - function to create a publisher and start its goroutine.
+//
+// Parameters:
+// 1. sink : Sink
+//    Refers to the destination of the events.
+// 2. bufferSize : int
+//    Refers to the number of events buffered until they are published.
+//
+// Returns:
+// 1. *Publisher
+//    Returns the publisher, closed by Close.
+func NewPublisher(sink Sink, bufferSize int) *Publisher {
+	publisher := &Publisher{
+		sink:   sink,
+		events: make(chan Event, bufferSize),
+		done:   make(chan struct{}),
+	}
+	go publisher.run()
+	return publisher
+}
+
+// Dial - function to create a publisher sending the events to a topic of
+// the message bus. Close also closes the message bus connection.
+//
+// Parameters:
+// 1. config : map[string]interface{}
+//    Refers to the message bus config of the publisher interface.
+// 2. topic : string
+//    Refers to the topic the events are published on.
+// 3. bufferSize : int
+//    Refers to the number of events buffered until they are published.
+//
+// Returns:
+// 1. *Publisher
+//    Returns the publisher, closed by Close.
+// 2. error
+//    Returns an error object if connecting to the message bus fails.
+func Dial(config map[string]interface{}, topic string, bufferSize int) (*Publisher, error) {
+	client, err := eiimsgbus.NewMsgbusClient(config)
+	if err != nil {
+		return nil, fmt.Errorf("initializing events message bus context: %v", err)
+	}
+	sink, err := client.NewPublisher(topic)
+	if err != nil {
+		client.Close()
+		return nil, fmt.Errorf("creating events publisher for topic %s: %v", topic, err)
+	}
+	publisher := NewPublisher(sink, bufferSize)
+	publisher.closeSink = func() {
+		sink.Close()
+		client.Close()
+	}
+	return publisher, nil
+}
+
+// Emit - function to queue an event for publishing, without blocking
+func (publisher *Publisher) Emit(event Event) {
+	if event.Timestamp.IsZero() {
+		event.Timestamp = time.Now()
+	}
+	select {
+	case publisher.events <- event:
+	default:
+		metrics.Add("events_dropped", 1)
+	}
+}
+
+// Close - function to publish the queued events and stop the goroutine
+func (publisher *Publisher) Close() {
+	close(publisher.events)
+	<-publisher.done
+	if publisher.closeSink != nil {
+		publisher.closeSink()
+	}
+}
+
+func (publisher *Publisher) run() {
+	defer close(publisher.done)
+	for event := range publisher.events {
+		if err := publisher.sink.Publish(event.toMap()); err != nil {
+			glog.Errorf("Failed to publish %s event of %s: %v", event.Type, event.Handle, err)
+			metrics.Add("events_publish_errors", 1)
+			continue
+		}
+		metrics.Add("events_published", 1)
+	}
+}
@@ -0,0 +1,67 @@
+/*
+Copyright (c) 2021 Intel Corporation
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package events
+
+import (
+	"testing"
+	"time"
+)
+
+type recordingSink struct {
+	msgs []map[string]interface{}
+}
+
+func (sink *recordingSink) Publish(msg interface{}) error {
+	sink.msgs = append(sink.msgs, msg.(map[string]interface{}))
+	return nil
+}
+
+func TestPublisher(t *testing.T) {
+	sink := &recordingSink{}
+	publisher := NewPublisher(sink, 10)
+	// The message bus connection is closed once the queued events are sent
+	publishedAtClose := -1
+	publisher.closeSink = func() { publishedAtClose = len(sink.msgs) }
+	stored := time.Date(2021, 3, 4, 5, 6, 7, 0, time.UTC)
+	publisher.Emit(Event{Type: TypeStored, Handle: "h1", Topic: "camera1", Size: 3, Checksum: "abc", Timestamp: stored})
+	publisher.Emit(Event{Type: TypeDeleted, Handle: "h2", Reason: "expired"})
+	publisher.Close()
+
+	if len(sink.msgs) != 2 {
+		t.Fatalf("got %d messages, want 2", len(sink.msgs))
+	}
+	if publishedAtClose != 2 {
+		t.Errorf("connection closed after %d messages, want 2", publishedAtClose)
+	}
+	msg := sink.msgs[0]
+	if msg["type"] != TypeStored || msg["img_handle"] != "h1" || msg["topic"] != "camera1" ||
+		msg["size"] != int64(3) || msg["checksum"] != "abc" || msg["timestamp"] != "2021-03-04T05:06:07Z" {
+		t.Errorf("unexpected stored event %v", msg)
+	}
+	if _, ok := msg["reason"]; ok {
+		t.Errorf("stored event has a reason: %v", msg)
+	}
+	if msg = sink.msgs[1]; msg["reason"] != "expired" || msg["timestamp"] == "" {
+		t.Errorf("unexpected deleted event %v", msg)
+	}
+}
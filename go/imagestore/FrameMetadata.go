@@ -0,0 +1,125 @@
+/*
+Copyright (c) 2021 Intel Corporation
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package imagestore
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode/utf16"
+)
+
+// maxFrameMetadataSize bounds the encoded frame metadata, S3 limits the user
+// metadata of an object to 2 KB
+const maxFrameMetadataSize = 1536
+
+// EncodeFrameMetadata encodes the metadata of a frame as ASCII only JSON to
+// be saved in the object metadata. Metadata too large is truncated, arrays
+// are first shortened to their first element, which keeps them non-empty,
+// then the largest fields are dropped.
+//
+// Parameters:
+// 1. frameMetadata : map[string]interface{}
+//    Refers to the metadata the frame was published with.
+//
+// Returns:
+// 1. string
+//    Returns the encoded metadata.
+// 2. bool
+//    Returns whether the metadata was truncated.
+// 3. error
+//    Returns an error object if the metadata can not be encoded.
+func EncodeFrameMetadata(frameMetadata map[string]interface{}) (string, bool, error) {
+	encoded, err := encodeASCII(frameMetadata)
+	if err != nil || len(encoded) <= maxFrameMetadataSize {
+		return encoded, false, err
+	}
+
+	fields := make(map[string]interface{}, len(frameMetadata))
+	for name, value := range frameMetadata {
+		if array, ok := value.([]interface{}); ok && len(array) > 1 {
+			value = array[:1]
+		}
+		fields[name] = value
+	}
+	encoded, _ = encodeASCII(fields)
+
+	names := make([]string, 0, len(fields))
+	sizes := make(map[string]int, len(fields))
+	for name, value := range fields {
+		field, _ := encodeASCII(value)
+		names = append(names, name)
+		sizes[name] = len(field)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if sizes[names[i]] != sizes[names[j]] {
+			return sizes[names[i]] > sizes[names[j]]
+		}
+		return names[i] < names[j]
+	})
+	for _, name := range names {
+		if len(encoded) <= maxFrameMetadataSize {
+			break
+		}
+		delete(fields, name)
+		encoded, _ = encodeASCII(fields)
+	}
+	return encoded, true, nil
+}
+
+// DecodeFrameMetadata decodes frame metadata saved by EncodeFrameMetadata,
+// returning nil if it is missing or invalid
+func DecodeFrameMetadata(encoded string) map[string]interface{} {
+	if encoded == "" {
+		return nil
+	}
+	var frameMetadata map[string]interface{}
+	if err := json.Unmarshal([]byte(encoded), &frameMetadata); err != nil {
+		return nil
+	}
+	return frameMetadata
+}
+
+// encodeASCII encodes value as JSON, escaping the non ASCII characters so
+// that it can be sent as an HTTP header
+func encodeASCII(value interface{}) (string, error) {
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+
+	var ascii strings.Builder
+	for _, r := range string(encoded) {
+		if r < 0x80 {
+			ascii.WriteRune(r)
+			continue
+		}
+		if r1, r2 := utf16.EncodeRune(r); r1 != 0xFFFD {
+			fmt.Fprintf(&ascii, "\\u%04x\\u%04x", r1, r2)
+		} else {
+			fmt.Fprintf(&ascii, "\\u%04x", r)
+		}
+	}
+	return ascii.String(), nil
+}
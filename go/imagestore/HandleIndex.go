@@ -0,0 +1,123 @@
+/*
+Copyright (c) 2021 Intel Corporation
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package imagestore
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// HandleIndex maps the image handles the frames were published with to the
+// object keys they are stored under, when a key template is applied. The
+// same handle may be published on several topics, or rendered to several
+// keys, so every key of a handle is kept along with its topic. It is kept in
+// memory and rebuilt from the object metadata at startup.
+type HandleIndex struct {
+	mutex   sync.RWMutex
+	keys    map[string]map[string]string
+	handles map[string]string
+}
+
+// NewHandleIndex - function to initialize a new HandleIndex
+func NewHandleIndex() *HandleIndex {
+	return &HandleIndex{keys: make(map[string]map[string]string), handles: make(map[string]string)}
+}
+
+// Set records that the image of the given handle, received on topic, is
+// stored under keyname
+func (index *HandleIndex) Set(handle string, keyname string, topic string) {
+	index.mutex.Lock()
+	defer index.mutex.Unlock()
+	index.forget(keyname)
+	if index.keys[handle] == nil {
+		index.keys[handle] = make(map[string]string)
+	}
+	index.keys[handle][keyname] = topic
+	index.handles[keyname] = handle
+}
+
+// Resolve returns the key the image of the given handle is stored under, or
+// the handle itself if it is a key or not in the index. A non empty topic
+// only considers the keys of the images received on it. A handle stored
+// under several keys is ambiguous and fails.
+func (index *HandleIndex) Resolve(handle string, topic string) (string, error) {
+	index.mutex.RLock()
+	defer index.mutex.RUnlock()
+	if _, ok := index.handles[handle]; ok {
+		return handle, nil
+	}
+	matches := make([]string, 0, 1)
+	for keyname, keyTopic := range index.keys[handle] {
+		if topic == "" || keyTopic == topic {
+			matches = append(matches, keyname)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return handle, nil
+	case 1:
+		return matches[0], nil
+	}
+	sort.Strings(matches)
+	return "", fmt.Errorf("ambiguous handle %s stored under %s, use the full key or a topic",
+		handle, strings.Join(matches, ", "))
+}
+
+// HandleOf returns the handle the image stored under the given key was
+// published with, or the key itself if it is not in the index
+func (index *HandleIndex) HandleOf(keyname string) string {
+	index.mutex.RLock()
+	defer index.mutex.RUnlock()
+	if handle, ok := index.handles[keyname]; ok {
+		return handle
+	}
+	return keyname
+}
+
+// Forget drops the entry of a removed image
+func (index *HandleIndex) Forget(keyname string) {
+	index.mutex.Lock()
+	defer index.mutex.Unlock()
+	index.forget(keyname)
+}
+
+func (index *HandleIndex) forget(keyname string) {
+	handle, ok := index.handles[keyname]
+	if !ok {
+		return
+	}
+	delete(index.handles, keyname)
+	delete(index.keys[handle], keyname)
+	if len(index.keys[handle]) == 0 {
+		delete(index.keys, handle)
+	}
+}
+
+// Len returns the number of images in the index
+func (index *HandleIndex) Len() int {
+	index.mutex.RLock()
+	defer index.mutex.RUnlock()
+	return len(index.handles)
+}
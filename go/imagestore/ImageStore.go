@@ -24,8 +24,17 @@ SOFTWARE.
 package imagestore
 
 import (
+	archive "IEdgeInsights/ImageStore/go/imagestore/archive"
+	hashindex "IEdgeInsights/ImageStore/go/imagestore/hashindex"
+	imaging "IEdgeInsights/ImageStore/go/imagestore/imaging"
 	persistent "IEdgeInsights/ImageStore/go/imagestore/persistent"
+	spool "IEdgeInsights/ImageStore/spool"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
+	"strconv"
+	"time"
 	"github.com/golang/glog"
 	common "IEdgeInsights/ImageStore/common"
 )
@@ -34,6 +43,13 @@ import (
 type ImageStore struct {
 	storageType       string
 	persistentStorage *(persistent.Persistent)
+	policy            *imaging.Policy
+	topic             string
+	index             *hashindex.Index
+	tracker           *ReadTracker
+	gate              func() error
+	archive           archive.Archive
+	handles           *HandleIndex
 }
 
 // NewImageStore : This is the Constructor type method which initialises the Object for ImageStore Operations
@@ -81,6 +97,272 @@ func GetImageStoreInstance(persistCfg map[string]string) (*ImageStore, error) {
 	return &ImageStore{storageType: "", persistentStorage: persistentStorage}, nil
 }
 
+// NewImageStoreWithStorage is the constructor type method which initialises
+// the Object for ImageStore Operations on an existing storage.
+//
+// Parameters:
+// 1. persistentStorage : *persistent.Persistent
+//    Refers to the storage the images are kept in.
+//
+// Returns:
+// 1. *ImageStore
+//    Returns the ImageStore instance
+func NewImageStoreWithStorage(persistentStorage *persistent.Persistent) *ImageStore {
+	return &ImageStore{storageType: "", persistentStorage: persistentStorage}
+}
+
+// Reconfigure is used to replace the storage clients of every ImageStore
+// instance, e.g. when the credentials changed. The stores are paused, not
+// dropped, while the storage server is restarted.
+//
+// Parameters:
+// 1. persistCfg : map[string]string
+//    Refers to the new persistent config.
+// 2. restart : func() error
+//    Refers to the function restarting the storage server with the new
+//    config, returning once it is up.
+//
+// Returns:
+// 1. error
+//    Returns an error object if reconfiguring fails.
+func Reconfigure(persistCfg map[string]string, restart func() error) error {
+	return persistent.Reconfigure(persistCfg, restart)
+}
+
+// SetStoreListener is used to be notified of the outcome of the writes of
+// every ImageStore instance, as Store returns before the image is written.
+//
+// Parameters:
+// 1. listener : func(common.StoreResult)
+//    Refers to the function called after every write, nil to stop the
+//    notifications.
+func SetStoreListener(listener func(common.StoreResult)) {
+	persistent.SetStoreListener(listener)
+}
+
+// SetSpool is used to keep the writes of every ImageStore instance which
+// failed in a local spool, replayed with backoff once the storage recovers.
+//
+// Parameters:
+// 1. walSpool : *spool.Spool
+//    Refers to the spool the failed writes are kept in.
+// 2. minBackoff : time.Duration
+//    Refers to the wait after the first failed replay.
+// 3. maxBackoff : time.Duration
+//    Refers to the longest wait between replays.
+func SetSpool(walSpool *spool.Spool, minBackoff time.Duration, maxBackoff time.Duration) {
+	persistent.SetSpool(walSpool, minBackoff, maxBackoff)
+}
+
+// SetPolicy sets the policy every buffer is validated against before it is
+// stored. A nil policy accepts any buffer.
+//
+// Parameters:
+// 1. policy : *imaging.Policy
+//    Refers to the validation policy of the topic served by this instance.
+func (pImageStore *ImageStore) SetPolicy(policy *imaging.Policy) {
+	pImageStore.policy = policy
+}
+
+// SetTopic sets the topic saved in the metadata of every stored buffer.
+//
+// Parameters:
+// 1. topic : string
+//    Refers to the topic served by this instance.
+func (pImageStore *ImageStore) SetTopic(topic string) {
+	pImageStore.topic = topic
+}
+
+// SetIndex sets the index the perceptual hashes of every stored image are
+// added to, once the image is written and reported to IndexStored. A nil
+// index disables hashing.
+//
+// Parameters:
+// 1. index : *hashindex.Index
+//    Refers to the index shared by all ImageStore instances.
+func (pImageStore *ImageStore) SetIndex(index *hashindex.Index) {
+	pImageStore.index = index
+}
+
+// SetReadTracker sets the tracker recording the reads of this instance, used
+// for least recently read eviction.
+//
+// Parameters:
+// 1. tracker : *ReadTracker
+//    Refers to the tracker shared with the retention policy.
+func (pImageStore *ImageStore) SetReadTracker(tracker *ReadTracker) {
+	pImageStore.tracker = tracker
+}
+
+// SetHandleIndex sets the index resolving the image handles of the frames
+// stored under a key template to their keys.
+//
+// Parameters:
+// 1. handles : *HandleIndex
+//    Refers to the index shared by all ImageStore instances.
+func (pImageStore *ImageStore) SetHandleIndex(handles *HandleIndex) {
+	pImageStore.handles = handles
+}
+
+// Resolve is used to get the key the image of the given handle is stored
+// under, when a key template is applied.
+//
+// Parameters:
+// 1. keyname : string
+//    Refers to the image handle or the key of the image.
+// 2. topic : string
+//    Refers to the topic the image was received on, may be empty.
+//
+// Returns:
+// 1. string
+//    Returns the key of the image, keyname if it is not a templated handle.
+// 2. error
+//    Returns an error object if the handle is stored under several keys.
+func (pImageStore *ImageStore) Resolve(keyname string, topic string) (string, error) {
+	if pImageStore.handles == nil {
+		return keyname, nil
+	}
+	return pImageStore.handles.Resolve(keyname, topic)
+}
+
+// SetStoreGate sets the function deciding whether stores are currently
+// accepted, used to shed low priority topics under disk pressure.
+//
+// Parameters:
+// 1. gate : func() error
+//    Refers to the function returning an error to reject a store.
+func (pImageStore *ImageStore) SetStoreGate(gate func() error) {
+	pImageStore.gate = gate
+}
+
+// SetArchive sets the archive tier the read falls back to when the image is
+// no longer in the hot tier.
+//
+// Parameters:
+// 1. archive : archive.Archive
+//    Refers to the archive tier the retention policy moves images to.
+func (pImageStore *ImageStore) SetArchive(archive archive.Archive) {
+	pImageStore.archive = archive
+}
+
+// RebuildIndex adds every stored image carrying perceptual hashes in its
+// metadata to the index. Used at startup, as the index is kept in memory.
+//
+// Returns:
+// 1. error
+//    Returns an error object if listing the stored images fails.
+func (pImageStore *ImageStore) RebuildIndex() error {
+	if pImageStore.index == nil {
+		return nil
+	}
+
+	doneCh := make(chan struct{})
+	defer close(doneCh)
+	for obj := range pImageStore.persistentStorage.List("", doneCh) {
+		if obj.Err != nil {
+			return obj.Err
+		}
+		info, err := pImageStore.persistentStorage.Stat(obj.Key)
+		if err != nil {
+			glog.V(1).Infof("Failed to stat %s while rebuilding the index: %v", obj.Key, err)
+			continue
+		}
+		hashes, ok := parseHashes(info.Metadata)
+		if !ok {
+			continue
+		}
+		pImageStore.index.Add(hashindex.Entry{
+			Key:    info.Key,
+			Topic:  info.Metadata[common.MetaTopic],
+			Stored: info.LastModified,
+			Hashes: hashes,
+		})
+	}
+	glog.Infof("Perceptual hash index rebuilt with %d images", pImageStore.index.Len())
+	return nil
+}
+
+// RebuildHandleIndex is used to fill the handle index from the object
+// metadata of the stored images and of the archived ones, e.g. at startup.
+//
+// Returns:
+// 1. error
+//    Returns an error object if listing the images fails.
+func (pImageStore *ImageStore) RebuildHandleIndex() error {
+	if pImageStore.handles == nil {
+		return nil
+	}
+
+	doneCh := make(chan struct{})
+	defer close(doneCh)
+	for obj := range pImageStore.persistentStorage.List("", doneCh) {
+		if obj.Err != nil {
+			return obj.Err
+		}
+		info, err := pImageStore.persistentStorage.Stat(obj.Key)
+		if err != nil {
+			glog.V(1).Infof("Failed to stat %s while rebuilding the handle index: %v", obj.Key, err)
+			continue
+		}
+		if handle, ok := info.Metadata[common.MetaImageHandle]; ok {
+			pImageStore.handles.Set(handle, info.Key, info.Metadata[common.MetaTopic])
+		}
+	}
+	if pImageStore.archive != nil {
+		err := pImageStore.archive.Walk(func(key string, metadata map[string]string) {
+			if handle, ok := metadata[common.MetaImageHandle]; ok {
+				pImageStore.handles.Set(handle, key, metadata[common.MetaTopic])
+			}
+		})
+		if err != nil {
+			return err
+		}
+	}
+	glog.Infof("Handle index rebuilt with %d images", pImageStore.handles.Len())
+	return nil
+}
+
+// IndexStored is used to add a written image to the index if perceptual
+// hashes were computed for it. Called from the store listener, so the
+// images failing to be written are never found by the similarity queries.
+//
+// Parameters:
+// 1. index : *hashindex.Index
+//    Refers to the index shared by all ImageStore instances.
+// 2. result : common.StoreResult
+//    Refers to the outcome of the write reported to the store listener.
+func IndexStored(index *hashindex.Index, result common.StoreResult) {
+	if result.Err != nil {
+		return
+	}
+	hashes, ok := parseHashes(result.Metadata)
+	if !ok {
+		return
+	}
+	index.Add(hashindex.Entry{
+		Key:    result.Key,
+		Topic:  result.Metadata[common.MetaTopic],
+		Stored: time.Now(),
+		Hashes: hashes,
+	})
+}
+
+// parseHashes reads the perceptual hashes saved in the object metadata
+func parseHashes(metadata map[string]string) (imaging.Hashes, bool) {
+	var hashes imaging.Hashes
+	var err error
+	if hashes.AHash, err = strconv.ParseUint(metadata[common.MetaAHash], 16, 64); err != nil {
+		return hashes, false
+	}
+	if hashes.DHash, err = strconv.ParseUint(metadata[common.MetaDHash], 16, 64); err != nil {
+		return hashes, false
+	}
+	if hashes.PHash, err = strconv.ParseUint(metadata[common.MetaPHash], 16, 64); err != nil {
+		return hashes, false
+	}
+	return hashes, true
+}
+
 // Read is used to read the stored data from memory.
 //
 // Parameters:
@@ -93,7 +375,31 @@ func GetImageStoreInstance(persistCfg map[string]string) (*ImageStore, error) {
 // 2. error
 //    Returns an error object if read fails.
 func (pImageStore *ImageStore) Read(keyname string) (io.ReadCloser, error) {
-	return pImageStore.persistentStorage.Read(keyname)
+	keyname, err := pImageStore.Resolve(keyname, "")
+	if err != nil {
+		return nil, err
+	}
+	if pImageStore.tracker != nil {
+		pImageStore.tracker.Touch(keyname)
+	}
+	reader, err := pImageStore.persistentStorage.Read(keyname)
+	if pImageStore.archive == nil {
+		return reader, err
+	}
+
+	// The read of the hot tier is lazy, the stat tells whether the image
+	// was moved to the archive tier
+	if err == nil {
+		if _, err = pImageStore.persistentStorage.Stat(keyname); err == nil {
+			return reader, nil
+		}
+		reader.Close()
+	}
+	archived, archiveErr := pImageStore.archive.Read(keyname)
+	if archiveErr != nil {
+		return nil, err
+	}
+	return archived, nil
 }
 
 // Remove is used to remove the stored data from memory.
@@ -106,6 +412,16 @@ func (pImageStore *ImageStore) Read(keyname string) (io.ReadCloser, error) {
 // 1. error
 //    Returns an error object if remove fails.
 func (pImageStore *ImageStore) Remove(keyname string) error {
+	keyname, err := pImageStore.Resolve(keyname, "")
+	if err != nil {
+		return err
+	}
+	if pImageStore.handles != nil {
+		pImageStore.handles.Forget(keyname)
+	}
+	if pImageStore.index != nil {
+		pImageStore.index.Remove(keyname)
+	}
 	return pImageStore.persistentStorage.Remove(keyname)
 }
 
@@ -121,5 +437,336 @@ func (pImageStore *ImageStore) Remove(keyname string) error {
 // 2. error
 //    Returns an error object if store fails.
 func (pImageStore *ImageStore) Store(value []byte, keyname string) (string, error) {
-	return pImageStore.persistentStorage.Store(value, keyname)
+	return pImageStore.StoreFrame(value, keyname, nil, time.Time{})
+}
+
+// StoreFrame is used to store the data along with the metadata the frame
+// was published with, which is saved in the object metadata.
+//
+// Parameters:
+// 1. value : []byte
+//    Refers to the image buffer to be stored in ImageStore.
+// 2. keyname : string
+//    Refers to the image handle of the image.
+// 3. frameMetadata : map[string]interface{}
+//    Refers to the metadata of the frame, may be nil.
+// 4. captured : time.Time
+//    Refers to the capture time of the frame, saved in the object metadata
+//    and used as the age of the frame by the retention. Zero if unknown.
+//
+// Returns:
+// 1. string
+//    Returns the image handle of the image stored.
+// 2. error
+//    Returns an error object if store fails.
+func (pImageStore *ImageStore) StoreFrame(value []byte, keyname string, frameMetadata map[string]interface{}, captured time.Time) (string, error) {
+	return pImageStore.storeFrame(value, keyname, frameMetadata, captured, nil, false)
+}
+
+// StoreSync is used to store the data like Store, but returns only once the
+// image is durably written, with the error of the write if it failed.
+//
+// Parameters:
+// 1. value : []byte
+//    Refers to the image buffer to be stored in ImageStore.
+// 2. keyname : string
+//    Refers to the image handle of the image.
+//
+// Returns:
+// 1. string
+//    Returns the image handle of the image stored.
+// 2. error
+//    Returns an error object if store or the write fails.
+func (pImageStore *ImageStore) StoreSync(value []byte, keyname string) (string, error) {
+	return pImageStore.storeFrame(value, keyname, nil, time.Time{}, nil, true)
+}
+
+// StoreTopic is used to store the data received on the given topic, which is
+// saved in the object metadata instead of the topic of this instance.
+//
+// Parameters:
+// 1. value : []byte
+//    Refers to the image buffer to be stored in ImageStore.
+// 2. keyname : string
+//    Refers to the image handle of the image.
+// 3. topic : string
+//    Refers to the topic the image was received on, may be empty.
+// 4. sync : bool
+//    Refers to whether to return only once the image is durably written.
+//
+// Returns:
+// 1. string
+//    Returns the image handle of the image stored.
+// 2. error
+//    Returns an error object if store fails, or the write if sync is set.
+func (pImageStore *ImageStore) StoreTopic(value []byte, keyname string, topic string, sync bool) (string, error) {
+	var extra map[string]string
+	if topic != "" {
+		extra = map[string]string{common.MetaTopic: topic}
+	}
+	return pImageStore.storeFrame(value, keyname, nil, time.Time{}, extra, sync)
+}
+
+// StoreFrames is used to store every blob of a multi-blob frame, e.g. the
+// raw and annotated images, along with the metadata the frame was published
+// with. The keys of all the blobs are saved in the object metadata of the
+// first one, the parent.
+//
+// Parameters:
+// 1. values : [][]byte
+//    Refers to the image buffers to be stored in ImageStore.
+// 2. keynames : []string
+//    Refers to the keys the images are stored under, the parent first.
+// 3. handles : []string
+//    Refers to the image handles the images were published with, saved in
+//    the object metadata and the handle index when they differ from the
+//    keys, e.g. when a key template is applied. May be nil.
+// 4. frameMetadata : map[string]interface{}
+//    Refers to the metadata of the frame, may be nil.
+// 5. captured : time.Time
+//    Refers to the capture time of the frame, zero if unknown.
+//
+// Returns:
+// 1. []string
+//    Returns the image handles of the images stored.
+// 2. error
+//    Returns an error object if storing any of the images fails.
+func (pImageStore *ImageStore) StoreFrames(values [][]byte, keynames []string, handles []string, frameMetadata map[string]interface{}, captured time.Time) ([]string, error) {
+	if len(values) == 0 || len(values) != len(keynames) || (handles != nil && len(handles) != len(keynames)) {
+		return nil, errors.New("need one image handle for every blob")
+	}
+
+	// The remaining blobs are stored even if one of them fails, but not if
+	// the parent fails
+	// The keys may contain commas, e.g. from the frame metadata of a key
+	// template
+	blobHandles, err := json.Marshal(keynames)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]string, 0, len(values))
+	var firstErr error
+	for i := range values {
+		extra := make(map[string]string)
+		if i == 0 && len(keynames) > 1 {
+			extra[common.MetaBlobHandles] = string(blobHandles)
+		}
+		if handles != nil && handles[i] != keynames[i] {
+			extra[common.MetaImageHandle] = handles[i]
+		}
+
+		key, err := pImageStore.storeFrame(values[i], keynames[i], frameMetadata, captured, extra, false)
+		if i == 0 && err != nil {
+			return nil, err
+		}
+		if err != nil {
+			glog.Errorf("Failed to store blob %d of %s: %v", i, keynames[0], err)
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		if pImageStore.handles != nil && extra[common.MetaImageHandle] != "" {
+			pImageStore.handles.Set(handles[i], key, pImageStore.topic)
+		}
+		keys = append(keys, key)
+	}
+	return keys, firstErr
+}
+
+// BlobHandles is used to get the image handles of all the blobs of a frame
+// stored by StoreFrames.
+//
+// Parameters:
+// 1. keyname : string
+//    Refers to the image handle of the parent image.
+//
+// Returns:
+// 1. []string
+//    Returns the image handles, the parent first, or nil if the frame had a
+//    single blob.
+// 2. error
+//    Returns an error object if the image does not exist.
+func (pImageStore *ImageStore) BlobHandles(keyname string) ([]string, error) {
+	key, err := pImageStore.Resolve(keyname, "")
+	if err != nil {
+		return nil, err
+	}
+	info, err := pImageStore.persistentStorage.Stat(key)
+	if err != nil {
+		return nil, err
+	}
+	encoded, ok := info.Metadata[common.MetaBlobHandles]
+	if !ok {
+		return nil, nil
+	}
+	var handles []string
+	if err := json.Unmarshal([]byte(encoded), &handles); err != nil {
+		return nil, fmt.Errorf("invalid blob handles of %s: %v", keyname, err)
+	}
+	return handles, nil
+}
+
+// storeFrame stores a blob with the given extra object metadata, may be nil,
+// waiting for the write to finish if sync is set. A topic in the extra
+// metadata replaces the topic of this instance.
+func (pImageStore *ImageStore) storeFrame(value []byte, keyname string, frameMetadata map[string]interface{}, captured time.Time, extra map[string]string, sync bool) (string, error) {
+	if pImageStore.gate != nil {
+		if err := pImageStore.gate(); err != nil {
+			return "", err
+		}
+	}
+	if pImageStore.policy != nil {
+		if err := pImageStore.policy.Validate(value); err != nil {
+			return "", err
+		}
+	}
+
+	metadata := make(map[string]string)
+	if pImageStore.topic != "" {
+		metadata[common.MetaTopic] = pImageStore.topic
+	}
+	for metaKey, metaValue := range extra {
+		metadata[metaKey] = metaValue
+	}
+	if !captured.IsZero() {
+		metadata[common.MetaCaptured] = captured.UTC().Format(time.RFC3339Nano)
+	}
+	if len(frameMetadata) > 0 {
+		encoded, truncated, err := EncodeFrameMetadata(frameMetadata)
+		if err != nil {
+			glog.Errorf("Failed to encode the frame metadata of %s: %v", keyname, err)
+		} else {
+			metadata[common.MetaFrameMetadata] = encoded
+			if truncated {
+				glog.V(1).Infof("Frame metadata of %s truncated to %d bytes", keyname, maxFrameMetadataSize)
+				metadata[common.MetaFrameMetadataTruncated] = "true"
+			}
+		}
+	}
+
+	if pImageStore.index != nil && imaging.DetectContentType(value) != imaging.ContentTypeRaw {
+		hashes, err := imaging.ComputeHashes(value)
+		if err != nil {
+			glog.Errorf("Failed to compute perceptual hashes for %s: %v", keyname, err)
+		} else {
+			metadata[common.MetaAHash] = strconv.FormatUint(hashes.AHash, 16)
+			metadata[common.MetaDHash] = strconv.FormatUint(hashes.DHash, 16)
+			metadata[common.MetaPHash] = strconv.FormatUint(hashes.PHash, 16)
+		}
+	}
+
+	var key string
+	var err error
+	if sync {
+		key, err = pImageStore.persistentStorage.StoreWithMetadataSync(value, keyname, metadata)
+	} else {
+		key, err = pImageStore.persistentStorage.StoreWithMetadata(value, keyname, metadata)
+	}
+	if err != nil {
+		return "", err
+	}
+	return key, nil
+}
+
+// Stat is used to get the details and metadata of the stored data.
+//
+// Parameters:
+// 1. keyname : string
+//    Refers to the image handle of the image.
+//
+// Returns:
+// 1. common.ObjectInfo
+//    Returns the details of the image.
+// 2. error
+//    Returns an error object if the image does not exist.
+func (pImageStore *ImageStore) Stat(keyname string) (common.ObjectInfo, error) {
+	key, err := pImageStore.Resolve(keyname, "")
+	if err != nil {
+		return common.ObjectInfo{}, err
+	}
+	return pImageStore.persistentStorage.Stat(key)
+}
+
+// Pin is used to exempt the stored data from retention, e.g. to keep it as
+// evidence.
+//
+// Parameters:
+// 1. keyname : string
+//    Refers to the image handle of the image to be pinned.
+// 2. reason : string
+//    Refers to why the image is pinned, may be empty.
+//
+// Returns:
+// 1. error
+//    Returns an error object if pinning fails.
+func (pImageStore *ImageStore) Pin(keyname string, reason string) error {
+	keyname, err := pImageStore.Resolve(keyname, "")
+	if err != nil {
+		return err
+	}
+	info, err := pImageStore.persistentStorage.Stat(keyname)
+	if err != nil {
+		return err
+	}
+	info.Metadata[common.MetaPinned] = "true"
+	if reason != "" {
+		info.Metadata[common.MetaPinReason] = reason
+	}
+	return pImageStore.persistentStorage.SetMetadata(keyname, info.Metadata)
+}
+
+// Unpin is used to make pinned data subject to retention again.
+//
+// Parameters:
+// 1. keyname : string
+//    Refers to the image handle of the image to be unpinned.
+//
+// Returns:
+// 1. error
+//    Returns an error object if unpinning fails.
+func (pImageStore *ImageStore) Unpin(keyname string) error {
+	keyname, err := pImageStore.Resolve(keyname, "")
+	if err != nil {
+		return err
+	}
+	info, err := pImageStore.persistentStorage.Stat(keyname)
+	if err != nil {
+		return err
+	}
+	if info.Metadata[common.MetaPinned] != "true" {
+		return nil
+	}
+	delete(info.Metadata, common.MetaPinned)
+	delete(info.Metadata, common.MetaPinReason)
+	return pImageStore.persistentStorage.SetMetadata(keyname, info.Metadata)
+}
+
+// ListPinned is used to list the pinned data. Every stored image is looked
+// up, so this is meant for occasional queries only.
+//
+// Returns:
+// 1. []common.ObjectInfo
+//    Returns the details of the pinned images.
+// 2. error
+//    Returns an error object if listing fails.
+func (pImageStore *ImageStore) ListPinned() ([]common.ObjectInfo, error) {
+	doneCh := make(chan struct{})
+	defer close(doneCh)
+
+	pinned := make([]common.ObjectInfo, 0)
+	for obj := range pImageStore.persistentStorage.List("", doneCh) {
+		if obj.Err != nil {
+			return nil, obj.Err
+		}
+		info, err := pImageStore.persistentStorage.Stat(obj.Key)
+		if err != nil {
+			continue
+		}
+		if info.Metadata[common.MetaPinned] == "true" {
+			pinned = append(pinned, info)
+		}
+	}
+	return pinned, nil
 }
@@ -0,0 +1,281 @@
+/*
+Copyright (c) 2021 Intel Corporation
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package imagestore
+
+import (
+	common "IEdgeInsights/ImageStore/common"
+	persistent "IEdgeInsights/ImageStore/go/imagestore/persistent"
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	minio "github.com/minio/minio-go"
+)
+
+// memoryStorage is an in-memory persistent.Storage failing the writes of
+// the rejected keys
+type memoryStorage struct {
+	mutex    sync.Mutex
+	objects  map[string]common.ObjectInfo
+	data     map[string][]byte
+	rejected map[string]bool
+}
+
+func newMemoryStorage() *memoryStorage {
+	return &memoryStorage{
+		objects:  make(map[string]common.ObjectInfo),
+		data:     make(map[string][]byte),
+		rejected: make(map[string]bool),
+	}
+}
+
+func (storage *memoryStorage) Read(keyname string) (io.ReadCloser, error) {
+	storage.mutex.Lock()
+	defer storage.mutex.Unlock()
+	data, ok := storage.data[keyname]
+	if !ok {
+		return nil, errors.New("no such key: " + keyname)
+	}
+	return ioutil.NopCloser(bytes.NewReader(data)), nil
+}
+
+func (storage *memoryStorage) Remove(keyname string) error {
+	storage.mutex.Lock()
+	defer storage.mutex.Unlock()
+	delete(storage.objects, keyname)
+	delete(storage.data, keyname)
+	return nil
+}
+
+func (storage *memoryStorage) Store(data []byte, key string) (string, error) {
+	return storage.StoreWithMetadata(data, key, nil)
+}
+
+func (storage *memoryStorage) StoreWithMetadata(data []byte, key string, metadata map[string]string) (string, error) {
+	storage.mutex.Lock()
+	defer storage.mutex.Unlock()
+	if storage.rejected[key] {
+		return "", errors.New("rejected key: " + key)
+	}
+	storage.objects[key] = common.ObjectInfo{Key: key, Size: int64(len(data)), LastModified: time.Now(), Metadata: metadata}
+	storage.data[key] = data
+	return key, nil
+}
+
+func (storage *memoryStorage) Stat(keyname string) (common.ObjectInfo, error) {
+	storage.mutex.Lock()
+	defer storage.mutex.Unlock()
+	info, ok := storage.objects[keyname]
+	if !ok {
+		return info, errors.New("no such key: " + keyname)
+	}
+	return info, nil
+}
+
+func (storage *memoryStorage) SetMetadata(keyname string, metadata map[string]string) error {
+	storage.mutex.Lock()
+	defer storage.mutex.Unlock()
+	info, ok := storage.objects[keyname]
+	if !ok {
+		return errors.New("no such key: " + keyname)
+	}
+	info.Metadata = metadata
+	storage.objects[keyname] = info
+	return nil
+}
+
+func (storage *memoryStorage) List(prefix string, doneCh <-chan struct{}) <-chan common.ObjectInfo {
+	objectsCh := make(chan common.ObjectInfo)
+	close(objectsCh)
+	return objectsCh
+}
+
+// memoryArchive is an archive.Archive copying the objects of a memoryStorage
+type memoryArchive struct {
+	storage *memoryStorage
+	objects map[string]common.ObjectInfo
+	data    map[string][]byte
+}
+
+func (arch *memoryArchive) Put(key string) error {
+	info, err := arch.storage.Stat(key)
+	if err != nil {
+		return err
+	}
+	arch.objects[key] = info
+	arch.data[key] = arch.storage.data[key]
+	return nil
+}
+
+func (arch *memoryArchive) Read(key string) (io.ReadCloser, error) {
+	data, ok := arch.data[key]
+	if !ok {
+		return nil, errors.New("no such key: " + key)
+	}
+	return ioutil.NopCloser(bytes.NewReader(data)), nil
+}
+
+func (arch *memoryArchive) Expire(before time.Time, onRemove func(key string, size int64, archived time.Time)) (int64, int64, error) {
+	return 0, 0, nil
+}
+
+func (arch *memoryArchive) Walk(fn func(key string, metadata map[string]string)) error {
+	for key, info := range arch.objects {
+		fn(key, info.Metadata)
+	}
+	return nil
+}
+
+func (arch *memoryArchive) SetClient(client *minio.Client) {}
+
+func TestStoreFrames(t *testing.T) {
+	storage := newMemoryStorage()
+	store := NewImageStoreWithStorage(persistent.NewPersistentStorage(storage))
+	store.SetHandleIndex(NewHandleIndex())
+
+	// The keys rendered from the frame metadata may contain commas
+	keys := []string{"line 1,2/frame", "line 1,2/frame_1", "line 1,2/frame_2"}
+	handles := []string{"frame", "frame_1", "frame_2"}
+	blobs := [][]byte{[]byte("raw"), []byte("annotated"), []byte("mask")}
+	stored, err := store.StoreFrames(blobs, keys, handles, nil, time.Time{})
+	if err != nil || len(stored) != 3 {
+		t.Fatalf("StoreFrames() = %v, %v", stored, err)
+	}
+	blobHandles, err := store.BlobHandles("frame")
+	if err != nil || strings.Join(blobHandles, "|") != strings.Join(keys, "|") {
+		t.Errorf("BlobHandles() = %v, %v, expected %v", blobHandles, err, keys)
+	}
+
+	// The parent is required, the other blobs are stored on a best effort
+	storage.rejected["second_1"] = true
+	stored, err = store.StoreFrames(blobs, []string{"second", "second_1", "second_2"}, nil, nil, time.Time{})
+	if err == nil || strings.Join(stored, ",") != "second,second_2" {
+		t.Errorf("StoreFrames() with a failing blob = %v, %v", stored, err)
+	}
+	storage.rejected["third"] = true
+	if stored, err = store.StoreFrames(blobs, []string{"third", "third_1", "third_2"}, nil, nil, time.Time{}); err == nil || stored != nil {
+		t.Errorf("StoreFrames() with a failing parent = %v, %v", stored, err)
+	}
+	if _, err := storage.Stat("third_1"); err == nil {
+		t.Errorf("Blob of a failed parent stored")
+	}
+}
+
+func TestBlobHandles(t *testing.T) {
+	storage := newMemoryStorage()
+	store := NewImageStoreWithStorage(persistent.NewPersistentStorage(storage))
+	storage.StoreWithMetadata([]byte("raw"), "single", nil)
+	storage.StoreWithMetadata([]byte("raw"), "corrupt", map[string]string{common.MetaBlobHandles: "[\"corrupt\""})
+
+	if handles, err := store.BlobHandles("single"); err != nil || handles != nil {
+		t.Errorf("BlobHandles() of a single blob frame = %v, %v", handles, err)
+	}
+	if _, err := store.BlobHandles("corrupt"); err == nil {
+		t.Errorf("Invalid blob handles parsed")
+	}
+	if _, err := store.BlobHandles("missing"); err == nil {
+		t.Errorf("Blob handles of a missing frame found")
+	}
+}
+
+func TestReadArchivedHandle(t *testing.T) {
+	storage := newMemoryStorage()
+	arch := &memoryArchive{storage: storage, objects: make(map[string]common.ObjectInfo), data: make(map[string][]byte)}
+	store := NewImageStoreWithStorage(persistent.NewPersistentStorage(storage))
+	store.SetHandleIndex(NewHandleIndex())
+	store.SetArchive(arch)
+
+	if _, err := store.StoreFrames([][]byte{[]byte("raw")}, []string{"line1/frame"}, []string{"frame"}, nil, time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	// Moving the frame to the archive the way the retention policy does
+	if err := arch.Put("line1/frame"); err != nil {
+		t.Fatal(err)
+	}
+	storage.Remove("line1/frame")
+
+	expectRead := func() {
+		t.Helper()
+		reader, err := store.Read("frame")
+		if err != nil {
+			t.Fatalf("Read() of an archived handle failed: %v", err)
+		}
+		data, _ := ioutil.ReadAll(reader)
+		reader.Close()
+		if string(data) != "raw" {
+			t.Errorf("Read %q from the archive", data)
+		}
+	}
+	expectRead()
+
+	// The handles of the archived frames survive a restart
+	store.SetHandleIndex(NewHandleIndex())
+	if err := store.RebuildHandleIndex(); err != nil {
+		t.Fatal(err)
+	}
+	expectRead()
+}
+
+func TestAmbiguousHandle(t *testing.T) {
+	storage := newMemoryStorage()
+	handles := NewHandleIndex()
+	for _, topic := range []string{"camera1", "camera2"} {
+		store := NewImageStoreWithStorage(persistent.NewPersistentStorage(storage))
+		store.SetHandleIndex(handles)
+		store.SetTopic(topic)
+		if _, err := store.StoreFrames([][]byte{[]byte(topic)}, []string{topic + "/frame"}, []string{"frame"}, nil, time.Time{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	store := NewImageStoreWithStorage(persistent.NewPersistentStorage(storage))
+	store.SetHandleIndex(handles)
+
+	if _, err := store.Read("frame"); err == nil || !strings.Contains(err.Error(), "ambiguous handle") {
+		t.Errorf("Read() of an ambiguous handle = %v", err)
+	}
+	if keyname, err := store.Resolve("frame", "camera2"); err != nil || keyname != "camera2/frame" {
+		t.Errorf("Resolve() with a topic = %s, %v", keyname, err)
+	}
+	if keyname, err := store.Resolve("camera1/frame", ""); err != nil || keyname != "camera1/frame" {
+		t.Errorf("Resolve() of a full key = %s, %v", keyname, err)
+	}
+
+	// Once one of the frames is removed the handle is unique again
+	if err := store.Remove("camera1/frame"); err != nil {
+		t.Fatal(err)
+	}
+	reader, err := store.Read("frame")
+	if err != nil {
+		t.Fatalf("Read() of a unique handle failed: %v", err)
+	}
+	data, _ := ioutil.ReadAll(reader)
+	reader.Close()
+	if string(data) != "camera2" {
+		t.Errorf("Read %q", data)
+	}
+}
@@ -0,0 +1,62 @@
+/*
+Copyright (c) 2021 Intel Corporation
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package imagestore
+
+import (
+	"sync"
+	"time"
+)
+
+// ReadTracker records when the stored images were last read. It is kept in
+// memory only, images not read since startup have no entry.
+type ReadTracker struct {
+	mutex    sync.Mutex
+	lastRead map[string]time.Time
+}
+
+// NewReadTracker - function to initialize a new ReadTracker
+func NewReadTracker() *ReadTracker {
+	return &ReadTracker{lastRead: make(map[string]time.Time)}
+}
+
+// Touch records that the given image was read now
+func (tracker *ReadTracker) Touch(keyname string) {
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+	tracker.lastRead[keyname] = time.Now()
+}
+
+// LastRead returns when the given image was last read
+func (tracker *ReadTracker) LastRead(keyname string) (time.Time, bool) {
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+	lastRead, ok := tracker.lastRead[keyname]
+	return lastRead, ok
+}
+
+// Forget drops the entry of a removed image
+func (tracker *ReadTracker) Forget(keyname string) {
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+	delete(tracker.lastRead, keyname)
+}
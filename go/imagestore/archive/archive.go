@@ -0,0 +1,481 @@
+/*
+Copyright (c) 2021 Intel Corporation
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+// Package archive provides the tiers the expired frames are moved to instead
+// of being deleted: a second Minio bucket or a local directory.
+package archive
+
+import (
+	isConfigMgr "IEdgeInsights/ImageStore/isconfigmgr"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	minio "github.com/minio/minio-go"
+)
+
+// Archive types
+const (
+	TypeBucket    string = "bucket"
+	TypeDirectory string = "directory"
+)
+
+// Files of the directory archive which are not archived frames: the
+// temporary files being written and the metadata sidecar of every frame
+const (
+	tempPrefix     string = ".archive-"
+	metadataSuffix string = ".meta.json"
+)
+
+// Bucket of TypeBucket when the config sets none
+const defaultBucket string = "image-store-archive"
+
+// Prefix of the user metadata headers returned by Minio
+const userMetadataPrefix string = "X-Amz-Meta-"
+
+// Archive is a tier the expired frames are moved to
+type Archive interface {
+	// Put copies a frame of the hot bucket to the archive
+	Put(key string) error
+	// Read reads an archived frame
+	Read(key string) (io.ReadCloser, error)
+	// Expire removes the frames archived before the given time, calls
+	// onRemove for every removed frame and returns the number of frames and
+	// bytes removed
+	Expire(before time.Time, onRemove func(key string, size int64, archived time.Time)) (int64, int64, error)
+	// Walk calls fn with the key and the user metadata of every archived
+	// frame
+	Walk(fn func(key string, metadata map[string]string)) error
+	// SetClient replaces the Minio client, e.g. when the credentials changed
+	SetClient(client *minio.Client)
+}
+
+// Config selects the archive tier
+type Config struct {
+	// Type is TypeBucket or TypeDirectory
+	Type string
+	// HotBucket is the bucket the frames are archived from
+	HotBucket string
+	// Bucket is the archive bucket of TypeBucket
+	Bucket string
+	// Region is the region the archive bucket is created in
+	Region string
+	// Path is the archive directory of TypeDirectory
+	Path string
+}
+
+// New creates the archive tier.
+//
+// Parameters:
+// 1. config : Config
+//    Refers to the archive tier to create.
+// 2. client : *minio.Client
+//    Refers to the client of the Minio server holding the hot bucket.
+//
+// Returns:
+// 1. Archive
+//    Returns the archive tier.
+// 2. error
+//    Returns an error object if the config is invalid.
+func New(config Config, client *minio.Client) (Archive, error) {
+	switch config.Type {
+	case TypeBucket:
+		if config.Bucket == "" || config.Bucket == config.HotBucket {
+			return nil, errors.New("archive bucket must be set and differ from the image store bucket")
+		}
+		return &bucketArchive{config: config, clientHolder: clientHolder{client: client}}, nil
+	case TypeDirectory:
+		if config.Path == "" {
+			return nil, errors.New("archive path must be set")
+		}
+		root, err := filepath.Abs(config.Path)
+		if err != nil {
+			return nil, err
+		}
+		return &directoryArchive{config: config, clientHolder: clientHolder{client: client}, root: root}, nil
+	}
+	return nil, fmt.Errorf("unknown archive type %s, expected %s or %s",
+		config.Type, TypeBucket, TypeDirectory)
+}
+
+// FromConfig creates the archive tier from the archive config, the bucket
+// of TypeBucket defaulting to "image-store-archive". The retention time and
+// poll interval of the config are left to the caller.
+//
+// Parameters:
+// 1. config : *isConfigMgr.Archive
+//    Refers to the archive config.
+// 2. hotBucket : string
+//    Refers to the bucket the frames are archived from.
+// 3. region : string
+//    Refers to the region the archive bucket is created in.
+// 4. client : *minio.Client
+//    Refers to the client of the Minio server holding the hot bucket.
+//
+// Returns:
+// 1. Archive
+//    Returns the archive tier.
+// 2. error
+//    Returns an error object if the config is invalid.
+func FromConfig(config *isConfigMgr.Archive, hotBucket string, region string, client *minio.Client) (Archive, error) {
+	bucket := config.Bucket
+	if bucket == "" {
+		bucket = defaultBucket
+	}
+	return New(Config{
+		Type:      config.Type,
+		HotBucket: hotBucket,
+		Bucket:    bucket,
+		Region:    region,
+		Path:      config.Path,
+	}, client)
+}
+
+// clientHolder holds the Minio client of an archive
+type clientHolder struct {
+	mutex  sync.RWMutex
+	client *minio.Client
+}
+
+func (holder *clientHolder) getClient() *minio.Client {
+	holder.mutex.RLock()
+	defer holder.mutex.RUnlock()
+	return holder.client
+}
+
+func (holder *clientHolder) SetClient(client *minio.Client) {
+	holder.mutex.Lock()
+	defer holder.mutex.Unlock()
+	holder.client = client
+}
+
+// bucketArchive archives to a second bucket with server side copies, which
+// keep the object metadata
+type bucketArchive struct {
+	clientHolder
+	config     Config
+	readyMutex sync.Mutex
+	ready      bool
+}
+
+func (archive *bucketArchive) Put(key string) error {
+	if err := archive.ensureBucket(); err != nil {
+		return err
+	}
+	dst, err := minio.NewDestinationInfo(archive.config.Bucket, key, nil, nil)
+	if err != nil {
+		return err
+	}
+	src := minio.NewSourceInfo(archive.config.HotBucket, key, nil)
+	return archive.getClient().CopyObject(dst, src)
+}
+
+func (archive *bucketArchive) Read(key string) (io.ReadCloser, error) {
+	obj, err := archive.getClient().GetObject(archive.config.Bucket, key, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, err
+	}
+	// GetObject is lazy, the stat surfaces a missing key right away
+	if _, err := obj.Stat(); err != nil {
+		obj.Close()
+		return nil, err
+	}
+	return obj, nil
+}
+
+func (archive *bucketArchive) Expire(before time.Time, onRemove func(key string, size int64, archived time.Time)) (int64, int64, error) {
+	doneCh := make(chan struct{})
+	defer close(doneCh)
+
+	expired := make(map[string]minio.ObjectInfo)
+	for obj := range archive.getClient().ListObjects(archive.config.Bucket, "", true, doneCh) {
+		if obj.Err != nil {
+			if minio.ToErrorResponse(obj.Err).Code == "NoSuchBucket" {
+				return 0, 0, nil
+			}
+			return 0, 0, obj.Err
+		}
+		if obj.LastModified.Before(before) {
+			expired[obj.Key] = obj
+		}
+	}
+	if len(expired) == 0 {
+		return 0, 0, nil
+	}
+
+	objectsCh := make(chan string)
+	go func() {
+		defer close(objectsCh)
+		for key := range expired {
+			objectsCh <- key
+		}
+	}()
+	for rErr := range archive.getClient().RemoveObjects(archive.config.Bucket, objectsCh) {
+		delete(expired, rErr.ObjectName)
+	}
+
+	var count, size int64
+	for _, obj := range expired {
+		count++
+		size += obj.Size
+		onRemove(obj.Key, obj.Size, obj.LastModified)
+	}
+	return count, size, nil
+}
+
+func (archive *bucketArchive) Walk(fn func(key string, metadata map[string]string)) error {
+	doneCh := make(chan struct{})
+	defer close(doneCh)
+
+	client := archive.getClient()
+	for obj := range client.ListObjects(archive.config.Bucket, "", true, doneCh) {
+		if obj.Err != nil {
+			if minio.ToErrorResponse(obj.Err).Code == "NoSuchBucket" {
+				return nil
+			}
+			return obj.Err
+		}
+		// The listing does not carry the user metadata
+		info, err := client.StatObject(archive.config.Bucket, obj.Key, minio.StatObjectOptions{})
+		if err != nil {
+			continue
+		}
+		fn(obj.Key, userMetadata(info.Metadata))
+	}
+	return nil
+}
+
+// userMetadata returns the user metadata of the headers returned by Minio
+func userMetadata(headers map[string][]string) map[string]string {
+	metadata := make(map[string]string)
+	for header, values := range headers {
+		if strings.HasPrefix(header, userMetadataPrefix) && len(values) > 0 {
+			metadata[strings.TrimPrefix(header, userMetadataPrefix)] = values[0]
+		}
+	}
+	return metadata
+}
+
+// ensureBucket creates the archive bucket on first use
+func (archive *bucketArchive) ensureBucket() error {
+	archive.readyMutex.Lock()
+	defer archive.readyMutex.Unlock()
+	if archive.ready {
+		return nil
+	}
+	found, err := archive.getClient().BucketExists(archive.config.Bucket)
+	if err != nil {
+		return err
+	}
+	if !found {
+		if err := archive.getClient().MakeBucket(archive.config.Bucket, archive.config.Region); err != nil {
+			return err
+		}
+	}
+	archive.ready = true
+	return nil
+}
+
+// directoryArchive archives to one file per frame below a local directory,
+// the image handle being the path of the file. The object metadata of the
+// frame is kept in a sidecar file next to it.
+type directoryArchive struct {
+	clientHolder
+	config Config
+	root   string
+}
+
+// archivedMetadata is the content of the metadata sidecar of a frame
+type archivedMetadata struct {
+	ContentType  string            `json:"contentType"`
+	LastModified time.Time         `json:"lastModified"`
+	Metadata     map[string]string `json:"metadata,omitempty"`
+}
+
+func (archive *directoryArchive) Put(key string) error {
+	path, err := archive.path(key)
+	if err != nil {
+		return err
+	}
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return err
+	}
+
+	obj, err := archive.getClient().GetObject(archive.config.HotBucket, key, minio.GetObjectOptions{})
+	if err != nil {
+		return err
+	}
+	defer obj.Close()
+	info, err := obj.Stat()
+	if err != nil {
+		return err
+	}
+
+	meta := archivedMetadata{
+		ContentType:  info.ContentType,
+		LastModified: info.LastModified,
+		Metadata:     userMetadata(info.Metadata),
+	}
+	sidecar, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+
+	// The frame is written first, so a sidecar never outlives its frame
+	if err := writeFile(path, obj); err != nil {
+		return err
+	}
+	if err := writeFile(path+metadataSuffix, bytes.NewReader(sidecar)); err != nil {
+		return err
+	}
+	return syncDir(dir)
+}
+
+// writeFile writes to a temporary file renamed once synced, so that a
+// partial file is never read
+func writeFile(path string, reader io.Reader) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), tempPrefix)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(tmp, reader)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
+
+// syncDir syncs a directory, persisting the renames in it
+func syncDir(dir string) error {
+	file, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	err = file.Sync()
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+func (archive *directoryArchive) Read(key string) (io.ReadCloser, error) {
+	path, err := archive.path(key)
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(path)
+}
+
+func (archive *directoryArchive) Expire(before time.Time, onRemove func(key string, size int64, archived time.Time)) (int64, int64, error) {
+	var count, size int64
+	err := filepath.Walk(archive.root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if info.IsDir() || !info.ModTime().Before(before) {
+			return nil
+		}
+		if strings.HasSuffix(info.Name(), metadataSuffix) {
+			// Removed along with its frame
+			return nil
+		}
+		if strings.HasPrefix(info.Name(), tempPrefix) {
+			// Left by an interrupted Put, not a frame
+			os.Remove(path)
+			return nil
+		}
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+		os.Remove(path + metadataSuffix)
+		count++
+		size += info.Size()
+		if key, err := filepath.Rel(archive.root, path); err == nil {
+			onRemove(filepath.ToSlash(key), info.Size(), info.ModTime())
+		}
+		// Removing the directory if it is now empty, failing otherwise
+		if dir := filepath.Dir(path); dir != archive.root {
+			os.Remove(dir)
+		}
+		return nil
+	})
+	return count, size, err
+}
+
+func (archive *directoryArchive) Walk(fn func(key string, metadata map[string]string)) error {
+	return filepath.Walk(archive.root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if info.IsDir() || !strings.HasSuffix(info.Name(), metadataSuffix) {
+			return nil
+		}
+		sidecar, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil
+		}
+		var meta archivedMetadata
+		if err := json.Unmarshal(sidecar, &meta); err != nil {
+			return nil
+		}
+		if key, err := filepath.Rel(archive.root, strings.TrimSuffix(path, metadataSuffix)); err == nil {
+			fn(filepath.ToSlash(key), meta.Metadata)
+		}
+		return nil
+	})
+}
+
+// path returns the file of an image handle, rejecting handles escaping the
+// archive directory or named like the files which are not frames
+func (archive *directoryArchive) path(key string) (string, error) {
+	path := filepath.Join(archive.root, filepath.FromSlash(key))
+	name := filepath.Base(path)
+	if !strings.HasPrefix(path, archive.root+string(filepath.Separator)) ||
+		strings.HasPrefix(name, tempPrefix) || strings.HasSuffix(name, metadataSuffix) {
+		return "", fmt.Errorf("invalid image handle %s", key)
+	}
+	return path, nil
+}
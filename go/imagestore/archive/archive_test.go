@@ -0,0 +1,311 @@
+/*
+Copyright (c) 2021 Intel Corporation
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package archive
+
+import (
+	isConfigMgr "IEdgeInsights/ImageStore/isconfigmgr"
+	"encoding/json"
+	"encoding/xml"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	minio "github.com/minio/minio-go"
+)
+
+const hotBucket string = "image-store-bucket"
+
+// fakeObject is an object of fakeS3
+type fakeObject struct {
+	data        []byte
+	contentType string
+	metadata    map[string]string
+	modified    time.Time
+}
+
+// fakeS3 serves the part of the S3 API used by the archives from memory
+type fakeS3 struct {
+	mutex   sync.Mutex
+	buckets map[string]map[string]fakeObject
+}
+
+func newFakeS3(t *testing.T) (*fakeS3, *minio.Client, func()) {
+	s3 := &fakeS3{buckets: map[string]map[string]fakeObject{hotBucket: {}}}
+	server := httptest.NewServer(s3)
+	client, err := minio.NewWithRegion(strings.TrimPrefix(server.URL, "http://"), "admin", "password", false, "gateway")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s3, client, server.Close
+}
+
+func (s3 *fakeS3) put(bucket string, key string, object fakeObject) {
+	s3.mutex.Lock()
+	defer s3.mutex.Unlock()
+	s3.buckets[bucket][key] = object
+}
+
+func (s3 *fakeS3) keys(bucket string) []string {
+	s3.mutex.Lock()
+	defer s3.mutex.Unlock()
+	keys := make([]string, 0)
+	for key := range s3.buckets[bucket] {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+func (s3 *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s3.mutex.Lock()
+	defer s3.mutex.Unlock()
+
+	path := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/"), "/", 2)
+	bucket, key := path[0], ""
+	if len(path) == 2 {
+		key = path[1]
+	}
+	objects, found := s3.buckets[bucket]
+	if !found && !(r.Method == http.MethodPut && key == "") {
+		s3.fail(w, http.StatusNotFound, "NoSuchBucket")
+		return
+	}
+
+	switch {
+	case key == "" && r.Method == http.MethodHead:
+	case key == "" && r.Method == http.MethodPut:
+		s3.buckets[bucket] = make(map[string]fakeObject)
+	case key == "" && r.Method == http.MethodGet:
+		type contents struct {
+			Key          string
+			LastModified string
+			ETag         string
+			Size         int
+		}
+		result := struct {
+			XMLName  xml.Name `xml:"ListBucketResult"`
+			Name     string
+			Contents []contents
+		}{Name: bucket}
+		for objKey, object := range objects {
+			result.Contents = append(result.Contents, contents{
+				Key:          objKey,
+				LastModified: object.modified.UTC().Format("2006-01-02T15:04:05.000Z"),
+				ETag:         `"etag"`,
+				Size:         len(object.data),
+			})
+		}
+		xml.NewEncoder(w).Encode(result)
+	case key == "" && r.Method == http.MethodPost:
+		var request struct {
+			Objects []struct{ Key string } `xml:"Object"`
+		}
+		xml.NewDecoder(r.Body).Decode(&request)
+		for _, object := range request.Objects {
+			delete(objects, object.Key)
+		}
+		w.Write([]byte("<DeleteResult></DeleteResult>"))
+	case r.Method == http.MethodPut && r.Header.Get("X-Amz-Copy-Source") != "":
+		source, _ := url.PathUnescape(strings.TrimPrefix(r.Header.Get("X-Amz-Copy-Source"), "/"))
+		sourcePath := strings.SplitN(source, "/", 2)
+		object, ok := s3.buckets[sourcePath[0]][sourcePath[1]]
+		if !ok {
+			s3.fail(w, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+		object.modified = time.Now()
+		objects[key] = object
+		w.Write([]byte("<CopyObjectResult><ETag>\"etag\"</ETag></CopyObjectResult>"))
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		object, ok := objects[key]
+		if !ok {
+			s3.fail(w, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+		w.Header().Set("Content-Type", object.contentType)
+		w.Header().Set("Last-Modified", object.modified.UTC().Format(http.TimeFormat))
+		w.Header().Set("ETag", `"etag"`)
+		for metaKey, value := range object.metadata {
+			w.Header().Set("X-Amz-Meta-"+metaKey, value)
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(object.data)))
+		if r.Method == http.MethodGet {
+			w.Write(object.data)
+		}
+	default:
+		s3.fail(w, http.StatusNotImplemented, "NotImplemented")
+	}
+}
+
+func (s3 *fakeS3) fail(w http.ResponseWriter, status int, code string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	xml.NewEncoder(w).Encode(minio.ErrorResponse{Code: code})
+}
+
+// storeFrame stores a frame in the hot bucket
+func storeFrame(s3 *fakeS3, key string) {
+	s3.put(hotBucket, key, fakeObject{
+		data:        []byte("frame " + key),
+		contentType: "image/png",
+		metadata:    map[string]string{"Topic": "camera1", "Pinned": "true"},
+		modified:    time.Now().Add(-time.Hour),
+	})
+}
+
+func TestBucketArchive(t *testing.T) {
+	s3, client, stop := newFakeS3(t)
+	defer stop()
+	storeFrame(s3, "frame1")
+
+	arch, err := New(Config{Type: TypeBucket, HotBucket: hotBucket, Bucket: "archive", Region: "gateway"}, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := arch.Put("frame1"); err != nil {
+		t.Fatalf("Put() failed: %v", err)
+	}
+	if err := arch.Put("missing"); err == nil {
+		t.Errorf("Missing frame archived")
+	}
+	reader, err := arch.Read("frame1")
+	if err != nil {
+		t.Fatalf("Read() failed: %v", err)
+	}
+	data, _ := ioutil.ReadAll(reader)
+	reader.Close()
+	if string(data) != "frame frame1" {
+		t.Errorf("Read %q from the archive", data)
+	}
+	walked := make(map[string]string)
+	if err := arch.Walk(func(key string, metadata map[string]string) { walked[key] = metadata["Topic"] }); err != nil || len(walked) != 1 || walked["frame1"] != "camera1" {
+		t.Errorf("Walk() = %v, %v", walked, err)
+	}
+
+	// The frames are archived now, so only a later time expires them
+	removed := 0
+	onRemove := func(key string, size int64, archived time.Time) { removed++ }
+	if count, _, err := arch.Expire(time.Now().Add(-time.Minute), onRemove); err != nil || count != 0 {
+		t.Errorf("Expire() = %d, %v before the retention time", count, err)
+	}
+	count, size, err := arch.Expire(time.Now().Add(time.Minute), onRemove)
+	if err != nil || count != 1 || size != int64(len("frame frame1")) || removed != 1 {
+		t.Errorf("Expire() = %d, %d, %v, removed %d, expected 1 frame", count, size, err, removed)
+	}
+	if keys := s3.keys("archive"); len(keys) != 0 {
+		t.Errorf("Archive still holds %v", keys)
+	}
+}
+
+func TestDirectoryArchive(t *testing.T) {
+	s3, client, stop := newFakeS3(t)
+	defer stop()
+	storeFrame(s3, "camera1/frame1")
+	dir, err := ioutil.TempDir("", "archive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	arch, err := New(Config{Type: TypeDirectory, HotBucket: hotBucket, Path: dir}, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := arch.Put("camera1/frame1"); err != nil {
+		t.Fatalf("Put() failed: %v", err)
+	}
+	for _, key := range []string{"../escape", "camera1/frame1" + metadataSuffix, tempPrefix + "1"} {
+		if err := arch.Put(key); err == nil {
+			t.Errorf("Invalid key %s archived", key)
+		}
+	}
+	reader, err := arch.Read("camera1/frame1")
+	if err != nil {
+		t.Fatalf("Read() failed: %v", err)
+	}
+	data, _ := ioutil.ReadAll(reader)
+	reader.Close()
+	if string(data) != "frame camera1/frame1" {
+		t.Errorf("Read %q from the archive", data)
+	}
+
+	// The object metadata is kept next to the frame
+	sidecar, err := ioutil.ReadFile(filepath.Join(dir, "camera1", "frame1"+metadataSuffix))
+	if err != nil {
+		t.Fatalf("Metadata sidecar missing: %v", err)
+	}
+	var meta archivedMetadata
+	if err := json.Unmarshal(sidecar, &meta); err != nil {
+		t.Fatal(err)
+	}
+	if meta.ContentType != "image/png" || meta.Metadata["Topic"] != "camera1" || meta.Metadata["Pinned"] != "true" || meta.LastModified.IsZero() {
+		t.Errorf("Archived metadata %+v", meta)
+	}
+	walked := make(map[string]string)
+	if err := arch.Walk(func(key string, metadata map[string]string) { walked[key] = metadata["Topic"] }); err != nil || len(walked) != 1 || walked["camera1/frame1"] != "camera1" {
+		t.Errorf("Walk() = %v, %v", walked, err)
+	}
+
+	// A temporary file left by an interrupted Put is not a frame
+	leftover := filepath.Join(dir, "camera1", tempPrefix+"123")
+	ioutil.WriteFile(leftover, []byte("partial"), 0640)
+
+	var removed []string
+	onRemove := func(key string, size int64, archived time.Time) { removed = append(removed, key) }
+	count, size, err := arch.Expire(time.Now().Add(time.Minute), onRemove)
+	if err != nil || count != 1 || size != int64(len("frame camera1/frame1")) {
+		t.Errorf("Expire() = %d, %d, %v, expected 1 frame", count, size, err)
+	}
+	if len(removed) != 1 || removed[0] != "camera1/frame1" {
+		t.Errorf("Expire() reported %v", removed)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "camera1")); !os.IsNotExist(err) {
+		t.Errorf("Archive directory not emptied: %v", err)
+	}
+}
+
+func TestFromConfig(t *testing.T) {
+	arch, err := FromConfig(&isConfigMgr.Archive{Type: TypeBucket}, hotBucket, "gateway", nil)
+	if err != nil {
+		t.Fatalf("FromConfig() failed: %v", err)
+	}
+	if bucket := arch.(*bucketArchive).config.Bucket; bucket != defaultBucket {
+		t.Errorf("Archiving to bucket %s, expected the default one", bucket)
+	}
+	for _, config := range []isConfigMgr.Archive{
+		{Type: TypeBucket, Bucket: hotBucket},
+		{Type: TypeDirectory},
+		{Type: "tape"},
+	} {
+		if _, err := FromConfig(&config, hotBucket, "gateway", nil); err == nil {
+			t.Errorf("Invalid archive config %+v accepted", config)
+		}
+	}
+}
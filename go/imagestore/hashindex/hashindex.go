@@ -0,0 +1,156 @@
+/*
+Copyright (c) 2021 Intel Corporation
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+// Package hashindex keeps the perceptual hashes of the stored frames in
+// memory and answers nearest neighbour queries on them.
+package hashindex
+
+import (
+	imaging "IEdgeInsights/ImageStore/go/imagestore/imaging"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Entry is an indexed frame
+type Entry struct {
+	Key    string
+	Topic  string
+	Stored time.Time
+	Hashes imaging.Hashes
+}
+
+// Query selects the frames considered by Nearest
+type Query struct {
+	// Hash is compared against the hashes computed with Algorithm
+	Hash      uint64
+	Algorithm string
+	// Count is the maximum number of matches returned
+	Count int
+	// Topic limits the search to one topic if not empty
+	Topic string
+	// Start and End limit the search to frames stored in between if not zero
+	Start time.Time
+	End   time.Time
+	// Exclude is skipped, used to not match a frame with itself
+	Exclude string
+}
+
+// Match is a result of Nearest
+type Match struct {
+	Entry
+	Distance int
+}
+
+// Index is a concurrency safe in-memory index of perceptual hashes
+type Index struct {
+	mutex   sync.RWMutex
+	entries map[string]Entry
+}
+
+// NewIndex - function to initialize a new Index
+func NewIndex() *Index {
+	return &Index{entries: make(map[string]Entry)}
+}
+
+// Add adds or replaces the entry of a frame
+func (index *Index) Add(entry Entry) {
+	index.mutex.Lock()
+	defer index.mutex.Unlock()
+	index.entries[entry.Key] = entry
+}
+
+// Remove drops the entry of a frame
+func (index *Index) Remove(key string) {
+	index.mutex.Lock()
+	defer index.mutex.Unlock()
+	delete(index.entries, key)
+}
+
+// Get returns the entry of a frame
+func (index *Index) Get(key string) (Entry, bool) {
+	index.mutex.RLock()
+	defer index.mutex.RUnlock()
+	entry, ok := index.entries[key]
+	return entry, ok
+}
+
+// Len returns the number of indexed frames
+func (index *Index) Len() int {
+	index.mutex.RLock()
+	defer index.mutex.RUnlock()
+	return len(index.entries)
+}
+
+// Nearest returns the indexed frames closest to the query hash by Hamming
+// distance, closest first. The index is scanned linearly, which is fast
+// enough for the number of frames kept within a retention period.
+//
+// Parameters:
+// 1. query : Query
+//    Refers to the hash to search for and the filters to apply.
+//
+// Returns:
+// 1. []Match
+//    Returns up to query.Count matches.
+// 2. error
+//    Returns an error object if the hash algorithm is unknown.
+func (index *Index) Nearest(query Query) ([]Match, error) {
+	if _, err := (imaging.Hashes{}).Get(query.Algorithm); err != nil {
+		return nil, err
+	}
+
+	index.mutex.RLock()
+	matches := make([]Match, 0)
+	for key, entry := range index.entries {
+		if key == query.Exclude {
+			continue
+		}
+		if query.Topic != "" && entry.Topic != query.Topic {
+			continue
+		}
+		if !query.Start.IsZero() && entry.Stored.Before(query.Start) {
+			continue
+		}
+		if !query.End.IsZero() && entry.Stored.After(query.End) {
+			continue
+		}
+		hash, _ := entry.Hashes.Get(query.Algorithm)
+		matches = append(matches, Match{
+			Entry:    entry,
+			Distance: imaging.HammingDistance(hash, query.Hash),
+		})
+	}
+	index.mutex.RUnlock()
+
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].Distance != matches[j].Distance {
+			return matches[i].Distance < matches[j].Distance
+		}
+		return matches[i].Stored.After(matches[j].Stored)
+	})
+
+	if query.Count > 0 && len(matches) > query.Count {
+		matches = matches[:query.Count]
+	}
+	return matches, nil
+}
@@ -0,0 +1,104 @@
+/*
+Copyright (c) 2021 Intel Corporation
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package hashindex
+
+import (
+	imaging "IEdgeInsights/ImageStore/go/imagestore/imaging"
+	"testing"
+	"time"
+)
+
+// newTestIndex indexes frames of two topics stored a minute apart, the
+// aHash of frame N having its N lowest bits set
+func newTestIndex() (*Index, time.Time) {
+	start := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	index := NewIndex()
+	for i, key := range []string{"a", "b", "c", "d"} {
+		topic := "camera1"
+		if i%2 == 1 {
+			topic = "camera2"
+		}
+		index.Add(Entry{
+			Key:    key,
+			Topic:  topic,
+			Stored: start.Add(time.Duration(i) * time.Minute),
+			Hashes: imaging.Hashes{AHash: 1<<uint(i) - 1},
+		})
+	}
+	return index, start
+}
+
+func keysOf(matches []Match) string {
+	keys := ""
+	for _, match := range matches {
+		keys += match.Key
+	}
+	return keys
+}
+
+func TestNearest(t *testing.T) {
+	index, start := newTestIndex()
+	tests := []struct {
+		name  string
+		query Query
+		keys  string
+	}{
+		{"closest first, newest first", Query{Hash: 0x3, Algorithm: imaging.AverageHash}, "cdba"},
+		{"count", Query{Hash: 0x3, Algorithm: imaging.AverageHash, Count: 2}, "cd"},
+		{"exclude", Query{Hash: 0x3, Algorithm: imaging.AverageHash, Exclude: "c"}, "dba"},
+		{"topic", Query{Hash: 0x3, Algorithm: imaging.AverageHash, Topic: "camera2"}, "db"},
+		{"start", Query{Hash: 0x3, Algorithm: imaging.AverageHash, Start: start.Add(2 * time.Minute)}, "cd"},
+		{"end", Query{Hash: 0x3, Algorithm: imaging.AverageHash, End: start.Add(time.Minute)}, "ba"},
+		{"other algorithm", Query{Hash: 0, Algorithm: imaging.PHash}, "dcba"},
+	}
+	for _, test := range tests {
+		matches, err := index.Nearest(test.query)
+		if err != nil {
+			t.Errorf("%s: query failed: %v", test.name, err)
+			continue
+		}
+		if keys := keysOf(matches); keys != test.keys {
+			t.Errorf("%s: matched %s, expected %s", test.name, keys, test.keys)
+		}
+	}
+
+	if _, err := index.Nearest(Query{Algorithm: "md5"}); err == nil {
+		t.Errorf("Query with an unknown algorithm succeeded")
+	}
+}
+
+func TestRemove(t *testing.T) {
+	index, _ := newTestIndex()
+	index.Remove("c")
+	index.Remove("unknown")
+	if index.Len() != 3 {
+		t.Errorf("Index has %d entries after removing one of 4", index.Len())
+	}
+	if _, ok := index.Get("c"); ok {
+		t.Errorf("Removed entry found")
+	}
+	matches, _ := index.Nearest(Query{Hash: 0x3, Algorithm: imaging.AverageHash})
+	if keys := keysOf(matches); keys != "dba" {
+		t.Errorf("Matched %s after removal, expected dba", keys)
+	}
+}
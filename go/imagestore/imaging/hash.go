@@ -0,0 +1,195 @@
+/*
+Copyright (c) 2021 Intel Corporation
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package imaging
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"math"
+	"math/bits"
+	"sort"
+
+	"golang.org/x/image/draw"
+)
+
+// Perceptual hash algorithms
+const (
+	AverageHash    string = "ahash"
+	DifferenceHash string = "dhash"
+	PHash          string = "phash"
+)
+
+// Size of the image the DCT of the pHash is computed on
+const dctSize int = 32
+
+// Hashes holds the 64 bit perceptual hashes of an image
+type Hashes struct {
+	AHash uint64
+	DHash uint64
+	PHash uint64
+}
+
+// Get returns the hash computed with the given algorithm
+func (hashes Hashes) Get(algorithm string) (uint64, error) {
+	switch algorithm {
+	case AverageHash:
+		return hashes.AHash, nil
+	case DifferenceHash:
+		return hashes.DHash, nil
+	case PHash:
+		return hashes.PHash, nil
+	}
+	return 0, fmt.Errorf("unknown hash algorithm %s, expected %s, %s or %s",
+		algorithm, AverageHash, DifferenceHash, PHash)
+}
+
+// ComputeHashes decodes the given image and computes its perceptual hashes.
+//
+// Parameters:
+// 1. data : []byte
+//    Refers to the encoded JPEG, PNG or BMP image.
+//
+// Returns:
+// 1. Hashes
+//    Returns the aHash, dHash and pHash of the image.
+// 2. error
+//    Returns an error object if the image can not be decoded.
+func ComputeHashes(data []byte) (Hashes, error) {
+	if DetectContentType(data) == ContentTypeRaw {
+		return Hashes{}, ErrUnsupportedEncoding
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return Hashes{}, fmt.Errorf("image could not be decoded: %v", err)
+	}
+
+	return Hashes{
+		AHash: averageHash(img),
+		DHash: differenceHash(img),
+		PHash: perceptualHash(img),
+	}, nil
+}
+
+// HammingDistance returns the number of bits differing between two hashes
+func HammingDistance(first, second uint64) int {
+	return bits.OnesCount64(first ^ second)
+}
+
+// grayscale scales img down to width x height and returns its luminance
+func grayscale(img image.Image, width, height int) []float64 {
+	small := image.NewGray(image.Rect(0, 0, width, height))
+	draw.ApproxBiLinear.Scale(small, small.Bounds(), img, img.Bounds(), draw.Src, nil)
+
+	pixels := make([]float64, width*height)
+	for i := range pixels {
+		pixels[i] = float64(small.Pix[(i/width)*small.Stride+i%width])
+	}
+	return pixels
+}
+
+// averageHash sets a bit for every pixel of the 8x8 thumbnail brighter than
+// the mean
+func averageHash(img image.Image) uint64 {
+	pixels := grayscale(img, 8, 8)
+
+	mean := 0.0
+	for _, pixel := range pixels {
+		mean += pixel
+	}
+	mean /= float64(len(pixels))
+
+	var hash uint64
+	for i, pixel := range pixels {
+		if pixel > mean {
+			hash |= 1 << uint(i)
+		}
+	}
+	return hash
+}
+
+// differenceHash sets a bit for every pixel of the 9x8 thumbnail brighter
+// than its right neighbour
+func differenceHash(img image.Image) uint64 {
+	pixels := grayscale(img, 9, 8)
+
+	var hash uint64
+	bit := uint(0)
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			if pixels[y*9+x] > pixels[y*9+x+1] {
+				hash |= 1 << bit
+			}
+			bit++
+		}
+	}
+	return hash
+}
+
+// perceptualHash sets a bit for every low frequency DCT coefficient of the
+// 32x32 thumbnail above the median of those coefficients
+func perceptualHash(img image.Image) uint64 {
+	pixels := grayscale(img, dctSize, dctSize)
+
+	// Separable 2D DCT-II, only the top left 8x8 coefficients are needed
+	rows := make([]float64, dctSize*8)
+	for y := 0; y < dctSize; y++ {
+		for u := 0; u < 8; u++ {
+			sum := 0.0
+			for x := 0; x < dctSize; x++ {
+				sum += pixels[y*dctSize+x] * dctCosine(x, u)
+			}
+			rows[y*8+u] = sum
+		}
+	}
+
+	coefficients := make([]float64, 64)
+	for v := 0; v < 8; v++ {
+		for u := 0; u < 8; u++ {
+			sum := 0.0
+			for y := 0; y < dctSize; y++ {
+				sum += rows[y*8+u] * dctCosine(y, v)
+			}
+			coefficients[v*8+u] = sum
+		}
+	}
+
+	// The DC coefficient only carries the average brightness
+	sorted := append([]float64(nil), coefficients[1:]...)
+	sort.Float64s(sorted)
+	median := sorted[len(sorted)/2]
+
+	var hash uint64
+	for i, coefficient := range coefficients {
+		if coefficient > median {
+			hash |= 1 << uint(i)
+		}
+	}
+	return hash
+}
+
+// dctCosine returns the DCT-II basis function of frequency k at position n
+func dctCosine(n, k int) float64 {
+	return math.Cos(math.Pi / float64(dctSize) * (float64(n) + 0.5) * float64(k))
+}
@@ -0,0 +1,122 @@
+/*
+Copyright (c) 2021 Intel Corporation
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+// Package imaging provides content detection and validation of the image
+// buffers handled by ImageStore.
+package imaging
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	// Registering the decoders supported by ImageStore
+	_ "image/jpeg"
+	_ "image/png"
+	"net/http"
+
+	_ "golang.org/x/image/bmp"
+)
+
+// Content types detected for the stored blobs
+const (
+	ContentTypeJPEG string = "image/jpeg"
+	ContentTypePNG  string = "image/png"
+	ContentTypeBMP  string = "image/bmp"
+	ContentTypeRaw  string = "application/octet-stream"
+)
+
+// Policy holds the checks applied to a blob before it is stored
+type Policy struct {
+	// RequireImage rejects blobs which are not decodable JPEG, PNG or BMP images
+	RequireImage bool
+	// MaxWidth is the maximum allowed image width, 0 means unlimited
+	MaxWidth int
+	// MaxHeight is the maximum allowed image height, 0 means unlimited
+	MaxHeight int
+}
+
+// DetectContentType sniffs the content type of the given blob.
+//
+// Parameters:
+// 1. data : []byte
+//    Refers to the blob to be inspected.
+//
+// Returns:
+// 1. string
+//    Returns one of the ContentType constants, ContentTypeRaw for anything
+//    which is not a JPEG, PNG or BMP image.
+func DetectContentType(data []byte) string {
+	contentType := http.DetectContentType(data)
+	switch contentType {
+	case ContentTypeJPEG, ContentTypePNG, ContentTypeBMP:
+		return contentType
+	}
+	return ContentTypeRaw
+}
+
+// Validate checks the given blob against the policy.
+//
+// Parameters:
+// 1. data : []byte
+//    Refers to the blob to be stored.
+//
+// Returns:
+// 1. error
+//    Returns an error object describing why the blob was rejected.
+func (policy *Policy) Validate(data []byte) error {
+	if !policy.RequireImage && policy.MaxWidth <= 0 && policy.MaxHeight <= 0 {
+		return nil
+	}
+
+	if DetectContentType(data) == ContentTypeRaw {
+		if policy.RequireImage {
+			return fmt.Errorf("blob is not a JPEG, PNG or BMP image")
+		}
+		// Dimensions of raw frames are not known, nothing else to check
+		return nil
+	}
+
+	var width, height int
+	if policy.RequireImage {
+		img, _, err := image.Decode(bytes.NewReader(data))
+		if err != nil {
+			return fmt.Errorf("image could not be decoded: %v", err)
+		}
+		width = img.Bounds().Dx()
+		height = img.Bounds().Dy()
+	} else {
+		config, _, err := image.DecodeConfig(bytes.NewReader(data))
+		if err != nil {
+			return fmt.Errorf("image header could not be decoded: %v", err)
+		}
+		width = config.Width
+		height = config.Height
+	}
+
+	if policy.MaxWidth > 0 && width > policy.MaxWidth {
+		return fmt.Errorf("image width %d exceeds maximum of %d", width, policy.MaxWidth)
+	}
+	if policy.MaxHeight > 0 && height > policy.MaxHeight {
+		return fmt.Errorf("image height %d exceeds maximum of %d", height, policy.MaxHeight)
+	}
+	return nil
+}
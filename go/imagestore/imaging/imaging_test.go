@@ -0,0 +1,147 @@
+/*
+Copyright (c) 2021 Intel Corporation
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package imaging
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"testing"
+
+	"golang.org/x/image/bmp"
+)
+
+// testImage creates a gradient image of the given size
+func testImage(width, height int) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.Set(x, y, color.RGBA{uint8(x), uint8(y), uint8(x + y), 255})
+		}
+	}
+	return img
+}
+
+func TestDetectContentType(t *testing.T) {
+	img := testImage(16, 8)
+
+	var pngBuf, jpegBuf, bmpBuf bytes.Buffer
+	png.Encode(&pngBuf, img)
+	jpeg.Encode(&jpegBuf, img, nil)
+	bmp.Encode(&bmpBuf, img)
+
+	tests := map[string][]byte{
+		ContentTypePNG:  pngBuf.Bytes(),
+		ContentTypeJPEG: jpegBuf.Bytes(),
+		ContentTypeBMP:  bmpBuf.Bytes(),
+		ContentTypeRaw:  make([]byte, 512),
+	}
+	for expected, data := range tests {
+		if contentType := DetectContentType(data); contentType != expected {
+			t.Errorf("Detected content type %s, expected %s", contentType, expected)
+		}
+	}
+}
+
+func TestPolicyValidate(t *testing.T) {
+	var pngBuf bytes.Buffer
+	png.Encode(&pngBuf, testImage(64, 32))
+	raw := make([]byte, 512)
+
+	policy := Policy{RequireImage: true}
+	if err := policy.Validate(pngBuf.Bytes()); err != nil {
+		t.Errorf("Valid image rejected: %v", err)
+	}
+	if err := policy.Validate(raw); err == nil {
+		t.Errorf("Raw blob accepted by policy requiring images")
+	}
+
+	// A truncated image is sniffed as PNG but can not be decoded
+	if err := policy.Validate(pngBuf.Bytes()[:64]); err == nil {
+		t.Errorf("Truncated image accepted by policy requiring images")
+	}
+
+	policy = Policy{MaxWidth: 32}
+	if err := policy.Validate(pngBuf.Bytes()); err == nil {
+		t.Errorf("Image wider than MaxWidth accepted")
+	}
+	if err := policy.Validate(raw); err != nil {
+		t.Errorf("Raw blob rejected by dimension only policy: %v", err)
+	}
+
+	policy = Policy{MaxWidth: 64, MaxHeight: 32}
+	if err := policy.Validate(pngBuf.Bytes()); err != nil {
+		t.Errorf("Image within limits rejected: %v", err)
+	}
+}
+
+func TestComputeHashes(t *testing.T) {
+	var original, brighter, other bytes.Buffer
+	img := testImage(64, 64)
+	png.Encode(&original, img)
+
+	// Slightly brighter copy of the same image
+	bright := image.NewRGBA(img.Bounds())
+	for y := 0; y < 64; y++ {
+		for x := 0; x < 64; x++ {
+			r, g, b, _ := img.At(x, y).RGBA()
+			bright.Set(x, y, color.RGBA{uint8(r>>8) + 4, uint8(g>>8) + 4, uint8(b>>8) + 4, 255})
+		}
+	}
+	png.Encode(&brighter, bright)
+
+	// Mirrored gradient, visually different from the original
+	mirrored := image.NewRGBA(img.Bounds())
+	for y := 0; y < 64; y++ {
+		for x := 0; x < 64; x++ {
+			mirrored.Set(x, y, img.At(63-x, 63-y))
+		}
+	}
+	png.Encode(&other, mirrored)
+
+	originalHashes, err := ComputeHashes(original.Bytes())
+	if err != nil {
+		t.Fatalf("Failed to hash image: %v", err)
+	}
+	brighterHashes, _ := ComputeHashes(brighter.Bytes())
+	otherHashes, _ := ComputeHashes(other.Bytes())
+
+	for _, algorithm := range []string{AverageHash, DifferenceHash, PHash} {
+		originalHash, _ := originalHashes.Get(algorithm)
+		brighterHash, _ := brighterHashes.Get(algorithm)
+		otherHash, _ := otherHashes.Get(algorithm)
+
+		near := HammingDistance(originalHash, brighterHash)
+		far := HammingDistance(originalHash, otherHash)
+		if near >= far {
+			t.Errorf("%s: distance to similar image %d not below distance to different image %d",
+				algorithm, near, far)
+		}
+	}
+
+	if _, err := ComputeHashes(make([]byte, 512)); err != ErrUnsupportedEncoding {
+		t.Errorf("Hashing raw blob returned %v, expected ErrUnsupportedEncoding", err)
+	}
+}
@@ -0,0 +1,214 @@
+/*
+Copyright (c) 2021 Intel Corporation
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package imaging
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+
+	"golang.org/x/image/bmp"
+	"golang.org/x/image/draw"
+)
+
+// Fit modes used when both width and height are requested
+const (
+	// FitContain scales the image to fit inside the requested size keeping
+	// its aspect ratio
+	FitContain string = "contain"
+	// FitCover scales the image to cover the requested size keeping its
+	// aspect ratio and crops the overflow around the center
+	FitCover string = "cover"
+	// FitFill stretches the image to exactly the requested size
+	FitFill string = "fill"
+)
+
+// Quality of the JPEG images encoded after a transformation
+const jpegQuality int = 90
+
+// MaxDimension is the largest width or height of a transformed image, so a
+// request can not allocate an arbitrarily large image
+const MaxDimension int = 8192
+
+// ErrUnsupportedEncoding is returned when transforming a blob which is not a
+// JPEG, PNG or BMP image
+var ErrUnsupportedEncoding = errors.New("unsupported image encoding, only JPEG, PNG and BMP frames can be resized or cropped")
+
+// TransformOptions holds the server side transformations applied on read
+type TransformOptions struct {
+	// Crop is the region of the original image to keep, applied before
+	// resizing. A nil Crop keeps the whole image.
+	Crop *image.Rectangle
+	// Width of the output image, 0 derives it from Height
+	Width int
+	// Height of the output image, 0 derives it from Width
+	Height int
+	// Fit is one of FitContain, FitCover or FitFill, FitContain if empty
+	Fit string
+}
+
+// IsEmpty reports whether the options leave the image untouched
+func (options *TransformOptions) IsEmpty() bool {
+	return options.Crop == nil && options.Width <= 0 && options.Height <= 0
+}
+
+// Transform crops and resizes the given image and encodes the result in the
+// encoding of the original image.
+//
+// Parameters:
+// 1. data : []byte
+//    Refers to the encoded image.
+// 2. options : TransformOptions
+//    Refers to the crop rectangle and output size.
+//
+// Returns:
+// 1. []byte
+//    Returns the encoded transformed image.
+// 2. error
+//    Returns an error object if the image can not be transformed.
+func Transform(data []byte, options TransformOptions) ([]byte, error) {
+	contentType := DetectContentType(data)
+	if contentType == ContentTypeRaw {
+		return nil, ErrUnsupportedEncoding
+	}
+	if options.Width > MaxDimension || options.Height > MaxDimension {
+		return nil, fmt.Errorf("requested size %dx%d exceeds the maximum of %d pixels",
+			options.Width, options.Height, MaxDimension)
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("image could not be decoded: %v", err)
+	}
+
+	if options.Crop != nil {
+		img, err = crop(img, *options.Crop)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if options.Width > 0 || options.Height > 0 {
+		img, err = resize(img, options.Width, options.Height, options.Fit)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var buf bytes.Buffer
+	switch contentType {
+	case ContentTypeJPEG:
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: jpegQuality})
+	case ContentTypePNG:
+		err = png.Encode(&buf, img)
+	case ContentTypeBMP:
+		err = bmp.Encode(&buf, img)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("image could not be encoded: %v", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// crop returns the part of img inside rect, rect being relative to the top
+// left corner of the image
+func crop(img image.Image, rect image.Rectangle) (image.Image, error) {
+	bounds := img.Bounds()
+	rect = rect.Add(bounds.Min).Intersect(bounds)
+	if rect.Empty() {
+		return nil, fmt.Errorf("crop rectangle is outside of the %dx%d image",
+			bounds.Dx(), bounds.Dy())
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, rect.Dx(), rect.Dy()))
+	draw.Draw(dst, dst.Bounds(), img, rect.Min, draw.Src)
+	return dst, nil
+}
+
+// resize scales img to the requested size according to the fit mode
+func resize(img image.Image, width, height int, fit string) (image.Image, error) {
+	bounds := img.Bounds()
+	srcWidth := bounds.Dx()
+	srcHeight := bounds.Dy()
+
+	if fit == "" {
+		fit = FitContain
+	}
+
+	// Deriving the missing dimension from the aspect ratio
+	if width <= 0 {
+		width = atLeastOne(srcWidth * height / srcHeight)
+		fit = FitFill
+	} else if height <= 0 {
+		height = atLeastOne(srcHeight * width / srcWidth)
+		fit = FitFill
+	}
+
+	src := bounds
+	switch fit {
+	case FitFill:
+	case FitContain:
+		if srcWidth*height > srcHeight*width {
+			height = atLeastOne(srcHeight * width / srcWidth)
+		} else {
+			width = atLeastOne(srcWidth * height / srcHeight)
+		}
+	case FitCover:
+		// Cropping the source to the aspect ratio of the output
+		if srcWidth*height > srcHeight*width {
+			cropWidth := srcHeight * width / height
+			offset := (srcWidth - cropWidth) / 2
+			src = image.Rect(bounds.Min.X+offset, bounds.Min.Y,
+				bounds.Min.X+offset+cropWidth, bounds.Max.Y)
+		} else {
+			cropHeight := srcWidth * height / width
+			offset := (srcHeight - cropHeight) / 2
+			src = image.Rect(bounds.Min.X, bounds.Min.Y+offset,
+				bounds.Max.X, bounds.Min.Y+offset+cropHeight)
+		}
+	default:
+		return nil, fmt.Errorf("unknown fit mode %s, expected %s, %s or %s",
+			fit, FitContain, FitCover, FitFill)
+	}
+
+	// The derived dimension may exceed the maximum for a narrow image
+	if width > MaxDimension || height > MaxDimension {
+		return nil, fmt.Errorf("output size %dx%d exceeds the maximum of %d pixels",
+			width, height, MaxDimension)
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, src, draw.Src, nil)
+	return dst, nil
+}
+
+// atLeastOne keeps scaled dimensions from collapsing to zero pixels
+func atLeastOne(value int) int {
+	if value < 1 {
+		return 1
+	}
+	return value
+}
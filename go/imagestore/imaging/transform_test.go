@@ -0,0 +1,93 @@
+/*
+Copyright (c) 2021 Intel Corporation
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package imaging
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"testing"
+)
+
+// encodePNG returns the PNG encoding of a test image of the given size
+func encodePNG(t *testing.T, width, height int) []byte {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, testImage(width, height)); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestTransformFit(t *testing.T) {
+	data := encodePNG(t, 200, 100)
+	tests := []struct {
+		name          string
+		options       TransformOptions
+		width, height int
+		fails         bool
+	}{
+		{"contain", TransformOptions{Width: 50, Height: 50, Fit: FitContain}, 50, 25, false},
+		{"default contain", TransformOptions{Width: 50, Height: 50}, 50, 25, false},
+		{"cover", TransformOptions{Width: 50, Height: 50, Fit: FitCover}, 50, 50, false},
+		{"fill", TransformOptions{Width: 50, Height: 50, Fit: FitFill}, 50, 50, false},
+		{"width only", TransformOptions{Width: 100}, 100, 50, false},
+		{"height only", TransformOptions{Height: 10}, 20, 10, false},
+		{"unknown fit", TransformOptions{Width: 50, Height: 50, Fit: "stretch"}, 0, 0, true},
+		{"too large", TransformOptions{Width: MaxDimension + 1}, 0, 0, true},
+		{"derived too large", TransformOptions{Height: MaxDimension}, 0, 0, true},
+		{"crop", TransformOptions{Crop: &image.Rectangle{Min: image.Point{150, 50}, Max: image.Point{250, 150}}}, 50, 50, false},
+		{"crop outside", TransformOptions{Crop: &image.Rectangle{Min: image.Point{300, 0}, Max: image.Point{400, 10}}}, 0, 0, true},
+	}
+	for _, test := range tests {
+		transformed, err := Transform(data, test.options)
+		if test.fails {
+			if err == nil {
+				t.Errorf("%s: transform succeeded", test.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: transform failed: %v", test.name, err)
+			continue
+		}
+		img, err := png.Decode(bytes.NewReader(transformed))
+		if err != nil {
+			t.Errorf("%s: transformed image is not a PNG: %v", test.name, err)
+			continue
+		}
+		if size := img.Bounds().Size(); size.X != test.width || size.Y != test.height {
+			t.Errorf("%s: transformed size %dx%d, expected %dx%d", test.name, size.X, size.Y, test.width, test.height)
+		}
+	}
+}
+
+func TestTransformUnsupportedEncoding(t *testing.T) {
+	if _, err := Transform(make([]byte, 512), TransformOptions{Width: 10}); err != ErrUnsupportedEncoding {
+		t.Errorf("Transforming a raw blob returned %v, expected ErrUnsupportedEncoding", err)
+	}
+	// A PNG signature followed by garbage can not be decoded
+	corrupt := append(encodePNG(t, 4, 4)[:16], make([]byte, 64)...)
+	if _, err := Transform(corrupt, TransformOptions{Width: 10}); err == nil {
+		t.Errorf("Corrupt image transformed")
+	}
+}
@@ -24,10 +24,13 @@ SOFTWARE.
 package persistent
 
 import (
+	common "IEdgeInsights/ImageStore/common"
 	"IEdgeInsights/ImageStore/go/imagestore/persistent/minio"
+	spool "IEdgeInsights/ImageStore/spool"
 	"errors"
 	"io"
 	"strings"
+	"time"
 	"github.com/golang/glog"
 )
 
@@ -42,6 +45,25 @@ type Storage interface {
 	// Store the given byte array to the storage and return the key under which
 	// it is not being stored.
 	Store(data []byte, key string) (string, error)
+
+	// Store the given byte array with user metadata to the storage and return
+	// the key under which it is being stored.
+	StoreWithMetadata(data []byte, key string, metadata map[string]string) (string, error)
+
+	// Stat returns the details and user metadata of the given key
+	Stat(keyname string) (common.ObjectInfo, error)
+
+	// SetMetadata replaces the user metadata of the given key
+	SetMetadata(keyname string, metadata map[string]string) error
+
+	// List the objects whose key starts with prefix until doneCh is closed
+	List(prefix string, doneCh <-chan struct{}) <-chan common.ObjectInfo
+}
+
+// syncStorage is implemented by the storages whose writes are asynchronous,
+// to write synchronously on request
+type syncStorage interface {
+	StoreWithMetadataSync(data []byte, key string, metadata map[string]string) (string, error)
 }
 
 // Persistent storage structure
@@ -81,6 +103,20 @@ func NewPersistent(storageType string, config map[string]string) (*Persistent, e
 	return nil, err
 }
 
+// NewPersistentStorage is used to wrap an existing storage, e.g. an in-memory
+// one.
+//
+// Parameters:
+// 1. storage : Storage
+//    Refers to the storage the data is kept in.
+//
+// Returns:
+// 1. *Persistent
+//    Returns the Persistent instance
+func NewPersistentStorage(storage Storage) *Persistent {
+	return &Persistent{storage: storage}
+}
+
 // GetConfgKey is used to get the key to retrieve the configuration from gRPC.
 // Parameters:
 // 1. storageType : string
@@ -143,3 +179,136 @@ func (pStorage *Persistent) Remove(keyname string) error {
 func (pStorage *Persistent) Store(data []byte, key string) (string, error) {
 	return pStorage.storage.Store(data, key)
 }
+
+// StoreWithMetadata is used to store the data along with user metadata in
+// Persistent memory.
+//
+// Parameters:
+// 1. data : []byte
+//    Refers to the image buffer to be stored in ImageStore.
+// 2. key : string
+//    Refers to the image handle of the image to be stored.
+// 3. metadata : map[string]string
+//    Refers to the user metadata saved with the image.
+//
+// Returns:
+// 1. string
+//    Returns the image handle of the image stored.
+// 2. error
+//    Returns an error object if store fails.
+func (pStorage *Persistent) StoreWithMetadata(data []byte, key string, metadata map[string]string) (string, error) {
+	return pStorage.storage.StoreWithMetadata(data, key, metadata)
+}
+
+// StoreWithMetadataSync is used to store the data along with user metadata
+// in Persistent memory, returning once it is durably written.
+//
+// Parameters:
+// 1. data : []byte
+//    Refers to the image buffer to be stored in ImageStore.
+// 2. key : string
+//    Refers to the image handle of the image to be stored.
+// 3. metadata : map[string]string
+//    Refers to the user metadata saved with the image.
+//
+// Returns:
+// 1. string
+//    Returns the image handle of the image stored.
+// 2. error
+//    Returns an error object if writing the image fails.
+func (pStorage *Persistent) StoreWithMetadataSync(data []byte, key string, metadata map[string]string) (string, error) {
+	if storage, ok := pStorage.storage.(syncStorage); ok {
+		return storage.StoreWithMetadataSync(data, key, metadata)
+	}
+	// The other storages write before returning
+	return pStorage.storage.StoreWithMetadata(data, key, metadata)
+}
+
+// Stat is used to get the details of data stored in Persistent memory.
+//
+// Parameters:
+// 1. keyname : string
+//    Refers to the image handle of the image.
+//
+// Returns:
+// 1. common.ObjectInfo
+//    Returns the details and user metadata of the image.
+// 2. error
+//    Returns an error object if the image does not exist.
+func (pStorage *Persistent) Stat(keyname string) (common.ObjectInfo, error) {
+	return pStorage.storage.Stat(keyname)
+}
+
+// List is used to list the data stored in Persistent memory.
+//
+// Parameters:
+// 1. prefix : string
+//    Refers to the prefix of the image handles to list.
+// 2. doneCh : <-chan struct{}
+//    Refers to the channel closed to stop the listing early.
+//
+// Returns:
+// 1. <-chan common.ObjectInfo
+//    Returns the channel of listed images.
+func (pStorage *Persistent) List(prefix string, doneCh <-chan struct{}) <-chan common.ObjectInfo {
+	return pStorage.storage.List(prefix, doneCh)
+}
+
+// SetMetadata is used to replace the user metadata of data stored in
+// Persistent memory.
+//
+// Parameters:
+// 1. keyname : string
+//    Refers to the image handle of the image.
+// 2. metadata : map[string]string
+//    Refers to the new user metadata of the image.
+//
+// Returns:
+// 1. error
+//    Returns an error object if the update fails.
+func (pStorage *Persistent) SetMetadata(keyname string, metadata map[string]string) error {
+	return pStorage.storage.SetMetadata(keyname, metadata)
+}
+
+// Reconfigure is used to replace the clients of every persistent storage
+// created, e.g. when the credentials changed, without dropping the queued
+// stores.
+//
+// Parameters:
+// 1. config : map[string]string
+//    Refers to the new persistent config.
+// 2. restart : func() error
+//    Refers to the function restarting the storage server with the new
+//    config while the stores are paused.
+//
+// Returns:
+// 1. error
+//    Returns an error object if reconfiguring fails.
+func Reconfigure(config map[string]string, restart func() error) error {
+	return minio.Reconfigure(config, restart)
+}
+
+// SetStoreListener is used to be notified of the outcome of the writes of
+// every persistent storage, which are done asynchronously.
+//
+// Parameters:
+// 1. listener : func(common.StoreResult)
+//    Refers to the function called after every write, nil to stop the
+//    notifications.
+func SetStoreListener(listener func(common.StoreResult)) {
+	minio.SetStoreListener(listener)
+}
+
+// SetSpool is used to keep the failed writes of every persistent storage in
+// a local spool, replayed with backoff until they succeed.
+//
+// Parameters:
+// 1. walSpool : *spool.Spool
+//    Refers to the spool the failed writes are kept in.
+// 2. minBackoff : time.Duration
+//    Refers to the wait after the first failed replay.
+// 3. maxBackoff : time.Duration
+//    Refers to the longest wait between replays.
+func SetSpool(walSpool *spool.Spool, minBackoff time.Duration, maxBackoff time.Duration) {
+	minio.SetSpool(walSpool, minBackoff, maxBackoff)
+}
@@ -24,11 +24,20 @@ SOFTWARE.
 package minio
 
 import (
+	common "IEdgeInsights/ImageStore/common"
+	imaging "IEdgeInsights/ImageStore/go/imagestore/imaging"
+	metrics "IEdgeInsights/ImageStore/metrics"
+	spool "IEdgeInsights/ImageStore/spool"
 	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
+	"fmt"
 	"io"
-
-	//"time"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/golang/glog"
 	minio "github.com/minio/minio-go"
@@ -40,6 +49,9 @@ const bucketName string = "image-store-bucket"
 // Constant for the region in Minio
 const region string = "gateway"
 
+// Prefix of the user metadata headers returned by Minio
+const userMetadataPrefix string = "X-Amz-Meta-"
+
 // Max number of buffers in the channel and workers for consuming it
 const (
 	maxBuffers int = 100
@@ -48,14 +60,177 @@ const (
 
 // DataBuffer - Struct for holding the buffers for the store workers
 type DataBuffer struct {
-	buffer []byte
-	key    string
+	buffer   []byte
+	key      string
+	metadata map[string]string
+	// done receives the outcome of the write if set, for synchronous stores
+	done chan<- error
 }
 
 // MinioStorage is a struct used to have default variables used for minio and to comprise methods of minio to it's scope
 type MinioStorage struct {
-	client   *(minio.Client)
-	dataChan chan DataBuffer
+	// mutex guards the clients, which are replaced by Reconfigure
+	mutex         sync.RWMutex
+	client        *(minio.Client)
+	workerClients []*(minio.Client)
+	dataChan      chan DataBuffer
+}
+
+// Every MinioStorage created, reconfigured together by Reconfigure
+var (
+	storagesMutex sync.Mutex
+	storages      []*MinioStorage
+)
+
+// put writes an object to Minio, replaced by the tests
+var put = putObject
+
+// storeListener is called by the store workers after every write
+var (
+	listenerMutex sync.RWMutex
+	storeListener func(common.StoreResult)
+)
+
+// SetStoreListener is used to be notified of the outcome of the writes of
+// every MinioStorage, which are done asynchronously by the store workers.
+//
+// Parameters:
+// 1. listener : func(common.StoreResult)
+//    Refers to the function called from the store workers after every
+//    write, nil to stop the notifications.
+func SetStoreListener(listener func(common.StoreResult)) {
+	listenerMutex.Lock()
+	defer listenerMutex.Unlock()
+	storeListener = listener
+}
+
+// getStoreListener returns the current store listener, may be nil
+func getStoreListener() func(common.StoreResult) {
+	listenerMutex.RLock()
+	defer listenerMutex.RUnlock()
+	return storeListener
+}
+
+// writeSpool keeps the writes which failed until they are replayed
+var (
+	spoolMutex sync.RWMutex
+	writeSpool *spool.Spool
+)
+
+// SetSpool is used to keep the failed writes of every MinioStorage in a
+// local spool instead of dropping them. The spooled writes, including the
+// ones left by a previous run, are replayed oldest first, with a backoff
+// while Minio keeps failing. Only the writes failing transiently are
+// spooled, and the spooled writes Minio rejects are moved to the dead-letter
+// directory of the spool.
+//
+// Parameters:
+// 1. walSpool : *spool.Spool
+//    Refers to the spool the failed writes are kept in.
+// 2. minBackoff : time.Duration
+//    Refers to the wait after the first failed replay.
+// 3. maxBackoff : time.Duration
+//    Refers to the longest wait, the wait doubling after every failed
+//    replay.
+func SetSpool(walSpool *spool.Spool, minBackoff time.Duration, maxBackoff time.Duration) {
+	spoolMutex.Lock()
+	writeSpool = walSpool
+	spoolMutex.Unlock()
+
+	setSpoolMetrics(walSpool)
+	go replaySpool(walSpool, minBackoff, maxBackoff)
+}
+
+// getSpool returns the current spool, may be nil
+func getSpool() *spool.Spool {
+	spoolMutex.RLock()
+	defer spoolMutex.RUnlock()
+	return writeSpool
+}
+
+// setSpoolMetrics updates the gauges of the pending writes
+func setSpoolMetrics(walSpool *spool.Spool) {
+	entries, size := walSpool.Len()
+	metrics.Set("spool_pending_entries", int64(entries))
+	metrics.Set("spool_pending_bytes", size)
+}
+
+// replaySpool is the function writing the spooled writes to Minio, oldest
+// first, as long as the process runs
+func replaySpool(walSpool *spool.Spool, minBackoff time.Duration, maxBackoff time.Duration) {
+	backoff := minBackoff
+	for {
+		name, entry, ok, err := walSpool.Oldest()
+		if !ok {
+			<-walSpool.Notify()
+			continue
+		}
+		if err != nil {
+			glog.Errorf("Dropping unreadable spool entry %s: %v", name, err)
+			metrics.Add("spool_corrupt_entries", 1)
+			if err := walSpool.Remove(name); err != nil {
+				glog.Errorf("Failed to remove spool entry %s: %v", name, err)
+			}
+			setSpoolMetrics(walSpool)
+			continue
+		}
+
+		if err := replayEntry(entry); err != nil && !transientError(err) {
+			glog.Errorf("Moving spooled %s to the dead letters, Minio rejected it: %v", entry.Key, err)
+			metrics.Add("spool_dead_letter_entries", 1)
+			if err := walSpool.DeadLetter(name); err != nil {
+				glog.Errorf("Failed to move spool entry %s to the dead letters, dropping it: %v", name, err)
+				walSpool.Remove(name)
+			}
+			setSpoolMetrics(walSpool)
+			notifyStored(entry.Key, entry.Data, entry.Metadata, err)
+			continue
+		} else if err != nil {
+			glog.V(1).Infof("Failed to replay spooled %s, retrying in %v: %v", entry.Key, backoff, err)
+			metrics.Add("spool_replay_errors", 1)
+			time.Sleep(backoff)
+			backoff *= 2
+			if backoff > maxBackoff {
+				backoff = maxBackoff
+			}
+			continue
+		}
+		backoff = minBackoff
+
+		if err := walSpool.Remove(name); err != nil {
+			glog.Errorf("Failed to remove spool entry %s: %v", name, err)
+		}
+		glog.Infof("Replayed spooled %s", entry.Key)
+		metrics.Add("spool_replayed_entries", 1)
+		setSpoolMetrics(walSpool)
+		notifyStored(entry.Key, entry.Data, entry.Metadata, nil)
+	}
+}
+
+// replayEntry writes a spooled write with the client of the first storage
+func replayEntry(entry spool.Entry) error {
+	storagesMutex.Lock()
+	if len(storages) == 0 {
+		storagesMutex.Unlock()
+		return errors.New("no Minio storage to replay with")
+	}
+	storage := storages[0]
+	storagesMutex.Unlock()
+
+	// Holding the read lock makes Reconfigure wait for this store
+	storage.mutex.RLock()
+	defer storage.mutex.RUnlock()
+	return put(storage.client, entry.Key, entry.Data, entry.Metadata)
+}
+
+// transientError tells whether a failed write may succeed once retried. The
+// requests Minio rejects, e.g. for an invalid key, a denied access or a too
+// large object, fail the same way every time.
+func transientError(err error) bool {
+	status := minio.ToErrorResponse(err).StatusCode
+	// No status for the connection errors
+	return status == 0 || status >= http.StatusInternalServerError ||
+		status == http.StatusRequestTimeout || status == http.StatusTooManyRequests
 }
 
 // missingKeyError is helper method for reporting a missing key in the Minio configuration
@@ -73,7 +248,8 @@ func missingKeyError(key string) error {
 	return errors.New(msg)
 }
 
-// missingKeyError is helper method for reporting a missing key in the Minio configuration
+// initClient is helper method for creating a Minio client, creating the
+// bucket if needed
 //
 // Parameters:
 // 1. config : map[string]string
@@ -85,6 +261,31 @@ func missingKeyError(key string) error {
 // 1. error
 //    Returns an error object if initialization fails.
 func initClient(config map[string]string) (*minio.Client, error) {
+	client, err := newClient(config)
+	if err != nil {
+		return nil, err
+	}
+
+	// Check if the bucket exists
+	glog.Infof("Checking if Minio bucket already exists")
+	found, err := client.BucketExists(bucketName)
+	if err != nil {
+		glog.Errorf("Failed to verify existence of bucket: %v", err)
+		return nil, err
+	}
+
+	if !found {
+		// Create the bucket if it does not exist
+		glog.Infof("Creating bucket")
+		client.MakeBucket(bucketName, region)
+	}
+
+	return client, nil
+}
+
+// newClient creates a Minio client from the config without connecting to
+// the server
+func newClient(config map[string]string) (*minio.Client, error) {
 	glog.Infof("Pulling out config values")
 	host, ok := config["Host"]
 	if !ok {
@@ -131,21 +332,6 @@ func initClient(config map[string]string) (*minio.Client, error) {
 		glog.Errorf("Failed to connect to Minio server: %v", err)
 		return nil, err
 	}
-
-	// Check if the bucket exists
-	glog.Infof("Checking if Minio bucket already exists")
-	found, err := client.BucketExists(bucketName)
-	if err != nil {
-		glog.Errorf("Failed to verify existence of bucket: %v", err)
-		return nil, err
-	}
-
-	if !found {
-		// Create the bucket if it does not exist
-		glog.Infof("Creating bucket")
-		client.MakeBucket(bucketName, region)
-	}
-
 	return client, nil
 }
 
@@ -170,20 +356,90 @@ func NewMinioStorage(config map[string]string) (*MinioStorage, error) {
 	dataChan := make(chan DataBuffer, maxBuffers)
 
 	minioStorage := &MinioStorage{client: client, dataChan: dataChan}
+	minioStorage.workerClients, err = initWorkerClients(config)
+	if err != nil {
+		// Error has already been logged
+		return nil, err
+	}
 
 	// Start store workers
 	for i := 0; i < maxWorkers; i++ {
-		client, err := initClient(config)
+		go storeWorker(minioStorage, i)
+	}
+
+	storagesMutex.Lock()
+	storages = append(storages, minioStorage)
+	storagesMutex.Unlock()
 
+	glog.Infof("Initialization finished")
+	return minioStorage, nil
+}
+
+// initWorkerClients creates a client for every store worker, the bucket
+// being checked by the client of the storage
+func initWorkerClients(config map[string]string) ([]*minio.Client, error) {
+	clients := make([]*minio.Client, maxWorkers)
+	for i := range clients {
+		client, err := newClient(config)
 		if err != nil {
-			// Error has already been logged
 			return nil, err
 		}
-		go storeWorker(minioStorage, client)
+		clients[i] = client
 	}
+	return clients, nil
+}
 
-	glog.Infof("Initialization finished")
-	return minioStorage, nil
+// Reconfigure is used to replace the clients of every MinioStorage, e.g.
+// when the credentials changed. Every new client is created before the
+// clients are swapped and restart is called, with the store workers paused,
+// so the frames queued meanwhile are stored with the new clients.
+//
+// Parameters:
+// 1. config : map[string]string
+//    Refers to the new minio config.
+// 2. restart : func() error
+//    Refers to the function restarting the Minio server with the new
+//    config, returning once it is up. The clients already use the new
+//    config if it fails, so the caller can not recover from it.
+//
+// Returns:
+// 1. error
+//    Returns an error object if the new clients can not be created, the
+//    previous clients are kept and restart is not called in that case, or
+//    if restart fails.
+func Reconfigure(config map[string]string, restart func() error) error {
+	storagesMutex.Lock()
+	defer storagesMutex.Unlock()
+
+	clients := make([]*minio.Client, len(storages))
+	workerClients := make([][]*minio.Client, len(storages))
+	for i := range storages {
+		var err error
+		if clients[i], err = newClient(config); err != nil {
+			return err
+		}
+		if workerClients[i], err = initWorkerClients(config); err != nil {
+			return err
+		}
+	}
+
+	// Waiting for the in-flight stores to finish
+	for _, storage := range storages {
+		storage.mutex.Lock()
+		defer storage.mutex.Unlock()
+	}
+	for i, storage := range storages {
+		storage.client = clients[i]
+		storage.workerClients = workerClients[i]
+	}
+	return restart()
+}
+
+// getClient returns the current client
+func (pMinioStorage *MinioStorage) getClient() *minio.Client {
+	pMinioStorage.mutex.RLock()
+	defer pMinioStorage.mutex.RUnlock()
+	return pMinioStorage.client
 }
 
 // Read is used to read the stored data from Minio.
@@ -199,7 +455,7 @@ func NewMinioStorage(config map[string]string) (*MinioStorage, error) {
 //    Returns an error object if read fails.
 func (pMinioStorage *MinioStorage) Read(keyname string) (io.ReadCloser, error) {
 	// Get the object from the store
-	obj, err := pMinioStorage.client.GetObject(
+	obj, err := pMinioStorage.getClient().GetObject(
 		bucketName, keyname, minio.GetObjectOptions{})
 	if err != nil {
 		return nil, err
@@ -218,7 +474,7 @@ func (pMinioStorage *MinioStorage) Read(keyname string) (io.ReadCloser, error) {
 // 1. error
 //    Returns an object object if remove fails.
 func (pMinioStorage *MinioStorage) Remove(keyname string) error {
-	return pMinioStorage.client.RemoveObject(bucketName, keyname)
+	return pMinioStorage.getClient().RemoveObject(bucketName, keyname)
 }
 
 // Store  is used to store the data in Minio.
@@ -233,33 +489,234 @@ func (pMinioStorage *MinioStorage) Remove(keyname string) error {
 // 2. error
 //    Returns an error object if store fails.
 func (pMinioStorage *MinioStorage) Store(data []byte, key string) (string, error) {
-	pMinioStorage.dataChan <- DataBuffer{data, key}
+	return pMinioStorage.StoreWithMetadata(data, key, nil)
+}
+
+// StoreWithMetadata is used to store the data in Minio along with user
+// metadata.
+//
+// Parameters:
+// 1. data : []byte
+//    Refers to the image buffer to be stored in ImageStore.
+// 2. key : string
+//    Refers to the image handle of the image to be stored.
+// 3. metadata : map[string]string
+//    Refers to the user metadata saved on the object, may be nil.
+//
+// Returns:
+// 1. string
+//    Returns the image handle of the image stored.
+// 2. error
+//    Returns an error object if store fails.
+func (pMinioStorage *MinioStorage) StoreWithMetadata(data []byte, key string, metadata map[string]string) (string, error) {
+	pMinioStorage.dataChan <- DataBuffer{buffer: data, key: key, metadata: metadata}
+	return key, nil
+}
+
+// StoreWithMetadataSync is used to store the data in Minio along with user
+// metadata, returning once the object is written by a store worker.
+//
+// Parameters:
+// 1. data : []byte
+//    Refers to the image buffer to be stored in ImageStore.
+// 2. key : string
+//    Refers to the image handle of the image to be stored.
+// 3. metadata : map[string]string
+//    Refers to the user metadata saved on the object, may be nil.
+//
+// Returns:
+// 1. string
+//    Returns the image handle of the image stored.
+// 2. error
+//    Returns an error object if writing the object fails, including when
+//    the object is kept in the spool to be replayed.
+func (pMinioStorage *MinioStorage) StoreWithMetadataSync(data []byte, key string, metadata map[string]string) (string, error) {
+	done := make(chan error, 1)
+	pMinioStorage.dataChan <- DataBuffer{buffer: data, key: key, metadata: metadata, done: done}
+	if err := <-done; err != nil {
+		return "", err
+	}
 	return key, nil
 }
 
+// Stat is used to get the details and user metadata of a stored object.
+//
+// Parameters:
+// 1. keyname : string
+//    Refers to the image handle of the object.
+//
+// Returns:
+// 1. common.ObjectInfo
+//    Returns the details of the object.
+// 2. error
+//    Returns an error object if the object does not exist.
+func (pMinioStorage *MinioStorage) Stat(keyname string) (common.ObjectInfo, error) {
+	info, err := pMinioStorage.getClient().StatObject(
+		bucketName, keyname, minio.StatObjectOptions{})
+	if err != nil {
+		return common.ObjectInfo{}, err
+	}
+	return toObjectInfo(info), nil
+}
+
+// SetMetadata is used to replace the user metadata of a stored object. The
+// object is copied onto itself, which updates its LastModified time, so the
+// previous LastModified time is kept as the capture time of the objects
+// having none, and their retention age is not restarted.
+//
+// Parameters:
+// 1. keyname : string
+//    Refers to the image handle of the object.
+// 2. metadata : map[string]string
+//    Refers to the new user metadata of the object.
+//
+// Returns:
+// 1. error
+//    Returns an error object if the update fails.
+func (pMinioStorage *MinioStorage) SetMetadata(keyname string, metadata map[string]string) error {
+	info, err := pMinioStorage.getClient().StatObject(
+		bucketName, keyname, minio.StatObjectOptions{})
+	if err != nil {
+		return err
+	}
+
+	// The content type is replaced along with the user metadata
+	userMeta := map[string]string{"Content-Type": info.ContentType}
+	for key, value := range metadata {
+		userMeta[key] = value
+	}
+	if _, ok := userMeta[common.MetaCaptured]; !ok {
+		userMeta[common.MetaCaptured] = info.LastModified.UTC().Format(time.RFC3339Nano)
+	}
+
+	dst, err := minio.NewDestinationInfo(bucketName, keyname, nil, userMeta)
+	if err != nil {
+		return err
+	}
+	src := minio.NewSourceInfo(bucketName, keyname, nil)
+	return pMinioStorage.getClient().CopyObject(dst, src)
+}
+
+// List is used to list the stored objects. User metadata is not returned by
+// the listing, use Stat to get it.
+//
+// Parameters:
+// 1. prefix : string
+//    Refers to the prefix of the keys to list.
+// 2. doneCh : <-chan struct{}
+//    Refers to the channel closed to stop the listing early.
+//
+// Returns:
+// 1. <-chan common.ObjectInfo
+//    Returns the channel of the listed objects, an object with Err set is
+//    sent if the listing fails.
+func (pMinioStorage *MinioStorage) List(prefix string, doneCh <-chan struct{}) <-chan common.ObjectInfo {
+	objectsCh := make(chan common.ObjectInfo)
+	go func() {
+		defer close(objectsCh)
+		for obj := range pMinioStorage.getClient().ListObjects(bucketName, prefix, true, doneCh) {
+			select {
+			case objectsCh <- toObjectInfo(obj):
+			case <-doneCh:
+				return
+			}
+		}
+	}()
+	return objectsCh
+}
+
+// toObjectInfo converts the minio object details
+func toObjectInfo(info minio.ObjectInfo) common.ObjectInfo {
+	metadata := make(map[string]string)
+	for header, values := range info.Metadata {
+		if strings.HasPrefix(header, userMetadataPrefix) && len(values) > 0 {
+			metadata[strings.TrimPrefix(header, userMetadataPrefix)] = values[0]
+		}
+	}
+	return common.ObjectInfo{
+		Key:          info.Key,
+		Size:         info.Size,
+		LastModified: info.LastModified,
+		ContentType:  info.ContentType,
+		Metadata:     metadata,
+		Err:          info.Err,
+	}
+}
+
 // storeWorker is the worker function storing data into the Minio DB
 // We start maxWorkers number of workers to ingest data to the DB.
 //
 // Parameters:
 // 1. pMinioStorage : MinioStorage
 //    Context of the Minio Image Store
-func storeWorker(pMinioStorage *MinioStorage, client *(minio.Client)) {
+// 2. id : int
+//    Index of the worker client used by this worker
+func storeWorker(pMinioStorage *MinioStorage, id int) {
 
 	for {
 		buf := <-pMinioStorage.dataChan
 
-		buffer := bytes.NewReader(buf.buffer)
-		bufLen := int64(buffer.Len())
-		n, err := client.PutObject(bucketName, buf.key, buffer,
-			bufLen, minio.PutObjectOptions{})
+		// Holding the read lock makes Reconfigure wait for this store
+		pMinioStorage.mutex.RLock()
+		client := pMinioStorage.workerClients[id]
+		err := put(client, buf.key, buf.buffer, buf.metadata)
+		pMinioStorage.mutex.RUnlock()
+
+		// A spooled write is durable, it is reported once replayed. The
+		// synchronous stores still fail, the frame not being in Minio yet.
+		spooled := false
+		if walSpool := getSpool(); err != nil && walSpool != nil && transientError(err) {
+			spoolErr := walSpool.Put(spool.Entry{Key: buf.key, Metadata: buf.metadata, Data: buf.buffer})
+			if spoolErr != nil {
+				glog.Errorf("Failed to spool %s, the frame is lost: %v", buf.key, spoolErr)
+				metrics.Add("spool_rejected_entries", 1)
+			} else {
+				glog.Warningf("Spooled %s until Minio accepts it", buf.key)
+				metrics.Add("spool_spooled_entries", 1)
+				setSpoolMetrics(walSpool)
+				spooled = true
+				err = fmt.Errorf("written to the local spool only, pending replay to Minio: %v", err)
+			}
+		}
 
-		if err != nil {
-			glog.Errorf("Failed to put object into Minio for %s: %v", buf.key, err)
+		if !spooled {
+			notifyStored(buf.key, buf.buffer, buf.metadata, err)
 		}
-		if n < bufLen {
-			glog.Errorf("Failed to push all of the bytes to Minio for key %s", buf.key)
+		if buf.done != nil {
+			buf.done <- err
 		}
-		buffer = nil
 		buf.buffer = nil
 	}
 }
+
+// putObject writes an object to Minio with the given client
+func putObject(client *minio.Client, key string, data []byte, metadata map[string]string) error {
+	buffer := bytes.NewReader(data)
+	bufLen := int64(buffer.Len())
+	contentType := imaging.DetectContentType(data)
+	n, err := client.PutObject(bucketName, key, buffer,
+		bufLen, minio.PutObjectOptions{ContentType: contentType, UserMetadata: metadata})
+	if err != nil {
+		glog.Errorf("Failed to put object into Minio for %s: %v", key, err)
+		return err
+	}
+	if n < bufLen {
+		glog.Errorf("Failed to push all of the bytes to Minio for key %s", key)
+		return errors.New("short write to Minio")
+	}
+	return nil
+}
+
+// notifyStored calls the store listener, if any, with the outcome of a write
+func notifyStored(key string, data []byte, metadata map[string]string, err error) {
+	listener := getStoreListener()
+	if listener == nil {
+		return
+	}
+	result := common.StoreResult{Key: key, Size: int64(len(data)), Metadata: metadata, Err: err}
+	if err == nil {
+		sum := sha256.Sum256(data)
+		result.Checksum = hex.EncodeToString(sum[:])
+	}
+	listener(result)
+}
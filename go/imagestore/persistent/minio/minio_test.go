@@ -0,0 +1,227 @@
+/*
+Copyright (c) 2021 Intel Corporation
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package minio
+
+import (
+	spool "IEdgeInsights/ImageStore/spool"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	minio "github.com/minio/minio-go"
+)
+
+// fakePut replaces the writes to Minio, failing with err if set and
+// rejecting the invalid keys
+type fakePut struct {
+	mutex   sync.Mutex
+	err     error
+	invalid map[string]bool
+	keys    []string
+}
+
+func (fake *fakePut) put(client *minio.Client, key string, data []byte, metadata map[string]string) error {
+	fake.mutex.Lock()
+	defer fake.mutex.Unlock()
+	if fake.invalid[key] {
+		return minio.ErrInvalidObjectName("invalid key " + key)
+	}
+	if fake.err == nil {
+		fake.keys = append(fake.keys, key)
+	}
+	return fake.err
+}
+
+func (fake *fakePut) fail(err error) {
+	fake.mutex.Lock()
+	defer fake.mutex.Unlock()
+	fake.err = err
+}
+
+// newTestStorage returns a storage with a single store worker writing
+// through the fake
+func newTestStorage(t *testing.T, fake *fakePut) *MinioStorage {
+	put = fake.put
+	storage := &MinioStorage{workerClients: []*minio.Client{nil}, dataChan: make(chan DataBuffer)}
+	go storeWorker(storage, 0)
+
+	storagesMutex.Lock()
+	storages = []*MinioStorage{storage}
+	storagesMutex.Unlock()
+	return storage
+}
+
+// newTestSpool returns an empty spool used by the store workers, not
+// replayed
+func newTestSpool(t *testing.T) (*spool.Spool, string) {
+	dir, err := ioutil.TempDir("", "spool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	walSpool, err := spool.Open(dir, 1<<20, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	spoolMutex.Lock()
+	writeSpool = walSpool
+	spoolMutex.Unlock()
+	return walSpool, dir
+}
+
+func TestTransientError(t *testing.T) {
+	tests := []struct {
+		err       error
+		transient bool
+	}{
+		{errors.New("connection refused"), true},
+		{minio.ErrorResponse{StatusCode: http.StatusServiceUnavailable, Code: "SlowDown"}, true},
+		{minio.ErrorResponse{StatusCode: http.StatusRequestTimeout, Code: "RequestTimeout"}, true},
+		{minio.ErrorResponse{StatusCode: http.StatusForbidden, Code: "AccessDenied"}, false},
+		{minio.ErrInvalidObjectName("invalid key"), false},
+		{minio.ErrEntityTooLarge(1<<40, 1<<30, bucketName, "key"), false},
+	}
+	for _, test := range tests {
+		if transient := transientError(test.err); transient != test.transient {
+			t.Errorf("transientError(%v) = %v, want %v", test.err, transient, test.transient)
+		}
+	}
+}
+
+func TestSpoolTransientErrors(t *testing.T) {
+	fake := &fakePut{}
+	storage := newTestStorage(t, fake)
+	walSpool, dir := newTestSpool(t)
+	defer os.RemoveAll(dir)
+
+	fake.fail(minio.ErrorResponse{StatusCode: http.StatusForbidden, Code: "AccessDenied"})
+	if _, err := storage.StoreWithMetadataSync([]byte("data"), "denied", nil); err == nil {
+		t.Errorf("Denied write succeeded")
+	}
+	if entries, _ := walSpool.Len(); entries != 0 {
+		t.Errorf("Denied write was spooled")
+	}
+
+	fake.fail(errors.New("connection refused"))
+	storage.StoreWithMetadataSync([]byte("data"), "unreachable", nil)
+	if _, entry, ok, _ := walSpool.Oldest(); !ok || entry.Key != "unreachable" {
+		t.Errorf("Failed write was not spooled, oldest entry %v", entry.Key)
+	}
+}
+
+func TestReplayDeadLetter(t *testing.T) {
+	fake := &fakePut{invalid: map[string]bool{"invalid": true}}
+	newTestStorage(t, fake)
+	walSpool, dir := newTestSpool(t)
+	defer os.RemoveAll(dir)
+
+	walSpool.Put(spool.Entry{Key: "invalid", Data: []byte("data")})
+	walSpool.Put(spool.Entry{Key: "valid", Data: []byte("data")})
+	name, _, _, _ := walSpool.Oldest()
+	go replaySpool(walSpool, time.Millisecond, time.Millisecond)
+
+	// The rejected entry is moved out of the way of the next ones
+	deadLetter := filepath.Join(dir, spool.DeadLetterDir, name)
+	for i := 0; i < 100; i++ {
+		if entries, _ := walSpool.Len(); entries == 0 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if _, err := os.Stat(deadLetter); err != nil {
+		t.Errorf("Rejected entry was not dead lettered: %v", err)
+	}
+	fake.mutex.Lock()
+	defer fake.mutex.Unlock()
+	if len(fake.keys) != 1 || fake.keys[0] != "valid" {
+		t.Errorf("Replayed %v, want valid", fake.keys)
+	}
+}
+
+func TestStoreSync(t *testing.T) {
+	fake := &fakePut{}
+	storage := newTestStorage(t, fake)
+	spoolMutex.Lock()
+	writeSpool = nil
+	spoolMutex.Unlock()
+
+	if key, err := storage.StoreWithMetadataSync([]byte("data"), "written", nil); err != nil || key != "written" {
+		t.Errorf("StoreWithMetadataSync() = %s, %v, want written", key, err)
+	}
+	fake.fail(errors.New("connection refused"))
+	if _, err := storage.StoreWithMetadataSync([]byte("data"), "lost", nil); err == nil {
+		t.Errorf("Failed write succeeded without a spool")
+	}
+
+	// The spooled frame is not in Minio yet, so the caller is told
+	walSpool, dir := newTestSpool(t)
+	defer os.RemoveAll(dir)
+	if _, err := storage.StoreWithMetadataSync([]byte("data"), "spooled", nil); err == nil {
+		t.Errorf("Spooled write succeeded")
+	}
+	if entries, _ := walSpool.Len(); entries != 1 {
+		t.Errorf("Failed write was not spooled")
+	}
+}
+
+func TestReconfigure(t *testing.T) {
+	fake := &fakePut{}
+	storage := newTestStorage(t, fake)
+	config := map[string]string{
+		"Host":      "localhost",
+		"Port":      "9000",
+		"AccessKey": "admin",
+		"SecretKey": "password",
+		"Ssl":       "maybe",
+	}
+
+	// Nothing changes when the new clients can not be created
+	restarted := false
+	restart := func() error {
+		restarted = true
+		return nil
+	}
+	if err := Reconfigure(config, restart); err == nil || restarted {
+		t.Errorf("Invalid config applied, restarted: %v", restarted)
+	}
+	if storage.client != nil || storage.workerClients[0] != nil {
+		t.Errorf("Clients replaced by an invalid config")
+	}
+
+	// The clients are swapped before the restart
+	config["Ssl"] = "false"
+	restart = func() error {
+		restarted = storage.workerClients[0] != nil
+		return nil
+	}
+	if err := Reconfigure(config, restart); err != nil || !restarted {
+		t.Errorf("Reconfigure() = %v, restarted with the new clients: %v", err, restarted)
+	}
+	if storage.client == nil || len(storage.workerClients) != maxWorkers {
+		t.Errorf("Clients not replaced")
+	}
+}
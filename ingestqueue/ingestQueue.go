@@ -0,0 +1,180 @@
+/*
+Copyright (c) 2021 Intel Corporation
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+// Package ingestqueue buffers the frames received on a topic until they are
+// stored, so a slow storage does not stall the message bus subscriber
+package ingestqueue
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// Drop policies applied when the queue is full
+const (
+	// DropOldest drops the oldest queued frames to make room
+	DropOldest = "drop_oldest"
+	// DropNewest drops the incoming frame
+	DropNewest = "drop_newest"
+	// Block waits for room, stalling the subscriber
+	Block = "block"
+)
+
+// Frame is a frame waiting to be stored. Keys are the object keys of the
+// blobs, the same as their handles unless a key template is applied.
+type Frame struct {
+	Blobs    [][]byte
+	Handles  []string
+	Keys     []string
+	Metadata map[string]interface{}
+	Captured time.Time
+}
+
+// Size - function to get the number of bytes of the blobs of the frame
+func (frame *Frame) Size() int64 {
+	var size int64
+	for _, blob := range frame.Blobs {
+		size += int64(len(blob))
+	}
+	return size
+}
+
+// Queue is a bounded FIFO of frames, limited in frames and in bytes
+type Queue struct {
+	mutex     sync.Mutex
+	cond      *sync.Cond
+	frames    []*Frame
+	bytes     int64
+	maxFrames int
+	maxBytes  int64
+	policy    string
+	closed    bool
+}
+
+// NewQueue - function to create a queue.
+//
+// Parameters:
+// 1. maxFrames : int
+//    Refers to the maximum number of queued frames, 0 for no limit.
+// 2. maxBytes : int64
+//    Refers to the maximum number of queued bytes, 0 for no limit.
+// 3. policy : string
+//    Refers to the drop policy applied when the queue is full, DropOldest,
+//    DropNewest or Block.
+//
+// Returns:
+// 1. *Queue
+//    Returns the queue.
+// 2. error
+//    Returns an error object if the policy is unknown or the queue is not
+//    bounded.
+func NewQueue(maxFrames int, maxBytes int64, policy string) (*Queue, error) {
+	if policy != DropOldest && policy != DropNewest && policy != Block {
+		return nil, errors.New("unknown drop policy: " + policy)
+	}
+	if maxFrames <= 0 && maxBytes <= 0 {
+		return nil, errors.New("queue needs maxFrames or maxBytes")
+	}
+	queue := &Queue{maxFrames: maxFrames, maxBytes: maxBytes, policy: policy}
+	queue.cond = sync.NewCond(&queue.mutex)
+	return queue, nil
+}
+
+// full reports whether frame does not fit in the queue. A frame always fits
+// in an empty queue, so frames larger than maxBytes are still stored.
+func (queue *Queue) full(frame *Frame) bool {
+	if len(queue.frames) == 0 {
+		return false
+	}
+	if queue.maxFrames > 0 && len(queue.frames) >= queue.maxFrames {
+		return true
+	}
+	return queue.maxBytes > 0 && queue.bytes+frame.Size() > queue.maxBytes
+}
+
+// Push - function to queue a frame, applying the drop policy when the queue
+// is full. It returns the dropped frames, either frame itself or the oldest
+// queued frames. Frames pushed to a closed queue are dropped.
+func (queue *Queue) Push(frame *Frame) []*Frame {
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+
+	var dropped []*Frame
+	for !queue.closed && queue.full(frame) {
+		switch queue.policy {
+		case DropNewest:
+			return []*Frame{frame}
+		case DropOldest:
+			dropped = append(dropped, queue.frames[0])
+			queue.bytes -= queue.frames[0].Size()
+			queue.frames[0] = nil
+			queue.frames = queue.frames[1:]
+		case Block:
+			queue.cond.Wait()
+		}
+	}
+	if queue.closed {
+		return append(dropped, frame)
+	}
+
+	queue.frames = append(queue.frames, frame)
+	queue.bytes += frame.Size()
+	queue.cond.Broadcast()
+	return dropped
+}
+
+// Pop - function to dequeue the oldest frame, waiting for one. It returns
+// false once the queue is closed and empty.
+func (queue *Queue) Pop() (*Frame, bool) {
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+
+	for len(queue.frames) == 0 {
+		if queue.closed {
+			return nil, false
+		}
+		queue.cond.Wait()
+	}
+	frame := queue.frames[0]
+	queue.frames[0] = nil
+	queue.frames = queue.frames[1:]
+	queue.bytes -= frame.Size()
+	queue.cond.Broadcast()
+	return frame, true
+}
+
+// Len - function to get the number of frames and bytes queued
+func (queue *Queue) Len() (int, int64) {
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+	return len(queue.frames), queue.bytes
+}
+
+// Close - function to close the queue. The queued frames can still be
+// popped, the frames pushed afterwards are dropped.
+func (queue *Queue) Close() {
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+	queue.closed = true
+	queue.cond.Broadcast()
+}
@@ -0,0 +1,120 @@
+/*
+Copyright (c) 2021 Intel Corporation
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package ingestqueue
+
+import (
+	"testing"
+	"time"
+)
+
+func frame(handle string, size int) *Frame {
+	return &Frame{Blobs: [][]byte{make([]byte, size)}, Handles: []string{handle}}
+}
+
+// handles pops the queued frames and returns their handles
+func handles(queue *Queue) []string {
+	var result []string
+	for {
+		if count, _ := queue.Len(); count == 0 {
+			return result
+		}
+		frame, _ := queue.Pop()
+		result = append(result, frame.Handles[0])
+	}
+}
+
+func expectHandles(t *testing.T, actual []string, expected ...string) {
+	t.Helper()
+	if len(actual) != len(expected) {
+		t.Errorf("Frames %v, expected %v", actual, expected)
+		return
+	}
+	for i := range actual {
+		if actual[i] != expected[i] {
+			t.Errorf("Frames %v, expected %v", actual, expected)
+			return
+		}
+	}
+}
+
+func TestDropPolicies(t *testing.T) {
+	queue, _ := NewQueue(2, 0, DropNewest)
+	queue.Push(frame("a", 1))
+	queue.Push(frame("b", 1))
+	if dropped := queue.Push(frame("c", 1)); len(dropped) != 1 || dropped[0].Handles[0] != "c" {
+		t.Errorf("Drop newest dropped %v", dropped)
+	}
+	expectHandles(t, handles(queue), "a", "b")
+
+	// Limited in bytes, dropping the oldest frames to make room
+	queue, _ = NewQueue(0, 10, DropOldest)
+	queue.Push(frame("a", 4))
+	queue.Push(frame("b", 4))
+	if dropped := queue.Push(frame("c", 8)); len(dropped) != 2 {
+		t.Errorf("Drop oldest dropped %d frames, expected 2", len(dropped))
+	}
+	// A frame larger than the limit is queued when the queue is empty
+	queue.Push(frame("d", 20))
+	expectHandles(t, handles(queue), "d")
+}
+
+func TestBlock(t *testing.T) {
+	queue, _ := NewQueue(1, 0, Block)
+	queue.Push(frame("a", 1))
+
+	pushed := make(chan struct{})
+	go func() {
+		queue.Push(frame("b", 1))
+		close(pushed)
+	}()
+
+	select {
+	case <-pushed:
+		t.Fatalf("Push to a full blocking queue returned")
+	case <-time.After(20 * time.Millisecond):
+	}
+	if frame, _ := queue.Pop(); frame.Handles[0] != "a" {
+		t.Errorf("Popped %s, expected a", frame.Handles[0])
+	}
+	<-pushed
+
+	queue.Close()
+	if frame, ok := queue.Pop(); !ok || frame.Handles[0] != "b" {
+		t.Errorf("Queued frame lost on close")
+	}
+	if _, ok := queue.Pop(); ok {
+		t.Errorf("Pop of a closed empty queue succeeded")
+	}
+	if dropped := queue.Push(frame("c", 1)); len(dropped) != 1 {
+		t.Errorf("Push to a closed queue was not dropped")
+	}
+}
+
+func TestNewQueueErrors(t *testing.T) {
+	if _, err := NewQueue(1, 0, "drop_random"); err == nil {
+		t.Errorf("Unknown drop policy accepted")
+	}
+	if _, err := NewQueue(0, 0, Block); err == nil {
+		t.Errorf("Unbounded queue accepted")
+	}
+}
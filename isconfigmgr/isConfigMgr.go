@@ -25,6 +25,7 @@ package isconfigmgr
 import (
 	util "IEdgeInsights/common/util"
 	"encoding/json"
+	"errors"
 	"io/ioutil"
 
 	"github.com/golang/glog"
@@ -33,14 +34,106 @@ import (
 // Configuration type struct
 type Configuration struct {
 	Minio struct {
-		AccessKey             string `json:"accessKey"`
-		SecretKey             string `json:"secretKey"`
-		RetentionTime         string `json:"retentionTime"`
-		RetentionPollInterval string `json:"retentionPollInterval,omitempty"`
-		Ssl                   string `json:"ssl"`
-		ReplyEndpoint         string `json:"replyEndpoint"`
-		Host                  string `json:"host"`
+		AccessKey             string  `json:"accessKey"`
+		SecretKey             string  `json:"secretKey"`
+		RetentionTime         string  `json:"retentionTime"`
+		RetentionPollInterval string  `json:"retentionPollInterval,omitempty"`
+		Ssl                   string  `json:"ssl"`
+		ReplyEndpoint         string  `json:"replyEndpoint"`
+		Host                  string  `json:"host"`
+		MaxStorageBytes       int64   `json:"maxStorageBytes,omitempty"`
+		StorageLowWatermark   float64 `json:"storageLowWatermark,omitempty"`
+		EvictionPolicy        string  `json:"evictionPolicy,omitempty"`
 	} `json:"minio"`
+	StorePolicies         map[string]StorePolicy  `json:"storePolicies,omitempty"`
+	SimilarityIndex       bool                    `json:"similarityIndex,omitempty"`
+	SyncStore             bool                    `json:"syncStore,omitempty"`
+	CaptureTimestampKey   string                  `json:"captureTimestampKey,omitempty"`
+	BlobHandleFormat      string                  `json:"blobHandleFormat,omitempty"`
+	IngestPolicies        map[string]IngestPolicy `json:"ingestPolicies,omitempty"`
+	TopicMaxStorageBytes  map[string]int64        `json:"topicMaxStorageBytes,omitempty"`
+	RetentionPolicies     []RetentionPolicy       `json:"retentionPolicies,omitempty"`
+	DiskPressure          *DiskPressure           `json:"diskPressure,omitempty"`
+	Archive               *Archive                `json:"archive,omitempty"`
+	AuditLog              *AuditLog               `json:"auditLog,omitempty"`
+	SubscriberSupervision *SubscriberSupervision  `json:"subscriberSupervision,omitempty"`
+	Spool                 *Spool                  `json:"spool,omitempty"`
+}
+
+// Spool type struct
+type Spool struct {
+	Path       string `json:"path,omitempty"`
+	MaxBytes   int64  `json:"maxBytes,omitempty"`
+	MaxEntries int    `json:"maxEntries,omitempty"`
+	MinBackoff string `json:"minBackoff,omitempty"`
+	MaxBackoff string `json:"maxBackoff,omitempty"`
+}
+
+// SubscriberSupervision type struct
+type SubscriberSupervision struct {
+	IdleTimeout string `json:"idleTimeout,omitempty"`
+	MaxErrors   int    `json:"maxErrors,omitempty"`
+	MinBackoff  string `json:"minBackoff,omitempty"`
+	MaxBackoff  string `json:"maxBackoff,omitempty"`
+}
+
+// AuditLog type struct
+type AuditLog struct {
+	Path     string `json:"path,omitempty"`
+	MaxBytes int64  `json:"maxBytes,omitempty"`
+	MaxFiles *int   `json:"maxFiles,omitempty"`
+}
+
+// Archive type struct
+type Archive struct {
+	Type          string `json:"type"`
+	Bucket        string `json:"bucket,omitempty"`
+	Path          string `json:"path,omitempty"`
+	RetentionTime string `json:"retentionTime"`
+	PollInterval  string `json:"pollInterval"`
+}
+
+// DiskPressure type struct
+type DiskPressure struct {
+	Path              string   `json:"path,omitempty"`
+	LowFreePercent    float64  `json:"lowFreePercent"`
+	HighFreePercent   float64  `json:"highFreePercent"`
+	CheckInterval     string   `json:"checkInterval,omitempty"`
+	LowPriorityTopics []string `json:"lowPriorityTopics,omitempty"`
+}
+
+// RetentionPolicy type struct
+type RetentionPolicy struct {
+	Topic         string `json:"topic,omitempty"`
+	Prefix        string `json:"prefix,omitempty"`
+	Match         string `json:"match,omitempty"`
+	RetentionTime string `json:"retentionTime"`
+	PollInterval  string `json:"pollInterval"`
+}
+
+// IngestPolicy type struct
+type IngestPolicy struct {
+	Filter      string       `json:"filter,omitempty"`
+	EveryNth    int          `json:"everyNth,omitempty"`
+	MaxFPS      float64      `json:"maxFps,omitempty"`
+	OnChange    []string     `json:"onChange,omitempty"`
+	Bypass      string       `json:"bypass,omitempty"`
+	Queue       *IngestQueue `json:"queue,omitempty"`
+	KeyTemplate string       `json:"keyTemplate,omitempty"`
+}
+
+// IngestQueue type struct
+type IngestQueue struct {
+	MaxFrames  int    `json:"maxFrames,omitempty"`
+	MaxBytes   int64  `json:"maxBytes,omitempty"`
+	DropPolicy string `json:"dropPolicy,omitempty"`
+}
+
+// StorePolicy type struct
+type StorePolicy struct {
+	RequireImage bool `json:"requireImage"`
+	MaxWidth     int  `json:"maxWidth,omitempty"`
+	MaxHeight    int  `json:"maxHeight,omitempty"`
 }
 
 // Minio type struct
@@ -52,34 +145,48 @@ type Minio struct {
 	Ssl                   string
 	ReplyEndpoint         string
 	Host                  string
+	MaxStorageBytes       int64
+	StorageLowWatermark   float64
+	EvictionPolicy        string
 }
 
-// ReadMinIoConfig - function to read Minio configuration
-func ReadMinIoConfig(conf map[string]interface{}) (Minio, error) {
+// parseConfig - function to validate the app config against schema.json and
+// unmarshal it
+func parseConfig(conf map[string]interface{}) (Configuration, error) {
 
-	var minIoConfig Minio
 	var tempConfig Configuration
 	value, err := json.Marshal(conf)
 	if err != nil {
 		glog.Errorf("Error:Conversion from json to string")
-		return minIoConfig, err
+		return tempConfig, err
 	}
 
 	// Reading schema json
 	schema, err := ioutil.ReadFile("./schema.json")
 	if err != nil {
 		glog.Errorf("Schema file not found")
-		return minIoConfig, err
+		return tempConfig, err
 	}
 
 	// Validating config json
 	if util.ValidateJSON(string(schema), string(value)) != true {
-		return minIoConfig, err
+		return tempConfig, errors.New("Config does not match schema.json")
 	}
 
 	err = json.Unmarshal([]byte(string(value)), &tempConfig)
 	if err != nil {
 		glog.Errorf("Error while json.Unmarshal")
+		return tempConfig, err
+	}
+	return tempConfig, nil
+}
+
+// ReadMinIoConfig - function to read Minio configuration
+func ReadMinIoConfig(conf map[string]interface{}) (Minio, error) {
+
+	var minIoConfig Minio
+	tempConfig, err := parseConfig(conf)
+	if err != nil {
 		return minIoConfig, err
 	}
 
@@ -88,5 +195,13 @@ func ReadMinIoConfig(conf map[string]interface{}) (Minio, error) {
 	minIoConfig.RetentionTime = tempConfig.Minio.RetentionTime
 	minIoConfig.RetentionPollInterval = tempConfig.Minio.RetentionPollInterval
 	minIoConfig.Ssl = tempConfig.Minio.Ssl
+	minIoConfig.MaxStorageBytes = tempConfig.Minio.MaxStorageBytes
+	minIoConfig.StorageLowWatermark = tempConfig.Minio.StorageLowWatermark
+	minIoConfig.EvictionPolicy = tempConfig.Minio.EvictionPolicy
 	return minIoConfig, nil
 }
+
+// ReadConfig - function to read the complete ImageStore configuration
+func ReadConfig(conf map[string]interface{}) (Configuration, error) {
+	return parseConfig(conf)
+}
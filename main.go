@@ -25,16 +25,32 @@ package main
 import (
 	eiicfgmgr "ConfigMgr/eiiconfigmgr"
 	eiimsgbus "EIIMessageBus/eiimsgbus"
+	audit "IEdgeInsights/ImageStore/audit"
 	common "IEdgeInsights/ImageStore/common"
+	diskMonitor "IEdgeInsights/ImageStore/diskmonitor"
+	events "IEdgeInsights/ImageStore/events"
 	imagestore "IEdgeInsights/ImageStore/go/imagestore"
+	archive "IEdgeInsights/ImageStore/go/imagestore/archive"
+	hashindex "IEdgeInsights/ImageStore/go/imagestore/hashindex"
+	imaging "IEdgeInsights/ImageStore/go/imagestore/imaging"
+	persistent "IEdgeInsights/ImageStore/go/imagestore/persistent"
 	isConfigMgr "IEdgeInsights/ImageStore/isconfigmgr"
+	metrics "IEdgeInsights/ImageStore/metrics"
+	retention "IEdgeInsights/ImageStore/retention"
+	spool "IEdgeInsights/ImageStore/spool"
 	subManager "IEdgeInsights/ImageStore/submanager"
 	util "IEdgeInsights/common/util"
 
+	"errors"
 	"flag"
+	"fmt"
+	"image"
 	"io"
+	"math"
 	"os"
 	"os/exec"
+	"strconv"
+	"syscall"
 	"time"
 
 	"github.com/golang/glog"
@@ -46,9 +62,39 @@ const (
 	maxFrameSize = 1024 * 1024 * 64 // 64MB
 )
 
+const (
+	bucketName          = "image-store-bucket"
+	region              = "gateway"
+	minioMetadataPrefix = "X-Amz-Meta-"
+)
+
 // IsServer is a struct used to implement ImageStore.IsServer
 type IsServer struct {
-	is *imagestore.ImageStore
+	is       *imagestore.ImageStore
+	policies map[string]*imaging.Policy
+	index    *hashindex.Index
+	engine   *retention.Engine
+	auditLog *audit.Log
+	subMgr   *subManager.SubManager
+	// syncStore is the default of the sync attribute of the store command
+	syncStore bool
+}
+
+// Default number of matches returned by the similar command
+const defaultSimilarCount = 10
+
+// Number of events buffered while the message bus is slow
+const eventBufferSize = 1000
+
+// Commands which do not operate on a single image handle
+var handleOptional = map[string]bool{
+	common.SimilarCode:         true,
+	common.MetricsCode:         true,
+	common.ListPinnedCode:      true,
+	common.RetentionReportCode: true,
+	common.SubscribeCode:       true,
+	common.UnsubscribeCode:     true,
+	common.SubscriptionsCode:   true,
 }
 
 func main() {
@@ -63,25 +109,75 @@ func main() {
 
 	defer configMgr.Destroy()
 
-	subCtx, err := configMgr.GetSubscriberByIndex(0)
+	numSubscribers, err := configMgr.GetNumSubscribers()
 	if err != nil {
-		glog.Errorf("Error: %v to GetSubscriberByName", err)
+		glog.Errorf("Error: %v to GetNumSubscribers", err)
 		return
 	}
 
-	defer subCtx.Destroy()
+	// Every subscriber interface has its own message bus config and topics
+	subscribers := make([]subscriberInterface, 0, numSubscribers)
+	for i := 0; i < numSubscribers; i++ {
+		subCtx, err := configMgr.GetSubscriberByIndex(i)
+		if err != nil {
+			glog.Errorf("Error: %v to GetSubscriberByIndex %d", err, i)
+			return
+		}
+
+		defer subCtx.Destroy()
 
-	topics, err := subCtx.GetTopics()
-	if err != nil {
-		glog.Errorf("Failed to fetch topics : %v", err)
-		return
+		topics, err := subCtx.GetTopics()
+		if err != nil {
+			glog.Errorf("Failed to fetch topics of subscriber %d : %v", i, err)
+			return
+		}
+
+		subConfig, err := subCtx.GetMsgbusConfig()
+		if err != nil {
+			glog.Errorf("Error: %v to get subscriber %d MsgbusConfig", err, i)
+			return
+		}
+		// The name selects the interface of the topics subscribed at runtime
+		name := strconv.Itoa(i)
+		if nameVal, err := subCtx.GetInterfaceValue("Name"); err == nil {
+			if nameStr, err := nameVal.GetString(); err == nil && nameStr != "" {
+				name = nameStr
+			}
+		}
+		subscribers = append(subscribers, subscriberInterface{name: name, topics: topics, config: subConfig})
 	}
 
-	subConfig, err := subCtx.GetMsgbusConfig()
+	// The events are published on the first topic of the first publisher
+	// interface, if any
+	numPublishers, err := configMgr.GetNumPublishers()
 	if err != nil {
-		glog.Errorf("Error: %v to get subscriber MsgbusConfig", err)
+		glog.Errorf("Error: %v to GetNumPublishers", err)
 		return
 	}
+	var eventTopic string
+	var eventConfig map[string]interface{}
+	if numPublishers > 0 {
+		pubCtx, err := configMgr.GetPublisherByIndex(0)
+		if err != nil {
+			glog.Errorf("Error: %v to GetPublisherByIndex 0", err)
+			return
+		}
+
+		defer pubCtx.Destroy()
+
+		topics, err := pubCtx.GetTopics()
+		if err != nil || len(topics) == 0 {
+			glog.Errorf("Failed to fetch topics of publisher 0 : %v", err)
+			return
+		}
+		eventTopic = topics[0]
+
+		eventConfig, err = pubCtx.GetMsgbusConfig()
+		if err != nil {
+			glog.Errorf("Error: %v to get publisher MsgbusConfig", err)
+			return
+		}
+	}
 
 	serverCtx, err := configMgr.GetServerByIndex(0)
 	if err != nil {
@@ -125,6 +221,28 @@ func main() {
 		os.Exit(-1)
 	}
 
+	isConfig, err := isConfigMgr.ReadConfig(appConfig)
+	if err != nil {
+		glog.Errorf("Error while reading config :" + err.Error())
+		os.Exit(-1)
+	}
+
+	policies := make(map[string]*imaging.Policy)
+	for topic, storePolicy := range isConfig.StorePolicies {
+		policies[topic] = &imaging.Policy{
+			RequireImage: storePolicy.RequireImage,
+			MaxWidth:     storePolicy.MaxWidth,
+			MaxHeight:    storePolicy.MaxHeight,
+		}
+	}
+
+	// The index is shared by the subscribers adding to it and the service
+	// querying it
+	var index *hashindex.Index
+	if isConfig.SimilarityIndex {
+		index = hashindex.NewIndex()
+	}
+
 	defer glog.Flush()
 	respMapMinio := make(map[string]string)
 	// Converting struct to MapchunkSize
@@ -139,71 +257,374 @@ func main() {
 
 	done := make(chan bool)
 
-	go StartMinio(respMapMinio)
+	restarts := make(chan minioRestart)
+	go StartMinio(respMapMinio, restarts)
 
-	if respMapMinio["RetentionTime"] != "-1" {
-		glog.Infof("Starting Minio retention thread")
-		go StartMinioRetentionPolicy(respMapMinio)
-	} else {
-		glog.Infof("Image retention time is infinite")
+	settings, err := retention.NewSettings(minIoConfig, isConfig)
+	if err != nil {
+		glog.Errorf("Error while reading retention config :" + err.Error())
+		os.Exit(-1)
+	}
+
+	tracker := imagestore.NewReadTracker()
+
+	var monitor *diskMonitor.DiskMonitor
+	var evictions <-chan int64
+	lowPriority := make(map[string]bool)
+	if isConfig.DiskPressure != nil {
+		monitor, err = diskMonitor.FromConfig(isConfig.DiskPressure)
+		if err != nil {
+			glog.Errorf("Error while reading disk pressure config :" + err.Error())
+			os.Exit(-1)
+		}
+		evictions = monitor.Evictions()
+		for _, topic := range isConfig.DiskPressure.LowPriorityTopics {
+			lowPriority[topic] = true
+		}
+		monitor.Start()
+	}
+
+	// Expired images are moved to the archive tier if set instead of being
+	// deleted
+	var arch archive.Archive
+	var archiveRule *retention.Rule
+	if isConfig.Archive != nil {
+		archiveRule, err = retention.NewRule("archive", isConfig.Archive.RetentionTime, isConfig.Archive.PollInterval)
+		if err == nil {
+			arch, err = archive.FromConfig(isConfig.Archive, bucketName, region, newMinioClient(respMapMinio))
+		}
+		if err != nil {
+			glog.Errorf("Error while reading archive config :" + err.Error())
+			os.Exit(-1)
+		}
+	}
+
+	var auditLog *audit.Log
+	if isConfig.AuditLog != nil {
+		auditLog, err = audit.FromConfig(isConfig.AuditLog)
+		if err != nil {
+			glog.Errorf("Error while opening the audit log :" + err.Error())
+			os.Exit(-1)
+		}
+	}
+
+	// Writes failing while Minio or its disk is unavailable are spooled
+	// and replayed instead of being dropped
+	if isConfig.Spool != nil {
+		walSpool, minBackoff, maxBackoff, err := spool.FromConfig(isConfig.Spool)
+		if err != nil {
+			glog.Errorf("Error while opening the write-ahead spool :" + err.Error())
+			os.Exit(-1)
+		}
+		if entries, size := walSpool.Len(); entries > 0 {
+			glog.Infof("Replaying %d spooled writes, %d bytes", entries, size)
+		}
+		imagestore.SetSpool(walSpool, minBackoff, maxBackoff)
+	}
+
+	var publisher *events.Publisher
+	if eventConfig != nil {
+		publisher, err = events.Dial(eventConfig, eventTopic, eventBufferSize)
+		if err != nil {
+			glog.Errorf("-- Error starting the events publisher: %v\n", err)
+			os.Exit(-1)
+		}
+		defer publisher.Close()
+		glog.Infof("Publishing store events on topic %s", eventTopic)
+	}
+
+	// The images are indexed and published once written by the store
+	// workers, including the spooled ones once replayed
+	if index != nil || publisher != nil {
+		imagestore.SetStoreListener(func(result common.StoreResult) {
+			if index != nil {
+				imagestore.IndexStored(index, result)
+			}
+			if publisher != nil {
+				publisher.Emit(events.FromStoreResult(result))
+			}
+		})
+	}
+
+	minioPort := common.MinioPort
+	if !util.CheckPortAvailability("", minioPort) {
+		glog.Errorf("Minio port: %s not up, so exiting...", minioPort)
+		os.Exit(-1)
+	}
+	storage, err := persistent.NewPersistent(persistent.MINIO, respMapMinio)
+	if err != nil {
+		glog.Errorf("Error while creating the retention storage :" + err.Error())
+		os.Exit(-1)
+	}
+
+	topicIngests, err := subManager.IngestPoliciesFromConfig(isConfig.IngestPolicies)
+	if err != nil {
+		glog.Errorf("Error while reading ingest policies :" + err.Error())
+		os.Exit(-1)
+	}
+
+	// Frames stored under a key template are still read by their handle
+	var handles *imagestore.HandleIndex
+	for _, topicSettings := range topicIngests {
+		if topicSettings.KeyTemplate != "" {
+			handles = imagestore.NewHandleIndex()
+			break
+		}
+	}
+
+	// The retention engine always runs to answer dry-run reports, it only
+	// sweeps when retention is configured
+	engine := retention.New(retention.Config{
+		Storage:     storage,
+		Settings:    settings,
+		Tracker:     tracker,
+		Index:       index,
+		Handles:     handles,
+		Archive:     arch,
+		ArchiveRule: archiveRule,
+		AuditLog:    auditLog,
+		Events:      publisher,
+		Evictions:   evictions,
+	})
+	engine.Start()
+	defer engine.Stop()
+
+	// The subscriptions are started by startSubScriber and changed at
+	// runtime through the service
+	subMgr := subManager.NewSubManager()
+	if isConfig.SubscriberSupervision != nil {
+		supervision, err := subManager.SupervisionFromConfig(isConfig.SubscriberSupervision)
+		if err != nil {
+			glog.Errorf("Error while reading subscriber supervision config :" + err.Error())
+			os.Exit(-1)
+		}
+		subMgr.SetSupervision(supervision)
+	}
+
+	go startReqReply(serverConfig{
+		minioConfig:   respMapMinio,
+		serviceName:   serviceName,
+		serviceConfig: serviceConfig,
+		policies:      policies,
+		index:         index,
+		handles:       handles,
+		tracker:       tracker,
+		engine:        engine,
+		archive:       arch,
+		auditLog:      auditLog,
+		subMgr:        subMgr,
+		syncStore:     isConfig.SyncStore,
+	})
+
+	// Frames of low priority topics are rejected while the disk is under
+	// pressure
+	var gate func() error
+	if monitor != nil {
+		gate = func() error {
+			if monitor.UnderPressure() {
+				metrics.Add("disk_pressure_rejected_frames", 1)
+				return errors.New("disk is under pressure, not accepting low priority topic")
+			}
+			return nil
+		}
 	}
 
-	go startReqReply(respMapMinio, serviceName, serviceConfig)
+	ingest := subManager.IngestConfig{
+		CaptureTimestampKey: isConfig.CaptureTimestampKey,
+		BlobHandleFormat:    isConfig.BlobHandleFormat,
+	}
+	if ingest.CaptureTimestampKey == "" {
+		ingest.CaptureTimestampKey = common.DefaultCaptureTimestampKey
+	}
+	if ingest.BlobHandleFormat == "" {
+		ingest.BlobHandleFormat = common.DefaultBlobHandleFormat
+	}
 
-	go startSubScriber(respMapMinio, topics, subConfig)
+	go startSubScriber(subscriberConfig{
+		subMgr:       subMgr,
+		minioConfig:  respMapMinio,
+		subscribers:  subscribers,
+		policies:     policies,
+		index:        index,
+		handles:      handles,
+		lowPriority:  lowPriority,
+		gate:         gate,
+		ingest:       ingest,
+		topicIngests: topicIngests,
+	})
+
+	// Retention settings and credentials changed in the app config are
+	// applied without a restart
+	watchObj, err := configMgr.GetWatchObj()
+	if err != nil {
+		glog.Errorf("Failed to watch the app config, changes need a restart: %v", err)
+	} else {
+		reloader := &configReloader{
+			minioConfig: respMapMinio,
+			engine:      engine,
+			arch:        arch,
+			reconfigure: imagestore.Reconfigure,
+			restart: func(config map[string]string) error {
+				started := make(chan struct{})
+				restarts <- minioRestart{config: config, started: started}
+				<-started
+				if !util.CheckPortAvailability("", common.MinioPort) {
+					return errors.New("minio port " + common.MinioPort + " not up after restart")
+				}
+				return nil
+			},
+		}
+		watchObj.WatchConfig(reloader.onChange, nil)
+	}
 	<-done
 	glog.Infof("**************Exiting**************")
 }
 
-func startSubScriber(minioConfigMap map[string]string, topicArray []string, subConfig map[string]interface{}) {
+// subscriberInterface holds the name, topics and message bus config of one
+// of the subscriber interfaces of ImageStore
+type subscriberInterface struct {
+	name   string
+	topics []string
+	config map[string]interface{}
+}
+
+// subscriberConfig holds what startSubScriber needs to store the frames of
+// the subscribed topics
+type subscriberConfig struct {
+	subMgr      *subManager.SubManager
+	minioConfig map[string]string
+	subscribers []subscriberInterface
+	// Store policies, by topic
+	policies map[string]*imaging.Policy
+	// Shared indexes, may be nil
+	index   *hashindex.Index
+	handles *imagestore.HandleIndex
+	// Topics whose stores are rejected by gate, may be nil
+	lowPriority map[string]bool
+	gate        func() error
+	// Ingest config of all the topics, and the policies of some topics
+	ingest       subManager.IngestConfig
+	topicIngests map[string]subManager.IngestConfig
+}
+
+func startSubScriber(config subscriberConfig) {
 
 	glog.Infof("**************In startSubScriber**************")
 
+	subMgr := config.subMgr
+	topicArray := make([]string, 0)
+	for _, subscriber := range config.subscribers {
+		topicArray = append(topicArray, subscriber.topics...)
+	}
 	if len(topicArray) <= 0 {
 		glog.Errorf("suscriber list empty")
 		os.Exit(-1)
 	}
 
-	subMgr := subManager.NewSubManager()
-	subMgr.RegSubscriberList(subConfig)
-	subMgr.StartAllSubscribers(topicArray, subConfig)
-
-	for _, topic := range topicArray {
-		is, err := imagestore.GetImageStoreInstance(minioConfigMap)
+	subMgr.SetIngestConfig(config.ingest)
+	subMgr.RegIngestPolicies(config.topicIngests)
+	// The writer of every topic is created before subscribing to it, the
+	// topics subscribed at runtime get their writer the same way
+	newWriter := func(topic string) (common.Writer, error) {
+		is, err := imagestore.GetImageStoreInstance(config.minioConfig)
 		if err != nil {
-			glog.Errorf("%v", err)
+			return nil, err
+		}
+		is.SetPolicy(config.policies[topic])
+		is.SetTopic(topic)
+		is.SetIndex(config.index)
+		is.SetHandleIndex(config.handles)
+		if config.lowPriority[topic] {
+			is.SetStoreGate(config.gate)
+		}
+		return is, nil
+	}
+	subMgr.SetWriterFactory(newWriter)
+
+	// A subscriber interface failing to start does not stop the others,
+	// the subscribers which failed are recreated once receiving
+	for _, subscriber := range config.subscribers {
+		if err := subMgr.StartAllSubscribers(subscriber.name, subscriber.topics, subscriber.config); err != nil {
+			glog.Errorf("Failed to start subscriber %s: %v", subscriber.name, err)
 		}
-		subMgr.RegWriterInterface(topic, is)
 	}
 	subMgr.ReceiveFromAll()
 }
 
-func startReqReply(minioConfigMap map[string]string, serviceName string, serviceConfig map[string]interface{}) {
+// serverConfig holds what startReqReply needs to serve the requests
+type serverConfig struct {
+	minioConfig   map[string]string
+	serviceName   string
+	serviceConfig map[string]interface{}
+	// Store policies, by topic
+	policies map[string]*imaging.Policy
+	// Shared indexes and tracker, the indexes may be nil
+	index   *hashindex.Index
+	handles *imagestore.HandleIndex
+	tracker *imagestore.ReadTracker
+	engine  *retention.Engine
+	// Archive tier and audit log, may be nil
+	archive  archive.Archive
+	auditLog *audit.Log
+	subMgr   *subManager.SubManager
+	// Default of the sync attribute of the store requests
+	syncStore bool
+}
+
+func startReqReply(config serverConfig) {
 
 	var ser IsServer
-	is, err := imagestore.GetImageStoreInstance(minioConfigMap)
+	is, err := imagestore.GetImageStoreInstance(config.minioConfig)
 	ser.is = is
+	ser.policies = config.policies
+	ser.index = config.index
+	ser.engine = config.engine
+	ser.auditLog = config.auditLog
+	ser.subMgr = config.subMgr
+	ser.syncStore = config.syncStore
 	if err != nil {
 		glog.Errorf("Error while GetImageStoreInstance %v", err)
 		os.Exit(-1)
 	}
+	is.SetReadTracker(config.tracker)
+	if config.archive != nil {
+		is.SetArchive(config.archive)
+	}
 
-	client, err := eiimsgbus.NewMsgbusClient(serviceConfig)
+	if config.index != nil {
+		is.SetIndex(config.index)
+		go func() {
+			if err := is.RebuildIndex(); err != nil {
+				glog.Errorf("Failed to rebuild perceptual hash index: %v", err)
+			}
+		}()
+	}
+
+	if config.handles != nil {
+		is.SetHandleIndex(config.handles)
+		go func() {
+			if err := is.RebuildHandleIndex(); err != nil {
+				glog.Errorf("Failed to rebuild the handle index: %v", err)
+			}
+		}()
+	}
+
+	client, err := eiimsgbus.NewMsgbusClient(config.serviceConfig)
 	if err != nil {
 		glog.Errorf("-- Error initializing message bus context: %v\n", err)
 		os.Exit(-1)
 	}
 	defer client.Close()
 
-	glog.Infof("-- Initializing service %v", serviceName)
-	service, err := client.NewService(serviceName)
+	glog.Infof("-- Initializing service %v", config.serviceName)
+	service, err := client.NewService(config.serviceName)
 	if err != nil {
 		glog.Errorf("-- Error initializing service: %v\n", err)
 		os.Exit(-1)
 	}
 	defer service.Close()
 
-	glog.Infof("-- Running service %s\n", serviceName)
+	glog.Infof("-- Running service %s\n", config.serviceName)
 
 	for {
 		var errMessage string
@@ -222,24 +643,51 @@ func startReqReply(minioConfigMap map[string]string, serviceName string, service
 		}
 
 		imgHandle, ok := msg.Data[common.ImageHandle].(string)
-		if ok == false {
+		if ok == false && !handleOptional[command] {
 			errMessage += "Missing " + common.ImageHandle
 			handleError(service, errMessage)
 			continue
 		}
 
-		if len(errMessage) > 0 {
-			handleError(service, errMessage)
-		} else if command == common.ReadCode {
-			handleReadCommand(imgHandle, service, ser)
-		} else if command == common.StoreCode {
+		switch command {
+		case common.ReadCode:
+			handleReadCommand(imgHandle, msg.Data, service, ser)
+		case common.StoreCode:
 			if msg.Blob != nil {
-				handleStoreCommand(imgHandle, service, ser, msg.Blob[0])
+				// Topic is optional, selects the store policy to apply and is
+				// saved in the object metadata
+				topic, _ := msg.Data[common.Topic].(string)
+				sync, ok := msg.Data[common.Sync].(bool)
+				if !ok {
+					sync = ser.syncStore
+				}
+				handleStoreCommand(imgHandle, topic, sync, service, ser, msg.Blob[0])
 			} else {
 				errMessage = "Can not store empty image for handle " + imgHandle
 				handleError(service, errMessage)
 			}
-		} else {
+		case common.SimilarCode:
+			handleSimilarCommand(msg.Data, msg.Blob, service, ser)
+		case common.MetricsCode:
+			service.Response(metrics.Snapshot())
+		case common.PinCode:
+			reason, _ := msg.Data[common.Reason].(string)
+			handlePinCommand(imgHandle, reason, service, ser)
+		case common.UnpinCode:
+			handleUnpinCommand(imgHandle, service, ser)
+		case common.ListPinnedCode:
+			handleListPinnedCommand(service, ser)
+		case common.RetentionReportCode:
+			handleRetentionReportCommand(msg.Data, service, ser)
+		case common.AuditLookupCode:
+			handleAuditLookupCommand(imgHandle, service, ser)
+		case common.SubscribeCode:
+			handleSubscribeCommand(msg.Data, service, ser)
+		case common.UnsubscribeCode:
+			handleUnsubscribeCommand(msg.Data, service, ser)
+		case common.SubscriptionsCode:
+			handleSubscriptionsCommand(service, ser)
+		default:
 			errMessage = "Invalid Command " + command
 			handleError(service, errMessage)
 		}
@@ -251,26 +699,127 @@ func handleError(service *eiimsgbus.Service, errMessage string) {
 	service.Response(map[string]interface{}{common.Error: errMessage})
 }
 
-func handleReadCommand(imgHandle string, service *eiimsgbus.Service, ser IsServer) {
+func handleReadCommand(imgHandle string, params map[string]interface{}, service *eiimsgbus.Service, ser IsServer) {
+
+	options, err := parseTransformOptions(params)
+	if err != nil {
+		error := "Invalid read request for handle " + imgHandle + " Error :" + err.Error()
+		glog.Errorf(error)
+		service.Response(map[string]interface{}{common.Error: error})
+		return
+	}
 
-	frame, err := ser.Read(imgHandle)
+	// Topic is optional and picks the frame of a handle stored under several
+	// keys
+	topic, _ := params[common.Topic].(string)
+	handles, frames, err := ser.ReadFrame(imgHandle, topic)
+	if err == nil && !options.IsEmpty() {
+		for i := range frames {
+			if frames[i], err = imaging.Transform(frames[i], options); err != nil {
+				break
+			}
+		}
+	}
 
 	if err != nil {
 		error := "Reading image failed for handle " + imgHandle + " Error :" + err.Error()
 		glog.Errorf(error)
 		service.Response(map[string]interface{}{common.Error: error})
 	} else {
-		response := make([]interface{}, 2)
-		response[0] = map[string]interface{}{common.ImageHandle: imgHandle}
-		response[1] = frame
+		metadata := map[string]interface{}{common.ImageHandle: imgHandle}
+		if len(handles) > 1 {
+			metadata[common.ImageHandles] = handles
+		}
+		response := make([]interface{}, 0, len(frames)+1)
+		response = append(response, metadata)
+		for _, frame := range frames {
+			response = append(response, frame)
+		}
 		service.Response(response)
 		message := "Successfully read frame with handle:" + imgHandle
 		glog.Infof(message)
 	}
 }
 
-func handleStoreCommand(imgHandle string, service *eiimsgbus.Service, ser IsServer, imgFrame []byte) {
-	key, err := ser.StoreData(imgFrame, imgHandle)
+// parseTransformOptions reads the optional resize and crop attributes of a
+// read request
+func parseTransformOptions(params map[string]interface{}) (imaging.TransformOptions, error) {
+	var options imaging.TransformOptions
+	var err error
+
+	if options.Width, err = intParam(params, common.Width); err != nil {
+		return options, err
+	}
+	if options.Height, err = intParam(params, common.Height); err != nil {
+		return options, err
+	}
+	if options.Width < 0 || options.Height < 0 {
+		return options, errors.New("width and height must not be negative")
+	}
+	if options.Width > imaging.MaxDimension || options.Height > imaging.MaxDimension {
+		return options, fmt.Errorf("width and height must not exceed %d", imaging.MaxDimension)
+	}
+
+	if fit, ok := params[common.Fit]; ok {
+		if options.Fit, ok = fit.(string); !ok {
+			return options, errors.New(common.Fit + " must be a string")
+		}
+	}
+
+	if cropParam, ok := params[common.Crop]; ok {
+		cropMap, ok := cropParam.(map[string]interface{})
+		if !ok {
+			return options, errors.New(common.Crop + " must be a map of x, y, width and height")
+		}
+		var x, y, width, height int
+		if x, err = intParam(cropMap, "x"); err != nil {
+			return options, err
+		}
+		if y, err = intParam(cropMap, "y"); err != nil {
+			return options, err
+		}
+		if width, err = intParam(cropMap, "width"); err != nil {
+			return options, err
+		}
+		if height, err = intParam(cropMap, "height"); err != nil {
+			return options, err
+		}
+		if x < 0 || y < 0 || width <= 0 || height <= 0 {
+			return options, errors.New(common.Crop + " needs a positive width and height and a non negative x and y")
+		}
+		rect := image.Rect(x, y, x+width, y+height)
+		options.Crop = &rect
+	}
+	return options, nil
+}
+
+// intParam reads an optional integer attribute of a request, 0 if missing.
+// The values are limited to 32 bits, so the crop rectangles can not
+// overflow.
+func intParam(params map[string]interface{}, key string) (int, error) {
+	value, ok := params[key]
+	if !ok {
+		return 0, nil
+	}
+	var number float64
+	switch typed := value.(type) {
+	case int:
+		number = float64(typed)
+	case int64:
+		number = float64(typed)
+	case float64:
+		number = typed
+	default:
+		return 0, fmt.Errorf("%s must be a number, not %v", key, value)
+	}
+	if math.IsNaN(number) || number < math.MinInt32 || number > math.MaxInt32 {
+		return 0, fmt.Errorf("%s is out of range: %v", key, value)
+	}
+	return int(number), nil
+}
+
+func handleStoreCommand(imgHandle string, topic string, sync bool, service *eiimsgbus.Service, ser IsServer, imgFrame []byte) {
+	key, err := ser.StoreData(imgFrame, imgHandle, topic, sync)
 	if err != nil {
 		error := "Store image failed for handle " + imgHandle + " Error :" + err.Error()
 		glog.Errorf(error)
@@ -282,18 +831,284 @@ func handleStoreCommand(imgHandle string, service *eiimsgbus.Service, ser IsServ
 	}
 }
 
+func handleSimilarCommand(params map[string]interface{}, blobs [][]byte, service *eiimsgbus.Service, ser IsServer) {
+	matches, err := ser.Similar(params, blobs)
+	if err != nil {
+		handleError(service, "Similarity search failed Error :"+err.Error())
+		return
+	}
+
+	response := make([]interface{}, len(matches))
+	for i, match := range matches {
+		response[i] = map[string]interface{}{
+			common.ImageHandle: match.Key,
+			common.Topic:       match.Topic,
+			common.Distance:    match.Distance,
+			common.Stored:      match.Stored.UTC().Format(time.RFC3339),
+		}
+	}
+	service.Response(map[string]interface{}{common.Matches: response})
+	glog.Infof("Similarity search returned %d matches", len(matches))
+}
+
+func handlePinCommand(imgHandle string, reason string, service *eiimsgbus.Service, ser IsServer) {
+	if err := ser.is.Pin(imgHandle, reason); err != nil {
+		handleError(service, "Pinning image failed for handle "+imgHandle+" Error :"+err.Error())
+		return
+	}
+	service.Response(map[string]interface{}{common.ImageHandle: imgHandle})
+	glog.Infof("Pinned frame with handle:%s reason:%q", imgHandle, reason)
+}
+
+func handleUnpinCommand(imgHandle string, service *eiimsgbus.Service, ser IsServer) {
+	if err := ser.is.Unpin(imgHandle); err != nil {
+		handleError(service, "Unpinning image failed for handle "+imgHandle+" Error :"+err.Error())
+		return
+	}
+	service.Response(map[string]interface{}{common.ImageHandle: imgHandle})
+	glog.Infof("Unpinned frame with handle:%s", imgHandle)
+}
+
+func handleListPinnedCommand(service *eiimsgbus.Service, ser IsServer) {
+	pinned, err := ser.is.ListPinned()
+	if err != nil {
+		handleError(service, "Listing pinned images failed Error :"+err.Error())
+		return
+	}
+
+	response := make([]interface{}, len(pinned))
+	for i, info := range pinned {
+		response[i] = map[string]interface{}{
+			common.ImageHandle: info.Key,
+			common.Topic:       info.Metadata[common.MetaTopic],
+			common.Reason:      info.Metadata[common.MetaPinReason],
+			common.Stored:      info.LastModified.UTC().Format(time.RFC3339),
+		}
+	}
+	service.Response(map[string]interface{}{common.Pinned: response})
+}
+
+func handleRetentionReportCommand(params map[string]interface{}, service *eiimsgbus.Service, ser IsServer) {
+	var retentionTime *time.Duration
+	if value, ok := params[common.RetentionTime]; ok {
+		parsed, err := parseRetentionTime(value)
+		if err != nil {
+			handleError(service, "Invalid retention report request Error :"+err.Error())
+			return
+		}
+		retentionTime = &parsed
+	}
+
+	report := ser.engine.Report(retentionTime)
+	if report == nil {
+		handleError(service, "Retention dry-run failed, see the ImageStore logs")
+		return
+	}
+	service.Response(report.ToMap())
+}
+
+func handleAuditLookupCommand(imgHandle string, service *eiimsgbus.Service, ser IsServer) {
+	if ser.auditLog == nil {
+		handleError(service, "Deletion audit log is disabled, set auditLog in the config")
+		return
+	}
+	records, err := ser.auditLog.Lookup(imgHandle)
+	if err != nil {
+		handleError(service, "Audit lookup failed for handle "+imgHandle+" Error :"+err.Error())
+		return
+	}
+
+	response := make([]interface{}, len(records))
+	for i, record := range records {
+		response[i] = map[string]interface{}{
+			common.Size:    record.Size,
+			common.Age:     record.Age,
+			common.Rule:    record.Rule,
+			common.Reason:  record.Reason,
+			common.Removed: record.Removed.Format(time.RFC3339),
+		}
+	}
+	service.Response(map[string]interface{}{common.ImageHandle: imgHandle, common.Records: response})
+}
+
+func handleSubscribeCommand(params map[string]interface{}, service *eiimsgbus.Service, ser IsServer) {
+	topic, ok := params[common.Topic].(string)
+	if !ok || topic == "" {
+		handleError(service, "Missing "+common.Topic)
+		return
+	}
+	// The first subscriber interface is used if none is given
+	name, _ := params[common.Interface].(string)
+
+	if err := ser.subMgr.Subscribe(topic, name); err != nil {
+		handleError(service, "Subscribing to topic "+topic+" failed Error :"+err.Error())
+		return
+	}
+	service.Response(map[string]interface{}{common.Topic: topic})
+}
+
+func handleUnsubscribeCommand(params map[string]interface{}, service *eiimsgbus.Service, ser IsServer) {
+	topic, ok := params[common.Topic].(string)
+	if !ok || topic == "" {
+		handleError(service, "Missing "+common.Topic)
+		return
+	}
+
+	if err := ser.subMgr.Unsubscribe(topic); err != nil {
+		handleError(service, "Unsubscribing from topic "+topic+" failed Error :"+err.Error())
+		return
+	}
+	service.Response(map[string]interface{}{common.Topic: topic})
+}
+
+func handleSubscriptionsCommand(service *eiimsgbus.Service, ser IsServer) {
+	subscriptions := ser.subMgr.Subscriptions()
+	response := make([]interface{}, len(subscriptions))
+	for i, subscription := range subscriptions {
+		stats := make(map[string]interface{}, len(subscription.Stats))
+		for name, value := range subscription.Stats {
+			stats[name] = value
+		}
+		health := map[string]interface{}{
+			common.Healthy:           subscription.Health.Healthy,
+			common.ConsecutiveErrors: subscription.Health.ConsecutiveErrors,
+			common.Reconnects:        subscription.Health.Reconnects,
+		}
+		if !subscription.Health.LastMessage.IsZero() {
+			health[common.LastMessage] = subscription.Health.LastMessage.UTC().Format(time.RFC3339)
+		}
+		if subscription.Health.LastError != "" {
+			health[common.LastError] = subscription.Health.LastError
+		}
+		response[i] = map[string]interface{}{
+			common.Topic:     subscription.Topic,
+			common.Interface: subscription.Interface,
+			common.Stats:     stats,
+			common.Health:    health,
+		}
+	}
+	service.Response(map[string]interface{}{common.Subscriptions: response})
+}
+
+// parseRetentionTime parses a retention time attribute, "-1" keeps the
+// images forever and is returned as 0
+func parseRetentionTime(value interface{}) (time.Duration, error) {
+	str, ok := value.(string)
+	if !ok {
+		return 0, fmt.Errorf("%s must be a duration string", common.RetentionTime)
+	}
+	if str == "-1" {
+		return 0, nil
+	}
+	retentionTime, err := time.ParseDuration(str)
+	if err != nil {
+		return 0, fmt.Errorf("%s is invalid: %v", common.RetentionTime, err)
+	}
+	if retentionTime <= 0 {
+		return 0, fmt.Errorf("%s must be positive or -1", common.RetentionTime)
+	}
+	return retentionTime, nil
+}
+
+// Similar is used to find the stored images closest to a stored image or to
+// an uploaded blob by perceptual hash.
+//
+// Parameters:
+// 1. params : map[string]interface{}
+//    Refers to the request holding the optional img_handle, count, hash,
+//    topic, start and end attributes.
+// 2. blobs : [][]byte
+//    Refers to the uploaded image, used when img_handle is missing.
+//
+// Returns:
+// 1. []hashindex.Match
+//    Returns the matches, closest first.
+// 2. error
+//    Returns an error object if the search fails.
+func (s *IsServer) Similar(params map[string]interface{}, blobs [][]byte) ([]hashindex.Match, error) {
+	if s.index == nil {
+		return nil, errors.New("similarity index is disabled, set similarityIndex in the config")
+	}
+
+	query := hashindex.Query{Algorithm: imaging.PHash, Count: defaultSimilarCount}
+	if algorithm, ok := params[common.Hash].(string); ok {
+		query.Algorithm = algorithm
+	}
+
+	count, err := intParam(params, common.Count)
+	if err != nil {
+		return nil, err
+	}
+	if count > 0 {
+		query.Count = count
+	}
+
+	query.Topic, _ = params[common.Topic].(string)
+	if query.Start, err = timeParam(params, common.Start); err != nil {
+		return nil, err
+	}
+	if query.End, err = timeParam(params, common.End); err != nil {
+		return nil, err
+	}
+
+	var hashes imaging.Hashes
+	if imgHandle, ok := params[common.ImageHandle].(string); ok {
+		entry, ok := s.index.Get(imgHandle)
+		if !ok {
+			return nil, errors.New("no perceptual hash indexed for handle " + imgHandle)
+		}
+		hashes = entry.Hashes
+		query.Exclude = imgHandle
+	} else if len(blobs) > 0 {
+		if hashes, err = imaging.ComputeHashes(blobs[0]); err != nil {
+			return nil, err
+		}
+	} else {
+		return nil, errors.New("either " + common.ImageHandle + " or an image blob is required")
+	}
+
+	if query.Hash, err = hashes.Get(query.Algorithm); err != nil {
+		return nil, err
+	}
+	return s.index.Nearest(query)
+}
+
+// timeParam reads an optional RFC3339 time attribute of a request
+func timeParam(params map[string]interface{}, key string) (time.Time, error) {
+	value, ok := params[key]
+	if !ok {
+		return time.Time{}, nil
+	}
+	str, ok := value.(string)
+	if !ok {
+		return time.Time{}, fmt.Errorf("%s must be an RFC3339 time string", key)
+	}
+	return time.Parse(time.RFC3339, str)
+}
+
 // StoreData is used to store image buffer in minio.
 //
 // 1. keyname : []byte
 //    Refers to the image frame to be stored.
 // 2. keyname : string
 //    Refers to the image handle of the image to be stored.
+// 3. topic : string
+//    Refers to the topic whose store policy is applied and which is saved in
+//    the object metadata, may be empty.
+// 4. sync : bool
+//    Refers to whether to return only once the image is durably written.
 //
 // Returns:
 // 1. error
-//    Returns an error object if store fails.
-func (s *IsServer) StoreData(blob []byte, keyname string) (string, error) {
-	key, err := s.is.Store(blob, keyname)
+//    Returns an error object if store fails, or the write if sync is set.
+func (s *IsServer) StoreData(blob []byte, keyname string, topic string, sync bool) (string, error) {
+	if policy, ok := s.policies[topic]; ok {
+		if err := policy.Validate(blob); err != nil {
+			glog.Errorf("Store rejected by policy of topic %s: %v", topic, err)
+			return "", err
+		}
+	}
+	key, err := s.is.StoreTopic(blob, keyname, topic, sync)
 	if err != nil {
 		glog.Errorf("Store failed")
 		return "", err
@@ -328,6 +1143,53 @@ func AppendByte(slice []byte, data ...byte) []byte {
 	return slice
 }
 
+// ReadFrame is used to read an image along with the other blobs of its
+// frame if it is the parent of a multi-blob frame. Blobs removed meanwhile
+// are skipped.
+//
+// Parameters:
+// 1. key : string
+//    Refers to the image handle.
+// 2. topic : string
+//    Refers to the topic the image was received on, may be empty.
+//
+// Returns:
+// 1. []string
+//    Returns the handles of the images read, the key of the image first.
+// 2. [][]byte
+//    Returns the images read.
+// 3. error
+//    Returns an error object if reading key fails.
+func (s *IsServer) ReadFrame(key string, topic string) ([]string, [][]byte, error) {
+	key, err := s.is.Resolve(key, topic)
+	if err != nil {
+		glog.Errorf("Read failed: %v", err)
+		return nil, nil, err
+	}
+	frame, err := s.Read(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	handles := []string{key}
+	frames := [][]byte{frame}
+
+	blobHandles, err := s.is.BlobHandles(key)
+	if err != nil {
+		// The frame may only be in the archive tier
+		glog.V(1).Infof("Failed to get the blob handles of %s: %v", key, err)
+		return handles, frames, nil
+	}
+	for i := 1; i < len(blobHandles); i++ {
+		frame, err := s.Read(blobHandles[i])
+		if err != nil {
+			continue
+		}
+		handles = append(handles, blobHandles[i])
+		frames = append(frames, frame)
+	}
+	return handles, frames, nil
+}
+
 func (s *IsServer) Read(key string) ([]byte, error) {
 	output, err := s.is.Read(key)
 	if err != nil {
@@ -364,20 +1226,129 @@ func (s *IsServer) Read(key string) ([]byte, error) {
 // Parameters:
 // 1. minioConfigMap : map[string]string
 //    Refers to the minio config.
-func StartMinio(minioConfigMap map[string]string) {
-	os.Setenv("MINIO_ACCESS_KEY", minioConfigMap["AccessKey"])
-	os.Setenv("MINIO_SECRET_KEY", minioConfigMap["SecretKey"])
-	os.Setenv("MINIO_REGION", "gateway")
-	glog.Infof("Minio port: %v\n", common.MinioPort)
-
-	// TODO: Need to see a way to pass port while bring
-	// as --address switch didn't work as expected
-	cmd := exec.Command("./minio", "server", "--address", common.MinioHost+":"+common.MinioPort, "--certs-dir=.minio/certs", "--config-dir=/tmp", "/data")
-	err := cmd.Run()
-	if err != nil {
-		glog.Errorf("Not able to start minio server: %v", err)
-		os.Exit(-1)
+// 2. restarts : <-chan minioRestart
+//    Refers to the requests to restart the server with a new config, e.g.
+//    when the credentials changed.
+func StartMinio(minioConfigMap map[string]string, restarts <-chan minioRestart) {
+	var started chan struct{}
+	for {
+		os.Setenv("MINIO_ACCESS_KEY", minioConfigMap["AccessKey"])
+		os.Setenv("MINIO_SECRET_KEY", minioConfigMap["SecretKey"])
+		os.Setenv("MINIO_REGION", "gateway")
+		glog.Infof("Minio port: %v\n", common.MinioPort)
+
+		// TODO: Need to see a way to pass port while bring
+		// as --address switch didn't work as expected
+		cmd := exec.Command("./minio", "server", "--address", common.MinioHost+":"+common.MinioPort, "--certs-dir=.minio/certs", "--config-dir=/tmp", "/data")
+		if err := cmd.Start(); err != nil {
+			glog.Errorf("Not able to start minio server: %v", err)
+			os.Exit(-1)
+		}
+		if started != nil {
+			close(started)
+		}
+
+		exited := make(chan error, 1)
+		go func() {
+			exited <- cmd.Wait()
+		}()
+
+		select {
+		case err := <-exited:
+			if err != nil {
+				glog.Errorf("Not able to start minio server: %v", err)
+				os.Exit(-1)
+			}
+			return
+		case restart := <-restarts:
+			glog.Infof("Restarting minio server with the new config")
+			cmd.Process.Signal(syscall.SIGTERM)
+			<-exited
+			minioConfigMap = restart.config
+			started = restart.started
+		}
+	}
+}
+
+// minioRestart asks StartMinio to restart the Minio server with a new
+// config, started is closed once the new server process is started
+type minioRestart struct {
+	config  map[string]string
+	started chan struct{}
+}
+
+// settingsReloader applies new retention settings, e.g. retention.Engine
+type settingsReloader interface {
+	Reload(settings retention.Settings)
+}
+
+// configReloader applies the changes of the app config
+type configReloader struct {
+	minioConfig map[string]string
+	engine      settingsReloader
+	arch        archive.Archive
+	// reconfigure replaces the storage clients, calling restart in between
+	reconfigure func(config map[string]string, restart func() error) error
+	// restart restarts the Minio server with the given config
+	restart func(config map[string]string) error
+}
+
+// onChange is the ConfigMgr watch callback of the app config. The retention
+// settings are applied live. When the credentials changed, the storage
+// clients are rebuilt and the Minio server restarted while the stores are
+// paused. The other settings are only applied on restart.
+func (reloader *configReloader) onChange(key string, value map[string]interface{}, userData interface{}) {
+	glog.Infof("App config %s changed", key)
+	minIoConfig, err := isConfigMgr.ReadMinIoConfig(value)
+	if err != nil {
+		glog.Errorf("Ignoring invalid config change: %v", err)
+		return
+	}
+	isConfig, err := isConfigMgr.ReadConfig(value)
+	if err != nil {
+		glog.Errorf("Ignoring invalid config change: %v", err)
+		return
+	}
+	settings, err := retention.NewSettings(minIoConfig, isConfig)
+	if err != nil {
+		glog.Errorf("Ignoring invalid retention config change: %v", err)
+		return
+	}
+
+	newConfig := make(map[string]string, len(reloader.minioConfig))
+	for configKey, configValue := range reloader.minioConfig {
+		newConfig[configKey] = configValue
+	}
+	newConfig["AccessKey"] = minIoConfig.AccessKey
+	newConfig["SecretKey"] = minIoConfig.SecretKey
+	newConfig["Ssl"] = minIoConfig.Ssl
+	newConfig["RetentionTime"] = minIoConfig.RetentionTime
+	newConfig["RetentionPollInterval"] = minIoConfig.RetentionPollInterval
+
+	if newConfig["AccessKey"] != reloader.minioConfig["AccessKey"] ||
+		newConfig["SecretKey"] != reloader.minioConfig["SecretKey"] ||
+		newConfig["Ssl"] != reloader.minioConfig["Ssl"] {
+		glog.Infof("Minio credentials changed, reconfiguring the storage clients")
+		restart := func() error {
+			// The storage clients already use the new credentials
+			if err := reloader.restart(newConfig); err != nil {
+				glog.Errorf("Failed to restart Minio with the new credentials, exiting: %v", err)
+				os.Exit(-1)
+			}
+			return nil
+		}
+		if err := reloader.reconfigure(newConfig, restart); err != nil {
+			glog.Errorf("Ignoring the credentials change, the storage clients can not be created: %v", err)
+			return
+		}
+		if reloader.arch != nil {
+			reloader.arch.SetClient(newMinioClient(newConfig))
+		}
 	}
+	reloader.minioConfig = newConfig
+
+	reloader.engine.Reload(settings)
+	glog.Infof("Applied the retention config change")
 }
 
 // missingKeyError is a helper method to report a missing key in Minio config
@@ -391,48 +1362,12 @@ func missingKeyError(key string) {
 	return
 }
 
-// StartMinioRetentionPolicy cleans up the ImageStore
-//
-// Parameters:
-// 1. config : map[string]string
-//    Refers to the minio config
-func StartMinioRetentionPolicy(config map[string]string) {
-	defer glog.Flush()
-	glog.Infof("Running minio retention policy")
-	minioPort := common.MinioPort
-	portUp := util.CheckPortAvailability("", minioPort)
-	if !portUp {
-		glog.Errorf("Minio port: %s not up, so exiting...", minioPort)
-		os.Exit(-1)
-	}
-
-	region := "gateway"
-	bucketName := "image-store-bucket"
+// newMinioClient creates a client of the Minio server from the minio config,
+// exiting on invalid config
+func newMinioClient(config map[string]string) *minio.Client {
 	port := common.MinioPort
 	host := common.MinioHost
 
-	retentionTimeStr, ok := config["RetentionTime"]
-	if !ok {
-		missingKeyError("RetentionTime")
-	}
-
-	retentionTime, err := time.ParseDuration(retentionTimeStr)
-	if err != nil {
-		glog.Errorf("Failed to parse retention time duration: %v", err)
-		os.Exit(-1)
-	}
-
-	pollIntervalStr, ok := config["RetentionPollInterval"]
-	if !ok {
-		missingKeyError("RetentionPollInterval")
-	}
-
-	pollInterval, err := time.ParseDuration(pollIntervalStr)
-	if err != nil {
-		glog.Errorf("Failed to parse retention poll interval duration: %v", err)
-		os.Exit(-1)
-	}
-
 	accessKey, ok := config["AccessKey"]
 	if !ok {
 		missingKeyError("AccessKey")
@@ -467,71 +1402,5 @@ func StartMinioRetentionPolicy(config map[string]string) {
 		glog.Errorf("Failed to connect to Minio server: %v", err)
 		os.Exit(-1)
 	}
-
-	// Check if the bucket exists
-	glog.V(1).Infof("Checking if Minio bucket already exists")
-	found, err := client.BucketExists(bucketName)
-	if err != nil {
-		glog.Errorf("Failed to verify existence of bucket: %v", err)
-		os.Exit(-1)
-	}
-
-	if !found {
-		// Create the bucket if it does not exist
-		glog.Infof("Creating bucket")
-		client.MakeBucket(bucketName, region)
-	}
-
-	// Channel for objects to be removed from Minio
-	removeObjects := func() {
-		objectsCh := make(chan string)
-		objectsErrCh := make(chan error, 1)
-		defer close(objectsErrCh)
-
-		// Routine to find objects to remove and send them over the `objectsCh`
-		go func() {
-			glog.V(1).Infof("Finding objects in Minio to delete")
-
-			// Defer channel close to when the function exits
-			defer close(objectsCh)
-
-			for obj := range client.ListObjects(bucketName, "", false, nil) {
-				if obj.Err != nil {
-					glog.Errorf("Failed retrieving objects from Minio: %v", obj.Err)
-					objectsErrCh <- obj.Err
-					return
-				}
-
-				now := time.Now()
-				elapsed := now.Sub(obj.LastModified)
-
-				if elapsed > retentionTime {
-					glog.V(1).Infof("Deleting key: %s", obj.Key)
-					objectsCh <- obj.Key
-				} else {
-					glog.V(2).Infof("Not deleting key: %s", obj.Key)
-				}
-			}
-
-			objectsErrCh <- nil
-		}()
-
-		for rErr := range client.RemoveObjects(bucketName, objectsCh) {
-			glog.Errorf("Error removing objects from Minio: %v", rErr)
-			return
-		}
-
-		if err := <-objectsErrCh; err != nil {
-			return
-		}
-	}
-	ticker := time.NewTicker(pollInterval)
-	defer ticker.Stop()
-
-	removeObjects()
-
-	for range ticker.C {
-		removeObjects()
-	}
-	glog.Infof("Exiting StartMinioRetentionPolicy()...")
+	return client
 }
@@ -0,0 +1,299 @@
+/*
+Copyright (c) 2021 Intel Corporation
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package main
+
+import (
+	common "IEdgeInsights/ImageStore/common"
+	imagestore "IEdgeInsights/ImageStore/go/imagestore"
+	imaging "IEdgeInsights/ImageStore/go/imagestore/imaging"
+	persistent "IEdgeInsights/ImageStore/go/imagestore/persistent"
+	retention "IEdgeInsights/ImageStore/retention"
+	"bytes"
+	"errors"
+	"image"
+	"io"
+	"io/ioutil"
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseTransformOptions(t *testing.T) {
+	tests := []struct {
+		name   string
+		params map[string]interface{}
+		crop   *image.Rectangle
+		fails  bool
+	}{
+		{"empty", map[string]interface{}{}, nil, false},
+		{"size", map[string]interface{}{common.Width: float64(64), common.Height: int64(32)}, nil, false},
+		{"negative", map[string]interface{}{common.Width: float64(-1)}, nil, true},
+		{"too large", map[string]interface{}{common.Width: float64(imaging.MaxDimension + 1)}, nil, true},
+		{"overflow", map[string]interface{}{common.Height: float64(1e20)}, nil, true},
+		{"not a number", map[string]interface{}{common.Height: math.NaN()}, nil, true},
+		{"not numeric", map[string]interface{}{common.Width: "64"}, nil, true},
+		{"fit", map[string]interface{}{common.Fit: 1}, nil, true},
+		{"crop", map[string]interface{}{common.Crop: map[string]interface{}{"x": 1, "y": 2, "width": 3, "height": 4}},
+			&image.Rectangle{Min: image.Point{1, 2}, Max: image.Point{4, 6}}, false},
+		{"empty crop", map[string]interface{}{common.Crop: map[string]interface{}{"x": 1, "y": 2}}, nil, true},
+		{"crop overflow", map[string]interface{}{common.Crop: map[string]interface{}{"x": float64(math.MaxInt64), "y": 0, "width": 1, "height": 1}}, nil, true},
+	}
+	for _, test := range tests {
+		options, err := parseTransformOptions(test.params)
+		if test.fails {
+			if err == nil {
+				t.Errorf("%s: parsed %+v", test.name, options)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: failed to parse: %v", test.name, err)
+			continue
+		}
+		if (options.Crop == nil) != (test.crop == nil) || (test.crop != nil && *options.Crop != *test.crop) {
+			t.Errorf("%s: parsed crop %v, expected %v", test.name, options.Crop, test.crop)
+		}
+	}
+}
+
+func TestParseRetentionTime(t *testing.T) {
+	tests := []struct {
+		value interface{}
+		want  time.Duration
+		valid bool
+	}{
+		{"2h", 2 * time.Hour, true},
+		{"-1", 0, true},
+		{"0s", 0, false},
+		{"-1h", 0, false},
+		{"an hour", 0, false},
+		{float64(3600), 0, false},
+	}
+	for _, test := range tests {
+		got, err := parseRetentionTime(test.value)
+		if (err == nil) != test.valid || got != test.want {
+			t.Errorf("parseRetentionTime(%v) = %v, %v", test.value, got, err)
+		}
+	}
+}
+
+// fakeEngine records the reloaded retention settings
+type fakeEngine struct {
+	reloads []retention.Settings
+}
+
+func (engine *fakeEngine) Reload(settings retention.Settings) {
+	engine.reloads = append(engine.reloads, settings)
+}
+
+// appConfig returns an app config with the given credentials and retention
+func appConfig(accessKey string, retentionTime string) map[string]interface{} {
+	return map[string]interface{}{
+		"minio": map[string]interface{}{
+			"accessKey":             accessKey,
+			"secretKey":             "password",
+			"retentionTime":         retentionTime,
+			"retentionPollInterval": "60s",
+			"ssl":                   "false",
+		},
+	}
+}
+
+// newTestReloader returns a reloader of the config of appConfig("admin",
+// "1h") counting the reconfigurations and restarts
+func newTestReloader(engine *fakeEngine, reconfigureErr error) (*configReloader, *int, *int) {
+	var reconfigures, restarts int
+	reloader := &configReloader{
+		minioConfig: map[string]string{
+			"AccessKey":             "admin",
+			"SecretKey":             "password",
+			"RetentionTime":         "1h",
+			"RetentionPollInterval": "60s",
+			"Ssl":                   "false",
+			"Host":                  "localhost",
+		},
+		engine: engine,
+		reconfigure: func(config map[string]string, restart func() error) error {
+			reconfigures++
+			if reconfigureErr != nil {
+				return reconfigureErr
+			}
+			return restart()
+		},
+		restart: func(config map[string]string) error {
+			restarts++
+			return nil
+		},
+	}
+	return reloader, &reconfigures, &restarts
+}
+
+func TestConfigReloaderRetention(t *testing.T) {
+	engine := &fakeEngine{}
+	reloader, reconfigures, _ := newTestReloader(engine, nil)
+
+	reloader.onChange("/ImageStore/config", appConfig("admin", "2h"), nil)
+	if *reconfigures != 0 {
+		t.Errorf("Storage reconfigured without a credentials change")
+	}
+	if len(engine.reloads) != 1 || engine.reloads[0].Default.RetentionTime != 2*time.Hour {
+		t.Errorf("Retention reloaded with %v, expected a 2h retention", engine.reloads)
+	}
+	if reloader.minioConfig["RetentionTime"] != "2h" || reloader.minioConfig["Host"] != "localhost" {
+		t.Errorf("Minio config %v after the change", reloader.minioConfig)
+	}
+
+	// An invalid change is ignored
+	reloader.onChange("/ImageStore/config", appConfig("admin", "soon"), nil)
+	if len(engine.reloads) != 1 || reloader.minioConfig["RetentionTime"] != "2h" {
+		t.Errorf("Invalid retention time applied")
+	}
+}
+
+func TestConfigReloaderCredentials(t *testing.T) {
+	engine := &fakeEngine{}
+	reloader, reconfigures, restarts := newTestReloader(engine, nil)
+
+	reloader.onChange("/ImageStore/config", appConfig("operator", "1h"), nil)
+	if *reconfigures != 1 || *restarts != 1 {
+		t.Errorf("Credentials change reconfigured %d times, restarted %d times, expected once", *reconfigures, *restarts)
+	}
+	if reloader.minioConfig["AccessKey"] != "operator" || len(engine.reloads) != 1 {
+		t.Errorf("Credentials change not applied, config %v", reloader.minioConfig)
+	}
+
+	// The previous clients are kept when the new ones can not be created
+	engine = &fakeEngine{}
+	reloader, reconfigures, restarts = newTestReloader(engine, errors.New("invalid config"))
+	reloader.onChange("/ImageStore/config", appConfig("operator", "2h"), nil)
+	if *reconfigures != 1 || *restarts != 0 {
+		t.Errorf("Failed reconfiguration restarted Minio")
+	}
+	if reloader.minioConfig["AccessKey"] != "admin" || len(engine.reloads) != 0 {
+		t.Errorf("Failed credentials change applied, config %v", reloader.minioConfig)
+	}
+}
+
+// frameStorage is an in-memory persistent.Storage keeping the object
+// metadata only, its objects read as "data of <key>"
+type frameStorage map[string]common.ObjectInfo
+
+func (storage frameStorage) Read(keyname string) (io.ReadCloser, error) {
+	if _, ok := storage[keyname]; !ok {
+		return nil, errors.New("no such key: " + keyname)
+	}
+	return ioutil.NopCloser(bytes.NewReader([]byte("data of " + keyname))), nil
+}
+
+func (storage frameStorage) Remove(keyname string) error {
+	return errors.New("read-only storage")
+}
+
+func (storage frameStorage) Store(data []byte, key string) (string, error) {
+	return storage.StoreWithMetadata(data, key, nil)
+}
+
+func (storage frameStorage) StoreWithMetadata(data []byte, key string, metadata map[string]string) (string, error) {
+	storage[key] = common.ObjectInfo{Key: key, Size: int64(len(data)), Metadata: metadata}
+	return key, nil
+}
+
+func (storage frameStorage) Stat(keyname string) (common.ObjectInfo, error) {
+	info, ok := storage[keyname]
+	if !ok {
+		return info, errors.New("no such key: " + keyname)
+	}
+	return info, nil
+}
+
+func (storage frameStorage) SetMetadata(keyname string, metadata map[string]string) error {
+	return errors.New("read-only storage")
+}
+
+func (storage frameStorage) List(prefix string, doneCh <-chan struct{}) <-chan common.ObjectInfo {
+	objectsCh := make(chan common.ObjectInfo)
+	close(objectsCh)
+	return objectsCh
+}
+
+func TestReadFrame(t *testing.T) {
+	storage := frameStorage{
+		"single":   {Key: "single"},
+		"parent":   {Key: "parent", Metadata: map[string]string{common.MetaBlobHandles: `["parent","parent_1","parent_2"]`}},
+		"parent_2": {Key: "parent_2"},
+	}
+	server := IsServer{is: imagestore.NewImageStoreWithStorage(persistent.NewPersistentStorage(storage))}
+
+	tests := []struct {
+		key     string
+		handles string
+	}{
+		{"single", "single"},
+		// The missing blobs are skipped
+		{"parent", "parent,parent_2"},
+		// A blob is read on its own
+		{"parent_2", "parent_2"},
+	}
+	for _, test := range tests {
+		handles, frames, err := server.ReadFrame(test.key, "")
+		if err != nil {
+			t.Errorf("ReadFrame(%s) failed: %v", test.key, err)
+			continue
+		}
+		if strings.Join(handles, ",") != test.handles || len(frames) != len(handles) {
+			t.Errorf("ReadFrame(%s) = %v, %d frames, expected %s", test.key, handles, len(frames), test.handles)
+			continue
+		}
+		for i, frame := range frames {
+			if string(frame) != "data of "+handles[i] {
+				t.Errorf("ReadFrame(%s) read %q for %s", test.key, frame, handles[i])
+			}
+		}
+	}
+	if _, _, err := server.ReadFrame("parent_1", ""); err == nil {
+		t.Errorf("ReadFrame() of a missing frame succeeded")
+	}
+}
+
+func TestStoreDataTopic(t *testing.T) {
+	storage := frameStorage{}
+	server := IsServer{is: imagestore.NewImageStoreWithStorage(persistent.NewPersistentStorage(storage))}
+
+	for _, sync := range []bool{false, true} {
+		key, err := server.StoreData([]byte("frame"), "frame", "camera1", sync)
+		if err != nil {
+			t.Fatalf("StoreData() failed: %v", err)
+		}
+		if topic := storage[key].Metadata[common.MetaTopic]; topic != "camera1" {
+			t.Errorf("StoreData() with sync %v saved topic %q", sync, topic)
+		}
+	}
+	key, err := server.StoreData([]byte("frame"), "no_topic", "", false)
+	if err != nil {
+		t.Fatalf("StoreData() failed: %v", err)
+	}
+	if topic, ok := storage[key].Metadata[common.MetaTopic]; ok {
+		t.Errorf("StoreData() without a topic saved topic %q", topic)
+	}
+}
@@ -0,0 +1,422 @@
+/*
+Copyright (c) 2021 Intel Corporation
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+// Package match evaluates boolean expressions against the metadata of a
+// frame, e.g. `len(defects) > 0 && class == "scratch"`.
+//
+// An expression combines comparisons with &&, || and !, grouped by
+// parentheses. The operands are field names, dotted for nested fields, the
+// string, number, true, false and null literals and len() of an operand.
+// The comparisons are ==, !=, <, <=, > and >=. A field on its own is true
+// when it is set and is not false, zero or empty, so `defects` matches the
+// frames with a non-empty defects array. A missing field is null.
+package match
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Expression is a compiled expression, safe for concurrent use
+type Expression struct {
+	source string
+	root   node
+}
+
+// Compile parses an expression.
+//
+// Parameters:
+// 1. source : string
+//    Refers to the expression to parse.
+//
+// Returns:
+// 1. *Expression
+//    Returns the compiled expression.
+// 2. error
+//    Returns an error object if the expression is invalid.
+func Compile(source string) (*Expression, error) {
+	tokens, err := tokenize(source)
+	if err != nil {
+		return nil, fmt.Errorf("invalid expression %q: %v", source, err)
+	}
+	p := &parser{tokens: tokens}
+	root, err := p.parseOr()
+	if err == nil && p.peek().kind != tokenEnd {
+		err = fmt.Errorf("unexpected %q at offset %d", p.peek().text, p.peek().offset)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid expression %q: %v", source, err)
+	}
+	return &Expression{source: source, root: root}, nil
+}
+
+// Match reports whether the expression is true for the given metadata
+func (expression *Expression) Match(data map[string]interface{}) bool {
+	return truthy(expression.root.eval(data))
+}
+
+// String returns the source of the expression
+func (expression *Expression) String() string {
+	return expression.source
+}
+
+type tokenKind int
+
+const (
+	tokenEnd tokenKind = iota
+	tokenField
+	tokenString
+	tokenNumber
+	tokenOperator
+)
+
+type token struct {
+	kind   tokenKind
+	text   string
+	value  interface{}
+	offset int
+}
+
+// Operators, longest first so that <= is not read as <
+var operators = []string{"&&", "||", "==", "!=", "<=", ">=", "<", ">", "!", "(", ")"}
+
+func tokenize(source string) ([]token, error) {
+	tokens := make([]token, 0)
+	for i := 0; i < len(source); {
+		c := rune(source[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case c == '"' || c == '\'':
+			end := i + 1
+			var text strings.Builder
+			for ; end < len(source) && rune(source[end]) != c; end++ {
+				if source[end] == '\\' && end+1 < len(source) {
+					end++
+				}
+				text.WriteByte(source[end])
+			}
+			if end >= len(source) {
+				return nil, fmt.Errorf("unterminated string at offset %d", i)
+			}
+			tokens = append(tokens, token{tokenString, source[i : end+1], text.String(), i})
+			i = end + 1
+		case c == '-' || c == '.' || unicode.IsDigit(c):
+			end := i + 1
+			for end < len(source) && strings.ContainsRune("0123456789.eE+-", rune(source[end])) {
+				if (source[end] == '+' || source[end] == '-') && source[end-1] != 'e' && source[end-1] != 'E' {
+					break
+				}
+				end++
+			}
+			value, err := strconv.ParseFloat(source[i:end], 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid number %q at offset %d", source[i:end], i)
+			}
+			tokens = append(tokens, token{tokenNumber, source[i:end], value, i})
+			i = end
+		case c == '_' || unicode.IsLetter(c):
+			end := i + 1
+			for end < len(source) && (source[end] == '_' || source[end] == '.' ||
+				unicode.IsLetter(rune(source[end])) || unicode.IsDigit(rune(source[end]))) {
+				end++
+			}
+			tokens = append(tokens, token{tokenField, source[i:end], nil, i})
+			i = end
+		default:
+			matched := false
+			for _, operator := range operators {
+				if strings.HasPrefix(source[i:], operator) {
+					tokens = append(tokens, token{tokenOperator, operator, nil, i})
+					i += len(operator)
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				return nil, fmt.Errorf("unexpected %q at offset %d", c, i)
+			}
+		}
+	}
+	return append(tokens, token{tokenEnd, "end of expression", nil, len(source)}), nil
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokenEnd {
+		p.pos++
+	}
+	return t
+}
+
+// accept consumes the next token if it is the given operator
+func (p *parser) accept(operator string) bool {
+	if t := p.peek(); t.kind == tokenOperator && t.text == operator {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *parser) expect(operator string) error {
+	if !p.accept(operator) {
+		return fmt.Errorf("expected %q at offset %d, got %q", operator, p.peek().offset, p.peek().text)
+	}
+	return nil
+}
+
+func (p *parser) parseOr() (node, error) {
+	left, err := p.parseAnd()
+	for err == nil && p.accept("||") {
+		var right node
+		right, err = p.parseAnd()
+		left = orNode{left, right}
+	}
+	return left, err
+}
+
+func (p *parser) parseAnd() (node, error) {
+	left, err := p.parseUnary()
+	for err == nil && p.accept("&&") {
+		var right node
+		right, err = p.parseUnary()
+		left = andNode{left, right}
+	}
+	return left, err
+}
+
+func (p *parser) parseUnary() (node, error) {
+	if p.accept("!") {
+		operand, err := p.parseUnary()
+		return notNode{operand}, err
+	}
+	return p.parseComparison()
+}
+
+func (p *parser) parseComparison() (node, error) {
+	left, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind == tokenOperator {
+		switch t.text {
+		case "==", "!=", "<", "<=", ">", ">=":
+			p.next()
+			right, err := p.parseOperand()
+			return compareNode{t.text, left, right}, err
+		}
+	}
+	return left, nil
+}
+
+func (p *parser) parseOperand() (node, error) {
+	t := p.next()
+	switch t.kind {
+	case tokenString, tokenNumber:
+		return literalNode{t.value}, nil
+	case tokenField:
+		switch t.text {
+		case "true":
+			return literalNode{true}, nil
+		case "false":
+			return literalNode{false}, nil
+		case "null":
+			return literalNode{nil}, nil
+		case "len":
+			if p.accept("(") {
+				operand, err := p.parseOperand()
+				if err == nil {
+					err = p.expect(")")
+				}
+				return lenNode{operand}, err
+			}
+		}
+		return fieldNode{strings.Split(t.text, ".")}, nil
+	case tokenOperator:
+		if t.text == "(" {
+			inner, err := p.parseOr()
+			if err == nil {
+				err = p.expect(")")
+			}
+			return inner, err
+		}
+	}
+	return nil, fmt.Errorf("unexpected %q at offset %d", t.text, t.offset)
+}
+
+type node interface {
+	eval(data map[string]interface{}) interface{}
+}
+
+type literalNode struct {
+	value interface{}
+}
+
+func (n literalNode) eval(data map[string]interface{}) interface{} {
+	return n.value
+}
+
+type fieldNode struct {
+	path []string
+}
+
+func (n fieldNode) eval(data map[string]interface{}) interface{} {
+	var value interface{} = data
+	for _, name := range n.path {
+		fields, ok := value.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		value = fields[name]
+	}
+	return value
+}
+
+type lenNode struct {
+	operand node
+}
+
+func (n lenNode) eval(data map[string]interface{}) interface{} {
+	value := reflect.ValueOf(n.operand.eval(data))
+	switch value.Kind() {
+	case reflect.String, reflect.Slice, reflect.Array, reflect.Map:
+		return float64(value.Len())
+	}
+	return float64(0)
+}
+
+type notNode struct {
+	operand node
+}
+
+func (n notNode) eval(data map[string]interface{}) interface{} {
+	return !truthy(n.operand.eval(data))
+}
+
+type andNode struct {
+	left, right node
+}
+
+func (n andNode) eval(data map[string]interface{}) interface{} {
+	return truthy(n.left.eval(data)) && truthy(n.right.eval(data))
+}
+
+type orNode struct {
+	left, right node
+}
+
+func (n orNode) eval(data map[string]interface{}) interface{} {
+	return truthy(n.left.eval(data)) || truthy(n.right.eval(data))
+}
+
+type compareNode struct {
+	operator    string
+	left, right node
+}
+
+func (n compareNode) eval(data map[string]interface{}) interface{} {
+	left, right := n.left.eval(data), n.right.eval(data)
+
+	leftNumber, leftOk := toNumber(left)
+	rightNumber, rightOk := toNumber(right)
+	if leftOk && rightOk {
+		return compare(n.operator, leftNumber < rightNumber, leftNumber == rightNumber)
+	}
+
+	leftString, leftOk := left.(string)
+	rightString, rightOk := right.(string)
+	if leftOk && rightOk {
+		return compare(n.operator, leftString < rightString, leftString == rightString)
+	}
+
+	// Other values only support equality
+	switch n.operator {
+	case "==":
+		return reflect.DeepEqual(left, right)
+	case "!=":
+		return !reflect.DeepEqual(left, right)
+	}
+	return false
+}
+
+func compare(operator string, less bool, equal bool) bool {
+	switch operator {
+	case "==":
+		return equal
+	case "!=":
+		return !equal
+	case "<":
+		return less
+	case "<=":
+		return less || equal
+	case ">":
+		return !less && !equal
+	case ">=":
+		return !less
+	}
+	return false
+}
+
+// toNumber converts the numeric types found in decoded metadata to float64
+func toNumber(value interface{}) (float64, bool) {
+	v := reflect.ValueOf(value)
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(v.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), true
+	}
+	return 0, false
+}
+
+// truthy reports whether a value is set and is not false, zero or empty
+func truthy(value interface{}) bool {
+	if number, ok := toNumber(value); ok {
+		return number != 0
+	}
+	v := reflect.ValueOf(value)
+	switch v.Kind() {
+	case reflect.Invalid:
+		return false
+	case reflect.Bool:
+		return v.Bool()
+	case reflect.String, reflect.Slice, reflect.Array, reflect.Map:
+		return v.Len() > 0
+	case reflect.Ptr, reflect.Interface:
+		return !v.IsNil()
+	}
+	return true
+}
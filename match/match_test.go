@@ -0,0 +1,89 @@
+/*
+Copyright (c) 2021 Intel Corporation
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package match
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestMatch(t *testing.T) {
+	var data map[string]interface{}
+	json.Unmarshal([]byte(`{
+		"defects": [{"type": 0, "tl": [1, 2]}],
+		"class": "scratch",
+		"score": 0.75,
+		"count": 3,
+		"ok": false,
+		"camera": {"id": "cam-1"}
+	}`), &data)
+	data["frame_number"] = int64(42)
+
+	tests := map[string]bool{
+		`defects`:                           true,
+		`!defects`:                          false,
+		`len(defects) > 0`:                  true,
+		`missing`:                           false,
+		`missing == null`:                   true,
+		`class == "scratch"`:                true,
+		`class != 'scratch'`:                false,
+		`class < "z"`:                       true,
+		`score >= 0.5 && count == 3`:        true,
+		`score > 0.8 || ok`:                 false,
+		`!(score > 0.8 || ok)`:              true,
+		`frame_number == 42`:                true,
+		`frame_number <= -1`:                false,
+		`camera.id == "cam-1"`:              true,
+		`camera.missing.id == "cam-1"`:      false,
+		`len(class) == 7`:                   true,
+		`ok == false && defects || missing`: true,
+	}
+	for source, expected := range tests {
+		expression, err := Compile(source)
+		if err != nil {
+			t.Errorf("Compiling %s failed: %v", source, err)
+			continue
+		}
+		if matched := expression.Match(data); matched != expected {
+			t.Errorf("%s matched %v, expected %v", source, matched, expected)
+		}
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	invalid := []string{
+		``,
+		`class ==`,
+		`class == "scratch`,
+		`(defects`,
+		`len(defects`,
+		`defects &&`,
+		`class = "scratch"`,
+		`defects defects`,
+	}
+	for _, source := range invalid {
+		if _, err := Compile(source); err == nil {
+			t.Errorf("Invalid expression %s compiled", source)
+		}
+	}
+}
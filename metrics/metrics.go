@@ -0,0 +1,66 @@
+/*
+Copyright (c) 2021 Intel Corporation
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+// Package metrics keeps the process wide counters and gauges of ImageStore,
+// reported by the metrics command of the ImageStore service.
+package metrics
+
+import (
+	"sync"
+)
+
+var (
+	mutex  sync.Mutex
+	values = make(map[string]int64)
+)
+
+// Add - function to add delta to the named counter
+func Add(name string, delta int64) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	values[name] += delta
+}
+
+// Set - function to set the named gauge to value
+func Set(name string, value int64) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	values[name] = value
+}
+
+// Get - function to get the current value of the named counter or gauge
+func Get(name string) int64 {
+	mutex.Lock()
+	defer mutex.Unlock()
+	return values[name]
+}
+
+// Snapshot - function to get a copy of all counters and gauges
+func Snapshot() map[string]interface{} {
+	mutex.Lock()
+	defer mutex.Unlock()
+	snapshot := make(map[string]interface{}, len(values))
+	for name, value := range values {
+		snapshot[name] = value
+	}
+	return snapshot
+}
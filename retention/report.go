@@ -0,0 +1,143 @@
+/*
+Copyright (c) 2021 Intel Corporation
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package retention
+
+import (
+	common "IEdgeInsights/ImageStore/common"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Count counts objects and their bytes
+type Count struct {
+	Objects int64
+	Bytes   int64
+}
+
+func (count *Count) add(size int64) {
+	count.Objects++
+	count.Bytes += size
+}
+
+func (count *Count) toMap() map[string]interface{} {
+	return map[string]interface{}{"objects": count.Objects, "bytes": count.Bytes}
+}
+
+// Upper bounds of the age buckets of the retention report
+var ageBuckets = []struct {
+	name  string
+	limit time.Duration
+}{
+	{"<1h", time.Hour},
+	{"1h-1d", 24 * time.Hour},
+	{"1d-7d", 7 * 24 * time.Hour},
+	{"7d-30d", 30 * 24 * time.Hour},
+	{">=30d", math.MaxInt64},
+}
+
+// ageBucket returns the name of the age bucket an object falls in
+func ageBucket(age time.Duration) string {
+	for _, bucket := range ageBuckets {
+		if age < bucket.limit {
+			return bucket.name
+		}
+	}
+	return ageBuckets[len(ageBuckets)-1].name
+}
+
+// Report summarizes the objects a retention sweep removes, by reason, by
+// topic and by age
+type Report struct {
+	Total   Count
+	Expired Count
+	Evicted Count
+	ByTopic map[string]*Count
+	ByAge   map[string]*Count
+}
+
+func newReport() *Report {
+	return &Report{
+		ByTopic: make(map[string]*Count),
+		ByAge:   make(map[string]*Count),
+	}
+}
+
+// add counts obj as removed for the given reason
+func (report *Report) add(reason *Count, obj common.ObjectInfo, topic string, now time.Time) {
+	if topic == "" {
+		topic = "(none)"
+	}
+	bucket := ageBucket(now.Sub(obj.LastModified))
+	if report.ByTopic[topic] == nil {
+		report.ByTopic[topic] = &Count{}
+	}
+	if report.ByAge[bucket] == nil {
+		report.ByAge[bucket] = &Count{}
+	}
+	report.Total.add(obj.Size)
+	reason.add(obj.Size)
+	report.ByTopic[topic].add(obj.Size)
+	report.ByAge[bucket].add(obj.Size)
+}
+
+// ToMap converts the report to the retention_report response
+func (report *Report) ToMap() map[string]interface{} {
+	byTopic := make(map[string]interface{}, len(report.ByTopic))
+	for topic, count := range report.ByTopic {
+		byTopic[topic] = count.toMap()
+	}
+	byAge := make(map[string]interface{}, len(report.ByAge))
+	for bucket, count := range report.ByAge {
+		byAge[bucket] = count.toMap()
+	}
+	return map[string]interface{}{
+		"objects":  report.Total.Objects,
+		"bytes":    report.Total.Bytes,
+		"expired":  report.Expired.toMap(),
+		"evicted":  report.Evicted.toMap(),
+		"by_topic": byTopic,
+		"by_age":   byAge,
+	}
+}
+
+func (report *Report) String() string {
+	var parts []string
+	for _, bucket := range ageBuckets {
+		if count, ok := report.ByAge[bucket.name]; ok {
+			parts = append(parts, fmt.Sprintf("%s: %d/%dB", bucket.name, count.Objects, count.Bytes))
+		}
+	}
+	topics := make([]string, 0, len(report.ByTopic))
+	for topic, count := range report.ByTopic {
+		topics = append(topics, fmt.Sprintf("%s: %d/%dB", topic, count.Objects, count.Bytes))
+	}
+	sort.Strings(topics)
+	return fmt.Sprintf("%d objects, %d bytes (expired %d/%dB, evicted %d/%dB), by age [%s], by topic [%s]",
+		report.Total.Objects, report.Total.Bytes,
+		report.Expired.Objects, report.Expired.Bytes,
+		report.Evicted.Objects, report.Evicted.Bytes,
+		strings.Join(parts, ", "), strings.Join(topics, ", "))
+}
@@ -0,0 +1,130 @@
+/*
+Copyright (c) 2021 Intel Corporation
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package retention
+
+import (
+	common "IEdgeInsights/ImageStore/common"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAgeBucket(t *testing.T) {
+	tests := map[time.Duration]string{
+		0:                   "<1h",
+		59 * time.Minute:    "<1h",
+		time.Hour:           "1h-1d",
+		3 * 24 * time.Hour:  "1d-7d",
+		7 * 24 * time.Hour:  "7d-30d",
+		90 * 24 * time.Hour: ">=30d",
+	}
+	for age, want := range tests {
+		if got := ageBucket(age); got != want {
+			t.Errorf("ageBucket(%v) = %s, expected %s", age, got, want)
+		}
+	}
+}
+
+func TestReport(t *testing.T) {
+	now := time.Date(2021, 1, 2, 0, 0, 0, 0, time.UTC)
+	report := newReport()
+	report.add(&report.Expired, common.ObjectInfo{Key: "a", Size: 10, LastModified: now.Add(-2 * time.Hour)}, "camera", now)
+	report.add(&report.Expired, common.ObjectInfo{Key: "b", Size: 20, LastModified: now.Add(-3 * time.Hour)}, "", now)
+	report.add(&report.Evicted, common.ObjectInfo{Key: "c", Size: 30, LastModified: now.Add(-time.Minute)}, "camera", now)
+
+	response := report.ToMap()
+	if response["objects"] != int64(3) || response["bytes"] != int64(60) {
+		t.Errorf("Unexpected totals in %v", response)
+	}
+	expired := response["expired"].(map[string]interface{})
+	evicted := response["evicted"].(map[string]interface{})
+	if expired["objects"] != int64(2) || expired["bytes"] != int64(30) || evicted["bytes"] != int64(30) {
+		t.Errorf("Unexpected reasons in %v", response)
+	}
+	byTopic := response["by_topic"].(map[string]interface{})
+	if camera := byTopic["camera"].(map[string]interface{}); camera["bytes"] != int64(40) {
+		t.Errorf("Unexpected topic counts %v", byTopic)
+	}
+	if _, ok := byTopic["(none)"]; !ok {
+		t.Errorf("Objects without topic not reported %v", byTopic)
+	}
+	byAge := response["by_age"].(map[string]interface{})
+	if len(byAge) != 2 || byAge["1h-1d"].(map[string]interface{})["objects"] != int64(2) {
+		t.Errorf("Unexpected age counts %v", byAge)
+	}
+
+	if summary := report.String(); !strings.Contains(summary, "by age [<1h: 1/30B, 1h-1d: 2/30B]") {
+		t.Errorf("Unexpected summary %s", summary)
+	}
+}
+
+func TestDryRunRules(t *testing.T) {
+	clock := newFakeClock()
+	storage := newMemoryStorage(clock.Now)
+	store(storage, "raw", 100, "raw", nil)
+	store(storage, "result", 100, "results", nil)
+	store(storage, "pinned", 100, "raw", nil)
+	storage.SetMetadata("pinned", map[string]string{common.MetaTopic: "raw", common.MetaPinned: "true"})
+	clock.mutex.Lock()
+	clock.now = clock.now.Add(2 * time.Hour)
+	clock.mutex.Unlock()
+	store(storage, "new", 100, "raw", nil)
+
+	results := newTestRule(t, "results", "1h", "1m")
+	results.Topic = "results"
+	engine := New(Config{
+		Storage: storage,
+		Clock:   clock,
+		Settings: Settings{
+			Default: newTestRule(t, "default", "-1", "1m"),
+			Rules:   []*Rule{results},
+			Quota:   Quota{MaxBytes: 10000, TopicMaxBytes: map[string]int64{"raw": 250}, LowWatermark: 1},
+		},
+	})
+	engine.Start()
+	defer engine.Stop()
+	// Waiting for the first sweep, expiring the result and evicting the
+	// oldest unpinned raw frame
+	engine.Report(nil)
+	expectKeys(t, storage, "new", "pinned")
+
+	store(storage, "result", 100, "results", nil)
+	clock.mutex.Lock()
+	clock.now = clock.now.Add(2 * time.Hour)
+	clock.mutex.Unlock()
+	store(storage, "extra", 100, "raw", nil)
+
+	// The default retention time of the dry run only replaces the one of
+	// the default rule, pins are still honoured
+	retentionTime := time.Hour
+	report := engine.Report(&retentionTime)
+	if report == nil || report.Expired.Objects != 2 || report.ByTopic["raw"].Objects != 1 || report.ByTopic["results"].Objects != 1 {
+		t.Fatalf("Dry run reported %v, expected the new and result frames to expire", report)
+	}
+	retentionTime = 0
+	report = engine.Report(&retentionTime)
+	if report == nil || report.Expired.Objects != 1 || report.Evicted.Objects != 1 || report.ByTopic["raw"].Objects != 1 {
+		t.Errorf("Dry run keeping the images forever reported %v, expected the result to expire and the raw quota to evict one", report)
+	}
+	expectKeys(t, storage, "extra", "new", "pinned", "result")
+}
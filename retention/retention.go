@@ -0,0 +1,629 @@
+/*
+Copyright (c) 2021 Intel Corporation
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+// Package retention removes the images stored in ImageStore once their
+// retention time elapsed or when a storage quota is exceeded
+package retention
+
+import (
+	audit "IEdgeInsights/ImageStore/audit"
+	common "IEdgeInsights/ImageStore/common"
+	events "IEdgeInsights/ImageStore/events"
+	imagestore "IEdgeInsights/ImageStore/go/imagestore"
+	archive "IEdgeInsights/ImageStore/go/imagestore/archive"
+	hashindex "IEdgeInsights/ImageStore/go/imagestore/hashindex"
+	persistent "IEdgeInsights/ImageStore/go/imagestore/persistent"
+	metrics "IEdgeInsights/ImageStore/metrics"
+	"sort"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// Clock is the source of time of the retention engine, replaced in tests
+type Clock interface {
+	Now() time.Time
+	NewTicker(d time.Duration) Ticker
+}
+
+// Ticker delivers the ticks of a Clock
+type Ticker interface {
+	Chan() <-chan time.Time
+	Stop()
+}
+
+type systemClock struct{}
+
+type systemTicker struct {
+	*time.Ticker
+}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (systemClock) NewTicker(d time.Duration) Ticker {
+	return systemTicker{time.NewTicker(d)}
+}
+
+func (ticker systemTicker) Chan() <-chan time.Time {
+	return ticker.C
+}
+
+// SystemClock returns the Clock of the system time
+func SystemClock() Clock {
+	return systemClock{}
+}
+
+// Config holds the dependencies of the retention engine
+type Config struct {
+	// Storage holds the images, it must support listing
+	Storage persistent.Storage
+	// Clock defaults to the system clock
+	Clock Clock
+	// Settings holds the rules and quotas, when none is set only the
+	// dry-run reports are served
+	Settings Settings
+	// Tracker records the image reads used by lru eviction, may be nil
+	Tracker *imagestore.ReadTracker
+	// Index is the perceptual hash index the removed images are dropped
+	// from, may be nil
+	Index *hashindex.Index
+	// Handles is the index of the image handles stored under a key
+	// template, may be nil
+	Handles *imagestore.HandleIndex
+	// Archive is the tier the expired images are moved to, may be nil
+	Archive archive.Archive
+	// ArchiveRule holds the retention time and poll interval of Archive
+	ArchiveRule *Rule
+	// AuditLog records every removal, may be nil
+	AuditLog *audit.Log
+	// Events publishes every removal, may be nil
+	Events *events.Publisher
+	// Evictions are the emergency eviction requests of the disk pressure
+	// monitor, in bytes to free, may be nil
+	Evictions <-chan int64
+}
+
+// objectMeta holds the details of a stored object needed by the retention
+type objectMeta struct {
+	topic    string
+	rule     *Rule
+	captured time.Time // the store time if the capture time is unknown
+}
+
+// reportRequest asks the retention thread for a dry run
+type reportRequest struct {
+	retentionTime *time.Duration // replaces the default retention time if set
+	reply         chan *Report
+}
+
+// Engine applies the retention settings to the storage from its own
+// goroutine, between Start and Stop
+type Engine struct {
+	config   Config
+	settings Settings
+
+	// Topics, retention rules and capture times of the stored objects. The
+	// rule is resolved once, so the frame metadata is not kept in memory.
+	known map[string]objectMeta
+	// Audit records of the removals, written by flushAudit once per batch
+	pending []audit.Record
+
+	// The ticker runs at the shortest poll interval, each rule is applied
+	// when its own poll interval elapsed. The tick channel stays nil and
+	// never fires while retention is disabled.
+	tickInterval time.Duration
+	ticker       Ticker
+	tick         <-chan time.Time
+
+	reports chan reportRequest
+	reloads chan Settings
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+// New creates a retention engine.
+//
+// Parameters:
+// 1. config : Config
+//    Refers to the storage, settings and dependencies of the engine.
+//
+// Returns:
+// 1. *Engine
+//    Returns the engine, started by Start.
+func New(config Config) *Engine {
+	if config.Clock == nil {
+		config.Clock = SystemClock()
+	}
+	return &Engine{
+		config:   config,
+		settings: config.Settings,
+		known:    make(map[string]objectMeta),
+		reports:  make(chan reportRequest),
+		reloads:  make(chan Settings),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+// Start runs the retention in a new goroutine. The first sweep runs right
+// away if retention is enabled.
+func (engine *Engine) Start() {
+	glog.Infof("Starting retention engine")
+	go engine.run()
+}
+
+// Stop stops the retention goroutine and waits for the running sweep to
+// complete
+func (engine *Engine) Stop() {
+	close(engine.stop)
+	<-engine.done
+}
+
+// Report runs a dry-run sweep applying every rule, removing nothing.
+//
+// Parameters:
+// 1. retentionTime : *time.Duration
+//    Refers to the retention time replacing the one of the default rule if
+//    set, 0 keeping the images forever.
+//
+// Returns:
+// 1. *Report
+//    Returns what the sweep would remove, nil if it failed or the engine is
+//    stopped.
+func (engine *Engine) Report(retentionTime *time.Duration) *Report {
+	request := reportRequest{retentionTime: retentionTime, reply: make(chan *Report, 1)}
+	select {
+	case engine.reports <- request:
+		return <-request.reply
+	case <-engine.done:
+		return nil
+	}
+}
+
+// Reload applies new retention settings, from the next sweep on.
+//
+// Parameters:
+// 1. settings : Settings
+//    Refers to the new retention settings.
+func (engine *Engine) Reload(settings Settings) {
+	select {
+	case engine.reloads <- settings:
+	case <-engine.done:
+	}
+}
+
+func (engine *Engine) run() {
+	defer close(engine.done)
+	defer glog.Flush()
+
+	engine.schedule()
+	if engine.tick != nil {
+		engine.sweep(false, engine.settings.Default.RetentionTime)
+		engine.expireArchive()
+	}
+
+	for {
+		select {
+		case <-engine.tick:
+			engine.sweep(false, engine.settings.Default.RetentionTime)
+			engine.expireArchive()
+		case needed := <-engine.config.Evictions:
+			engine.evictForSpace(needed)
+		case request := <-engine.reports:
+			defaultRetention := engine.settings.Default.RetentionTime
+			if request.retentionTime != nil {
+				defaultRetention = *request.retentionTime
+			}
+			request.reply <- engine.sweep(true, defaultRetention)
+		case settings := <-engine.reloads:
+			engine.settings = settings
+			// The cached rules of the objects are outdated
+			engine.known = make(map[string]objectMeta)
+			engine.schedule()
+		case <-engine.stop:
+			if engine.ticker != nil {
+				engine.ticker.Stop()
+			}
+			glog.Infof("Stopped retention engine")
+			return
+		}
+	}
+}
+
+// schedule restarts the ticker at the shortest poll interval of the rules
+func (engine *Engine) schedule() {
+	settings := engine.settings
+	engine.tickInterval = settings.Default.PollInterval
+	for _, rule := range settings.Rules {
+		if rule.PollInterval < engine.tickInterval {
+			engine.tickInterval = rule.PollInterval
+		}
+	}
+	archiveRule := engine.config.ArchiveRule
+	if engine.config.Archive != nil && archiveRule.PollInterval < engine.tickInterval {
+		engine.tickInterval = archiveRule.PollInterval
+	}
+
+	if engine.ticker != nil {
+		engine.ticker.Stop()
+		engine.ticker, engine.tick = nil, nil
+	}
+	if settings.enabled() || engine.config.Evictions != nil || engine.config.Archive != nil {
+		glog.Infof("Applying retention every %v", engine.tickInterval)
+		engine.ticker = engine.config.Clock.NewTicker(engine.tickInterval)
+		engine.tick = engine.ticker.Chan()
+	} else {
+		glog.Infof("Image retention time is infinite")
+	}
+}
+
+// ruleOf returns the first matching rule, the default rule catching the rest
+func (engine *Engine) ruleOf(key string, topic string, frame map[string]interface{}) *Rule {
+	for _, rule := range engine.settings.Rules {
+		if rule.matches(key, topic, frame) {
+			return rule
+		}
+	}
+	return engine.settings.Default
+}
+
+// lookup returns the cached details of an object, stat'ing it the first time
+func (engine *Engine) lookup(key string) (objectMeta, error) {
+	meta, ok := engine.known[key]
+	if !ok {
+		info, err := engine.config.Storage.Stat(key)
+		if err != nil {
+			return meta, err
+		}
+		meta.topic = info.Metadata[common.MetaTopic]
+		// The age of an object is kept when pinning rewrites it
+		meta.captured = info.LastModified
+		if captured, ok := info.Metadata[common.MetaCaptured]; ok {
+			if meta.captured, err = time.Parse(time.RFC3339Nano, captured); err != nil {
+				glog.V(1).Infof("Ignoring invalid capture time of %s: %v", key, err)
+			}
+		}
+		frame := imagestore.DecodeFrameMetadata(info.Metadata[common.MetaFrameMetadata])
+		meta.rule = engine.ruleOf(key, meta.topic, frame)
+		engine.known[key] = meta
+	}
+	return meta, nil
+}
+
+// topicOf returns the topic of an object returned by listObjects
+func (engine *Engine) topicOf(key string) string {
+	return engine.known[key].topic
+}
+
+func (engine *Engine) lastAccess(obj common.ObjectInfo) time.Time {
+	if engine.settings.Quota.Order == EvictLRU && engine.config.Tracker != nil {
+		if lastRead, ok := engine.config.Tracker.LastRead(obj.Key); ok && lastRead.After(obj.LastModified) {
+			return lastRead
+		}
+	}
+	return obj.LastModified
+}
+
+// evictable looks up the pin right before removal, so pins set through the
+// ImageStore service are honoured by the next sweep. The objects whose pin
+// cannot be checked are kept until a later sweep.
+func (engine *Engine) evictable(key string) bool {
+	info, err := engine.config.Storage.Stat(key)
+	if err != nil {
+		glog.Warningf("Skipping %s, failed to check its pin: %v", key, err)
+		metrics.Add("retention_skipped_objects", 1)
+		return false
+	}
+	return info.Metadata[common.MetaPinned] != "true"
+}
+
+// onRemove returns the callback of removeKeys publishing the removals,
+// dropping the removed objects from the caches and queueing their audit
+// records
+func (engine *Engine) onRemove(reason string, ruleName func(string) string) func(common.ObjectInfo) {
+	return func(obj common.ObjectInfo) {
+		if engine.config.Events != nil {
+			handle := obj.Key
+			if engine.config.Handles != nil {
+				handle = engine.config.Handles.HandleOf(obj.Key)
+			}
+			engine.config.Events.Emit(events.Event{
+				Type:   events.TypeDeleted,
+				Handle: handle,
+				Key:    obj.Key,
+				Topic:  engine.known[obj.Key].topic,
+				Size:   obj.Size,
+				Reason: reason,
+			})
+		}
+		if engine.config.Tracker != nil {
+			engine.config.Tracker.Forget(obj.Key)
+		}
+		delete(engine.known, obj.Key)
+		if engine.config.Index != nil {
+			engine.config.Index.Remove(obj.Key)
+		}
+		// An archived image is still read by its handle, which is forgotten
+		// when the archive expires it
+		if engine.config.Handles != nil && reason != audit.ReasonArchived {
+			engine.config.Handles.Forget(obj.Key)
+		}
+		if engine.config.AuditLog != nil {
+			engine.pending = append(engine.pending, audit.NewRecord(obj.Key, obj.Size, obj.LastModified, ruleName(obj.Key), reason))
+		}
+	}
+}
+
+func (engine *Engine) flushAudit() {
+	if engine.config.AuditLog == nil || len(engine.pending) == 0 {
+		return
+	}
+	if err := engine.config.AuditLog.Append(engine.pending...); err != nil {
+		glog.Errorf("Failed to write the deletion audit log: %v", err)
+	}
+	engine.pending = nil
+}
+
+func fixedRule(name string) func(string) string {
+	return func(string) string {
+		return name
+	}
+}
+
+// sweep applies the rules whose poll interval elapsed. A dry run applies
+// every rule, removes nothing and reports what would have been removed,
+// defaultRetention replacing the retention time of the default rule.
+func (engine *Engine) sweep(dryRun bool, defaultRetention time.Duration) *Report {
+	defaultRule, rules, quota := engine.settings.Default, engine.settings.Rules, engine.settings.Quota
+	arch := engine.config.Archive
+
+	now := engine.config.Clock.Now()
+	due := make(map[*Rule]bool)
+	for _, rule := range append([]*Rule{defaultRule}, rules...) {
+		if dryRun {
+			due[rule] = true
+			continue
+		}
+		// Half a tick of slack keeps ticks arriving slightly early from
+		// skipping a rule for a whole interval
+		if now.Sub(rule.lastRun) >= rule.PollInterval-engine.tickInterval/2 {
+			due[rule] = true
+			rule.lastRun = now
+		}
+	}
+	if len(due) == 0 {
+		return nil
+	}
+	retentionOf := func(rule *Rule) time.Duration {
+		if rule == defaultRule {
+			return defaultRetention
+		}
+		return rule.RetentionTime
+	}
+
+	glog.V(1).Infof("Finding objects to delete")
+	objects, err := engine.listObjects()
+	if err != nil {
+		glog.Errorf("Failed retrieving objects from the storage: %v", err)
+		return nil
+	}
+
+	// Dropping the cached details of objects removed by other means
+	listed := make(map[string]bool, len(objects))
+	for _, obj := range objects {
+		listed[obj.Key] = true
+	}
+	for key := range engine.known {
+		if !listed[key] {
+			delete(engine.known, key)
+		}
+	}
+
+	expired := make([]common.ObjectInfo, 0)
+	remaining := make([]common.ObjectInfo, 0, len(objects))
+	removedByRule := make(map[string]int64)
+	ruleNames := make(map[string]string)
+	var usedBytes int64
+	for _, obj := range objects {
+		rule := engine.known[obj.Key].rule
+		if due[rule] && retentionOf(rule) > 0 && now.Sub(obj.LastModified) > retentionOf(rule) && engine.evictable(obj.Key) {
+			glog.V(1).Infof("Deleting key: %s by retention rule %s", obj.Key, rule.Name)
+			expired = append(expired, obj)
+			removedByRule[rule.Name]++
+			ruleNames[obj.Key] = rule.Name
+		} else {
+			glog.V(2).Infof("Not deleting key: %s", obj.Key)
+			remaining = append(remaining, obj)
+			usedBytes += obj.Size
+		}
+	}
+
+	var evicted []common.ObjectInfo
+	// Quotas are checked on the poll interval of the default rule
+	if quota.enabled() && due[defaultRule] {
+		evicted = quota.selectEvictions(remaining, engine.lastAccess, engine.topicOf, engine.evictable)
+	}
+
+	if dryRun {
+		report := newReport()
+		for _, obj := range expired {
+			report.add(&report.Expired, obj, engine.topicOf(obj.Key), now)
+		}
+		for _, obj := range evicted {
+			report.add(&report.Evicted, obj, engine.topicOf(obj.Key), now)
+		}
+		glog.Infof("Retention dry run with default retention time %v would remove %s", defaultRetention, report)
+		return report
+	}
+
+	// The objects failing to be archived are kept for the next sweep
+	if arch != nil {
+		archived := make([]common.ObjectInfo, 0, len(expired))
+		for _, obj := range expired {
+			if err := arch.Put(obj.Key); err != nil {
+				glog.Errorf("Failed to archive %s: %v", obj.Key, err)
+				usedBytes += obj.Size
+				continue
+			}
+			archived = append(archived, obj)
+		}
+		expired = archived
+	}
+
+	reason := audit.ReasonExpired
+	if arch != nil {
+		reason = audit.ReasonArchived
+	}
+	count, size := engine.removeKeys(expired, engine.onRemove(reason, func(key string) string {
+		return ruleNames[key]
+	}))
+	metrics.Add("retention_expired_objects", count)
+	metrics.Add("retention_expired_bytes", size)
+	if arch != nil {
+		metrics.Add("retention_archived_objects", count)
+		metrics.Add("retention_archived_bytes", size)
+	}
+	if count > 0 {
+		glog.Infof("Retention removed %d expired objects, %d bytes, per rule: %v, archived: %v", count, size, removedByRule, arch != nil)
+	}
+
+	count, size = engine.removeKeys(evicted, engine.onRemove(audit.ReasonEvicted, fixedRule("storageQuota")))
+	engine.flushAudit()
+	usedBytes -= size
+	metrics.Add("retention_evicted_objects", count)
+	metrics.Add("retention_evicted_bytes", size)
+	if count > 0 {
+		glog.Infof("Storage quota evicted %d objects, %d bytes", count, size)
+	}
+	metrics.Set("storage_used_bytes", usedBytes)
+	return nil
+}
+
+// evictForSpace removes the oldest objects until the requested number of
+// bytes is freed
+func (engine *Engine) evictForSpace(needed int64) {
+	objects, err := engine.listObjects()
+	if err != nil {
+		glog.Errorf("Failed retrieving objects from the storage: %v", err)
+		return
+	}
+	sort.Slice(objects, func(i, j int) bool {
+		return objects[i].LastModified.Before(objects[j].LastModified)
+	})
+
+	evicted := make([]common.ObjectInfo, 0)
+	var selected int64
+	for _, obj := range objects {
+		if selected >= needed {
+			break
+		}
+		if !engine.evictable(obj.Key) {
+			continue
+		}
+		evicted = append(evicted, obj)
+		selected += obj.Size
+	}
+
+	count, size := engine.removeKeys(evicted, engine.onRemove(audit.ReasonDiskPressure, fixedRule("diskPressure")))
+	engine.flushAudit()
+	metrics.Add("disk_pressure_evicted_objects", count)
+	metrics.Add("disk_pressure_evicted_bytes", size)
+	glog.Warningf("Disk pressure evicted %d oldest objects, %d bytes of %d bytes needed", count, size, needed)
+}
+
+// expireArchive removes the images whose archive retention time elapsed
+func (engine *Engine) expireArchive() {
+	arch, archiveRule := engine.config.Archive, engine.config.ArchiveRule
+	if arch == nil || archiveRule.RetentionTime == 0 {
+		return
+	}
+	now := engine.config.Clock.Now()
+	if now.Sub(archiveRule.lastRun) < archiveRule.PollInterval-engine.tickInterval/2 {
+		return
+	}
+	archiveRule.lastRun = now
+
+	count, size, err := arch.Expire(now.Add(-archiveRule.RetentionTime), func(key string, size int64, archived time.Time) {
+		if engine.config.Handles != nil {
+			engine.config.Handles.Forget(key)
+		}
+		if engine.config.AuditLog != nil {
+			engine.pending = append(engine.pending, audit.NewRecord(key, size, archived, archiveRule.Name, audit.ReasonArchiveAged))
+		}
+	})
+	engine.flushAudit()
+	if err != nil {
+		glog.Errorf("Failed to expire archived objects: %v", err)
+	}
+	metrics.Add("archive_expired_objects", count)
+	metrics.Add("archive_expired_bytes", size)
+	if count > 0 {
+		glog.Infof("Archive retention removed %d objects, %d bytes", count, size)
+	}
+}
+
+// listObjects returns all the objects of the storage. The LastModified time
+// of the objects is replaced by their capture time if known, so the age of
+// the frames spooled, replayed or imported is counted from their capture.
+// The objects failing to be looked up are left out until a later sweep,
+// rather than being handled by the default rule.
+func (engine *Engine) listObjects() ([]common.ObjectInfo, error) {
+	doneCh := make(chan struct{})
+	defer close(doneCh)
+
+	objects := make([]common.ObjectInfo, 0)
+	for obj := range engine.config.Storage.List("", doneCh) {
+		if obj.Err != nil {
+			return nil, obj.Err
+		}
+		meta, err := engine.lookup(obj.Key)
+		if err != nil {
+			glog.Warningf("Skipping %s, failed to look up its retention rule: %v", obj.Key, err)
+			metrics.Add("retention_skipped_objects", 1)
+			continue
+		}
+		if !meta.captured.IsZero() {
+			obj.LastModified = meta.captured
+		}
+		objects = append(objects, obj)
+	}
+	return objects, nil
+}
+
+// removeKeys removes the given objects from the storage and returns the
+// number of objects and bytes removed. onRemove is called for every removed
+// object.
+func (engine *Engine) removeKeys(objects []common.ObjectInfo, onRemove func(common.ObjectInfo)) (int64, int64) {
+	var count, size int64
+	for _, obj := range objects {
+		if err := engine.config.Storage.Remove(obj.Key); err != nil {
+			glog.Errorf("Error removing object %s from the storage: %v", obj.Key, err)
+			continue
+		}
+		count++
+		size += obj.Size
+		onRemove(obj)
+	}
+	return count, size
+}
@@ -0,0 +1,548 @@
+/*
+Copyright (c) 2021 Intel Corporation
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package retention
+
+import (
+	common "IEdgeInsights/ImageStore/common"
+	imagestore "IEdgeInsights/ImageStore/go/imagestore"
+	match "IEdgeInsights/ImageStore/match"
+	metrics "IEdgeInsights/ImageStore/metrics"
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	minio "github.com/minio/minio-go"
+)
+
+// memoryStorage is an in-memory persistent.Storage
+type memoryStorage struct {
+	mutex   sync.Mutex
+	objects map[string]common.ObjectInfo
+	data    map[string][]byte
+	now     func() time.Time
+	// unreachable counts the failing Stat calls left per key
+	unreachable map[string]int
+}
+
+func newMemoryStorage(now func() time.Time) *memoryStorage {
+	return &memoryStorage{
+		objects:     make(map[string]common.ObjectInfo),
+		data:        make(map[string][]byte),
+		now:         now,
+		unreachable: make(map[string]int),
+	}
+}
+
+func (storage *memoryStorage) Read(keyname string) (io.ReadCloser, error) {
+	storage.mutex.Lock()
+	defer storage.mutex.Unlock()
+	data, ok := storage.data[keyname]
+	if !ok {
+		return nil, errors.New("no such key: " + keyname)
+	}
+	return ioutil.NopCloser(bytes.NewReader(data)), nil
+}
+
+func (storage *memoryStorage) Remove(keyname string) error {
+	storage.mutex.Lock()
+	defer storage.mutex.Unlock()
+	delete(storage.objects, keyname)
+	delete(storage.data, keyname)
+	return nil
+}
+
+func (storage *memoryStorage) Store(data []byte, key string) (string, error) {
+	return storage.StoreWithMetadata(data, key, nil)
+}
+
+func (storage *memoryStorage) StoreWithMetadata(data []byte, key string, metadata map[string]string) (string, error) {
+	storage.mutex.Lock()
+	defer storage.mutex.Unlock()
+	if metadata == nil {
+		metadata = make(map[string]string)
+	}
+	storage.objects[key] = common.ObjectInfo{Key: key, Size: int64(len(data)), LastModified: storage.now(), Metadata: metadata}
+	storage.data[key] = data
+	return key, nil
+}
+
+func (storage *memoryStorage) Stat(keyname string) (common.ObjectInfo, error) {
+	storage.mutex.Lock()
+	defer storage.mutex.Unlock()
+	info, ok := storage.objects[keyname]
+	if !ok {
+		return info, errors.New("no such key: " + keyname)
+	}
+	if storage.unreachable[keyname] > 0 {
+		storage.unreachable[keyname]--
+		return info, errors.New("storage unreachable")
+	}
+	return info, nil
+}
+
+func (storage *memoryStorage) SetMetadata(keyname string, metadata map[string]string) error {
+	storage.mutex.Lock()
+	defer storage.mutex.Unlock()
+	info, ok := storage.objects[keyname]
+	if !ok {
+		return errors.New("no such key: " + keyname)
+	}
+	// Like minio, rewriting the metadata updates the LastModified time
+	info.Metadata = metadata
+	info.LastModified = storage.now()
+	storage.objects[keyname] = info
+	return nil
+}
+
+func (storage *memoryStorage) List(prefix string, doneCh <-chan struct{}) <-chan common.ObjectInfo {
+	storage.mutex.Lock()
+	objects := make([]common.ObjectInfo, 0, len(storage.objects))
+	for key, info := range storage.objects {
+		if strings.HasPrefix(key, prefix) {
+			objects = append(objects, info)
+		}
+	}
+	storage.mutex.Unlock()
+
+	objectsCh := make(chan common.ObjectInfo)
+	go func() {
+		defer close(objectsCh)
+		for _, info := range objects {
+			select {
+			case objectsCh <- info:
+			case <-doneCh:
+				return
+			}
+		}
+	}()
+	return objectsCh
+}
+
+// keys returns the sorted keys of the stored objects
+func (storage *memoryStorage) keys() []string {
+	storage.mutex.Lock()
+	defer storage.mutex.Unlock()
+	keys := make([]string, 0, len(storage.objects))
+	for key := range storage.objects {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// fakeClock is a Clock advanced and ticked by the test
+type fakeClock struct {
+	mutex sync.Mutex
+	now   time.Time
+	ticks chan time.Time
+}
+
+type fakeTicker struct {
+	clock *fakeClock
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC), ticks: make(chan time.Time)}
+}
+
+func (clock *fakeClock) Now() time.Time {
+	clock.mutex.Lock()
+	defer clock.mutex.Unlock()
+	return clock.now
+}
+
+func (clock *fakeClock) NewTicker(d time.Duration) Ticker {
+	return fakeTicker{clock}
+}
+
+// advance moves the clock forward and delivers a tick to the engine
+func (clock *fakeClock) advance(d time.Duration) {
+	clock.mutex.Lock()
+	clock.now = clock.now.Add(d)
+	now := clock.now
+	clock.mutex.Unlock()
+	clock.ticks <- now
+}
+
+func (ticker fakeTicker) Chan() <-chan time.Time {
+	return ticker.clock.ticks
+}
+
+func (ticker fakeTicker) Stop() {}
+
+// memoryArchive is an archive.Archive recording the time the frames of a
+// memoryStorage were archived at
+type memoryArchive struct {
+	mutex    sync.Mutex
+	storage  *memoryStorage
+	archived map[string]time.Time
+}
+
+func (arch *memoryArchive) Put(key string) error {
+	if _, err := arch.storage.Stat(key); err != nil {
+		return err
+	}
+	arch.mutex.Lock()
+	defer arch.mutex.Unlock()
+	arch.archived[key] = arch.storage.now()
+	return nil
+}
+
+func (arch *memoryArchive) Read(key string) (io.ReadCloser, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (arch *memoryArchive) Expire(before time.Time, onRemove func(key string, size int64, archived time.Time)) (int64, int64, error) {
+	arch.mutex.Lock()
+	defer arch.mutex.Unlock()
+	var count int64
+	for key, archived := range arch.archived {
+		if archived.Before(before) {
+			delete(arch.archived, key)
+			onRemove(key, 0, archived)
+			count++
+		}
+	}
+	return count, 0, nil
+}
+
+func (arch *memoryArchive) Walk(fn func(key string, metadata map[string]string)) error {
+	return nil
+}
+
+func (arch *memoryArchive) SetClient(client *minio.Client) {}
+
+func newTestRule(t *testing.T, name string, retentionTime string, pollInterval string) *Rule {
+	rule, err := NewRule(name, retentionTime, pollInterval)
+	if err != nil {
+		t.Fatalf("Failed to create rule: %v", err)
+	}
+	return rule
+}
+
+func store(storage *memoryStorage, key string, size int, topic string, frame map[string]interface{}) {
+	metadata := map[string]string{common.MetaTopic: topic}
+	if frame != nil {
+		metadata[common.MetaFrameMetadata], _, _ = imagestore.EncodeFrameMetadata(frame)
+	}
+	storage.StoreWithMetadata(make([]byte, size), key, metadata)
+}
+
+func expectKeys(t *testing.T, storage *memoryStorage, expected ...string) {
+	t.Helper()
+	if keys := storage.keys(); strings.Join(keys, ",") != strings.Join(expected, ",") {
+		t.Errorf("Stored keys %v, expected %v", keys, expected)
+	}
+}
+
+func TestExpiryAndPins(t *testing.T) {
+	clock := newFakeClock()
+	storage := newMemoryStorage(clock.Now)
+	store(storage, "old", 10, "camera", nil)
+	store(storage, "pinned", 10, "camera", nil)
+	storage.SetMetadata("pinned", map[string]string{common.MetaPinned: "true"})
+
+	engine := New(Config{
+		Storage:  storage,
+		Clock:    clock,
+		Settings: Settings{Default: newTestRule(t, "default", "1h", "1m")},
+	})
+	engine.Start()
+	defer engine.Stop()
+
+	clock.advance(30 * time.Minute)
+	store(storage, "new", 10, "camera", nil)
+	clock.advance(31 * time.Minute)
+	// The dry run is served after the sweep of the tick completed
+	engine.Report(nil)
+	expectKeys(t, storage, "new", "pinned")
+}
+
+func TestUncheckedPins(t *testing.T) {
+	clock := newFakeClock()
+	storage := newMemoryStorage(clock.Now)
+	store(storage, "held", 10, "camera", nil)
+	storage.SetMetadata("held", map[string]string{common.MetaPinned: "true"})
+	store(storage, "old", 10, "camera", nil)
+
+	engine := New(Config{
+		Storage:  storage,
+		Clock:    clock,
+		Settings: Settings{Default: newTestRule(t, "default", "1h", "1m")},
+	})
+	engine.Start()
+	defer engine.Stop()
+
+	// The pin of the held frame cannot be checked during the sweep
+	storage.mutex.Lock()
+	storage.unreachable["held"] = 100
+	storage.mutex.Unlock()
+	skipped := metrics.Get("retention_skipped_objects")
+	clock.advance(2 * time.Hour)
+	engine.Report(nil)
+	expectKeys(t, storage, "held")
+	if metrics.Get("retention_skipped_objects") == skipped {
+		t.Errorf("Skipped object was not counted")
+	}
+}
+
+func TestUnpinKeepsAge(t *testing.T) {
+	clock := newFakeClock()
+	storage := newMemoryStorage(clock.Now)
+	store(storage, "frame", 10, "camera", nil)
+
+	engine := New(Config{
+		Storage:  storage,
+		Clock:    clock,
+		Settings: Settings{Default: newTestRule(t, "default", "1h", "1m")},
+	})
+	engine.Start()
+	defer engine.Stop()
+
+	clock.advance(30 * time.Minute)
+	engine.Report(nil)
+	storage.SetMetadata("frame", map[string]string{common.MetaPinned: "true"})
+	storage.SetMetadata("frame", map[string]string{})
+	clock.advance(31 * time.Minute)
+	engine.Report(nil)
+	expectKeys(t, storage)
+}
+
+func TestUnknownRule(t *testing.T) {
+	clock := newFakeClock()
+	storage := newMemoryStorage(clock.Now)
+	store(storage, "result", 10, "results", nil)
+	// The first lookups of the rule fail, the pin check succeeds
+	storage.unreachable["result"] = 2
+
+	keepResults := newTestRule(t, "results", "-1", "1m")
+	keepResults.Topic = "results"
+	engine := New(Config{
+		Storage: storage,
+		Clock:   clock,
+		Settings: Settings{
+			Default: newTestRule(t, "default", "1h", "1m"),
+			Rules:   []*Rule{keepResults},
+		},
+	})
+	engine.Start()
+	defer engine.Stop()
+
+	for i := 0; i < 3; i++ {
+		clock.advance(2 * time.Hour)
+	}
+	engine.Report(nil)
+	expectKeys(t, storage, "result")
+}
+
+func TestCaptureTime(t *testing.T) {
+	clock := newFakeClock()
+	storage := newMemoryStorage(clock.Now)
+	// Replayed frame captured two hours before it was stored
+	store(storage, "replayed", 10, "camera", nil)
+	storage.SetMetadata("replayed", map[string]string{
+		common.MetaCaptured: clock.Now().Add(-2 * time.Hour).Format(time.RFC3339Nano),
+	})
+	store(storage, "live", 10, "camera", nil)
+
+	engine := New(Config{
+		Storage:  storage,
+		Clock:    clock,
+		Settings: Settings{Default: newTestRule(t, "default", "1h", "1m")},
+	})
+	engine.Start()
+	defer engine.Stop()
+
+	clock.advance(time.Minute)
+	engine.Report(nil)
+	expectKeys(t, storage, "live")
+}
+
+func TestOrderedMatchRules(t *testing.T) {
+	clock := newFakeClock()
+	storage := newMemoryStorage(clock.Now)
+	store(storage, "defect", 10, "results", map[string]interface{}{"defects": []interface{}{"scratch"}})
+	store(storage, "clean", 10, "results", map[string]interface{}{"defects": []interface{}{}})
+	store(storage, "raw", 10, "raw", nil)
+
+	defects, err := match.Compile("len(defects) > 0")
+	if err != nil {
+		t.Fatalf("Failed to compile expression: %v", err)
+	}
+	keepDefects := newTestRule(t, "defects", "-1", "1m")
+	keepDefects.Match = defects
+	results := newTestRule(t, "results", "2h", "1m")
+	results.Topic = "results"
+
+	engine := New(Config{
+		Storage: storage,
+		Clock:   clock,
+		Settings: Settings{
+			Default: newTestRule(t, "default", "-1", "1m"),
+			Rules:   []*Rule{keepDefects, results},
+		},
+	})
+	engine.Start()
+	defer engine.Stop()
+
+	clock.advance(3 * time.Hour)
+	engine.Report(nil)
+	expectKeys(t, storage, "defect", "raw")
+}
+
+func TestRulePollIntervals(t *testing.T) {
+	clock := newFakeClock()
+	storage := newMemoryStorage(clock.Now)
+	store(storage, "slow/frame", 10, "raw", nil)
+	store(storage, "fast/frame", 10, "camera", nil)
+	store(storage, "other", 10, "raw", nil)
+	clock.mutex.Lock()
+	clock.now = clock.now.Add(50 * time.Minute)
+	clock.mutex.Unlock()
+
+	slow := newTestRule(t, "slow", "1h", "30m")
+	slow.Prefix = "slow/"
+	fast := newTestRule(t, "fast", "1h", "1m")
+	fast.Topic = "camera"
+	engine := New(Config{
+		Storage: storage,
+		Clock:   clock,
+		Settings: Settings{
+			Default: newTestRule(t, "default", "-1", "1m"),
+			Rules:   []*Rule{slow, fast},
+		},
+	})
+	engine.Start()
+	defer engine.Stop()
+	// Waiting for the first sweep, nothing expired yet
+	engine.Report(nil)
+	expectKeys(t, storage, "fast/frame", "other", "slow/frame")
+
+	// Both frames expired, the slow rule is not due yet
+	clock.advance(15 * time.Minute)
+	engine.Report(nil)
+	expectKeys(t, storage, "other", "slow/frame")
+
+	clock.advance(15 * time.Minute)
+	engine.Report(nil)
+	expectKeys(t, storage, "other")
+}
+
+func TestQuotaEviction(t *testing.T) {
+	clock := newFakeClock()
+	storage := newMemoryStorage(clock.Now)
+	for _, key := range []string{"a", "b", "c", "d"} {
+		store(storage, key, 100, "camera", nil)
+		clock.mutex.Lock()
+		clock.now = clock.now.Add(time.Minute)
+		clock.mutex.Unlock()
+	}
+
+	engine := New(Config{
+		Storage: storage,
+		Clock:   clock,
+		Settings: Settings{
+			Default: newTestRule(t, "default", "-1", "1m"),
+			Quota:   Quota{MaxBytes: 300, LowWatermark: 0.75, Order: EvictOldest},
+		},
+	})
+	engine.Start()
+	defer engine.Stop()
+
+	// Evicting the oldest objects down to 225 bytes
+	engine.Report(nil)
+	expectKeys(t, storage, "c", "d")
+}
+
+func TestDryRunReport(t *testing.T) {
+	clock := newFakeClock()
+	storage := newMemoryStorage(clock.Now)
+	store(storage, "a", 10, "camera", nil)
+	store(storage, "b", 20, "results", nil)
+	clock.mutex.Lock()
+	clock.now = clock.now.Add(2 * time.Hour)
+	clock.mutex.Unlock()
+
+	// Retention is disabled, so the engine only serves the dry runs
+	engine := New(Config{
+		Storage:  storage,
+		Clock:    clock,
+		Settings: Settings{Default: newTestRule(t, "default", "-1", "1m")},
+	})
+	engine.Start()
+
+	retentionTime := time.Hour
+	report := engine.Report(&retentionTime)
+	if report == nil || report.Expired.Objects != 2 || report.Expired.Bytes != 30 {
+		t.Fatalf("Dry run reported %v, expected 2 expired objects of 30 bytes", report)
+	}
+	if report.ByTopic["results"].Bytes != 20 || report.ByAge["1h-1d"].Objects != 2 {
+		t.Errorf("Dry run reported %v by topic and age", report)
+	}
+	expectKeys(t, storage, "a", "b")
+
+	engine.Stop()
+	if report := engine.Report(nil); report != nil {
+		t.Errorf("Stopped engine reported %v", report)
+	}
+}
+
+func TestArchiveKeepsHandles(t *testing.T) {
+	clock := newFakeClock()
+	storage := newMemoryStorage(clock.Now)
+	store(storage, "line1/frame", 10, "camera", nil)
+	handles := imagestore.NewHandleIndex()
+	handles.Set("frame", "line1/frame", "camera")
+
+	engine := New(Config{
+		Storage:     storage,
+		Clock:       clock,
+		Settings:    Settings{Default: newTestRule(t, "default", "1h", "1m")},
+		Handles:     handles,
+		Archive:     &memoryArchive{storage: storage, archived: make(map[string]time.Time)},
+		ArchiveRule: newTestRule(t, "archive", "2h", "1m"),
+	})
+	engine.Start()
+	defer engine.Stop()
+
+	// The archived frame is still read by its handle
+	clock.advance(61 * time.Minute)
+	engine.Report(nil)
+	expectKeys(t, storage)
+	if keyname, _ := handles.Resolve("frame", ""); keyname != "line1/frame" {
+		t.Errorf("Handle of an archived frame resolved to %s", keyname)
+	}
+
+	// and forgotten once the archive expired it
+	clock.advance(121 * time.Minute)
+	engine.Report(nil)
+	if keyname, _ := handles.Resolve("frame", ""); keyname != "frame" {
+		t.Errorf("Handle of an expired archived frame resolved to %s", keyname)
+	}
+}
@@ -0,0 +1,242 @@
+/*
+Copyright (c) 2021 Intel Corporation
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package retention
+
+import (
+	common "IEdgeInsights/ImageStore/common"
+	isConfigMgr "IEdgeInsights/ImageStore/isconfigmgr"
+	match "IEdgeInsights/ImageStore/match"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// Eviction orders of the size based retention
+const (
+	EvictOldest = "oldest"
+	EvictLRU    = "lru"
+)
+
+// Default fraction of a storage quota the usage is evicted down to
+const defaultLowWatermark = 0.9
+
+// Rule holds the retention time and poll interval applied to the objects of
+// a topic, of a handle prefix and/or matching a frame metadata expression
+type Rule struct {
+	Name          string
+	Topic         string
+	Prefix        string
+	Match         *match.Expression
+	RetentionTime time.Duration // 0 keeps the objects forever
+	PollInterval  time.Duration
+	lastRun       time.Time
+}
+
+// NewRule - function to create a rule applying to every object. A
+// retentionTime of "-1" keeps the objects forever.
+func NewRule(name string, retentionTime string, pollInterval string) (*Rule, error) {
+	rule := &Rule{Name: name}
+
+	var err error
+	if retentionTime != "-1" {
+		if rule.RetentionTime, err = time.ParseDuration(retentionTime); err != nil {
+			return nil, fmt.Errorf("failed to parse retention time duration: %v", err)
+		}
+	}
+	if rule.PollInterval, err = time.ParseDuration(pollInterval); err != nil {
+		return nil, fmt.Errorf("failed to parse retention poll interval duration: %v", err)
+	}
+	if rule.PollInterval <= 0 {
+		return nil, errors.New("retention poll interval must be positive")
+	}
+	return rule, nil
+}
+
+// matches reports whether the rule applies to the given object
+func (rule *Rule) matches(key string, topic string, frame map[string]interface{}) bool {
+	if rule.Topic != "" && rule.Topic != topic {
+		return false
+	}
+	if rule.Match != nil && !rule.Match.Match(frame) {
+		return false
+	}
+	return strings.HasPrefix(key, rule.Prefix)
+}
+
+// Quota holds the size based retention settings
+type Quota struct {
+	MaxBytes      int64
+	TopicMaxBytes map[string]int64
+	LowWatermark  float64
+	Order         string
+}
+
+// enabled reports whether any storage quota is set
+func (quota *Quota) enabled() bool {
+	return quota.MaxBytes > 0 || len(quota.TopicMaxBytes) > 0
+}
+
+// selectEvictions returns the objects to remove to bring the storage usage
+// back down to the low watermark of the exceeded quotas, topic quotas first.
+// Objects are evicted in the order of their last access, objects for which
+// evictable returns false are kept.
+func (quota *Quota) selectEvictions(objects []common.ObjectInfo, lastAccess func(common.ObjectInfo) time.Time, topicOf func(string) string, evictable func(string) bool) []common.ObjectInfo {
+	sort.Slice(objects, func(i, j int) bool {
+		return lastAccess(objects[i]).Before(lastAccess(objects[j]))
+	})
+
+	evicted := make([]common.ObjectInfo, 0)
+	selected := make(map[string]bool)
+
+	if len(quota.TopicMaxBytes) > 0 {
+		usage := make(map[string]int64)
+		for _, obj := range objects {
+			usage[topicOf(obj.Key)] += obj.Size
+		}
+		for topic, maxBytes := range quota.TopicMaxBytes {
+			if usage[topic] <= maxBytes {
+				continue
+			}
+			glog.Infof("Topic %s uses %d bytes, over its quota of %d bytes", topic, usage[topic], maxBytes)
+			target := int64(float64(maxBytes) * quota.LowWatermark)
+			for _, obj := range objects {
+				if usage[topic] <= target {
+					break
+				}
+				if topicOf(obj.Key) == topic && evictable(obj.Key) {
+					evicted = append(evicted, obj)
+					selected[obj.Key] = true
+					usage[topic] -= obj.Size
+				}
+			}
+		}
+	}
+
+	if quota.MaxBytes > 0 {
+		var total int64
+		for _, obj := range objects {
+			if !selected[obj.Key] {
+				total += obj.Size
+			}
+		}
+		if total > quota.MaxBytes {
+			glog.Infof("Storage uses %d bytes, over the quota of %d bytes", total, quota.MaxBytes)
+			target := int64(float64(quota.MaxBytes) * quota.LowWatermark)
+			for _, obj := range objects {
+				if total <= target {
+					break
+				}
+				if !selected[obj.Key] && evictable(obj.Key) {
+					evicted = append(evicted, obj)
+					total -= obj.Size
+				}
+			}
+		}
+	}
+	return evicted
+}
+
+// Settings holds the retention settings, which can be reloaded live
+type Settings struct {
+	// Default is the rule of the objects matched by none of Rules
+	Default *Rule
+	// Rules are evaluated in order, the first matching rule applies
+	Rules []*Rule
+	Quota Quota
+}
+
+// enabled reports whether the settings remove any object
+func (settings *Settings) enabled() bool {
+	return settings.Default.RetentionTime > 0 || settings.Quota.enabled() || len(settings.Rules) > 0
+}
+
+// NewSettings creates the retention settings from the config.
+//
+// Parameters:
+// 1. minIoConfig : isConfigMgr.Minio
+//    Refers to the minio config holding the default rule and the quota.
+// 2. config : isConfigMgr.Configuration
+//    Refers to the app config holding the retention policies and the topic
+//    quotas.
+//
+// Returns:
+// 1. Settings
+//    Returns the retention settings.
+// 2. error
+//    Returns an error object if the config is invalid.
+func NewSettings(minIoConfig isConfigMgr.Minio, config isConfigMgr.Configuration) (Settings, error) {
+	var settings Settings
+	defaultRule, err := NewRule("default", minIoConfig.RetentionTime, minIoConfig.RetentionPollInterval)
+	if err != nil {
+		return settings, err
+	}
+
+	rules, err := parseRules(config.RetentionPolicies)
+	if err != nil {
+		return settings, fmt.Errorf("invalid retention policies: %v", err)
+	}
+
+	quota := Quota{
+		MaxBytes:      minIoConfig.MaxStorageBytes,
+		TopicMaxBytes: config.TopicMaxStorageBytes,
+		LowWatermark:  minIoConfig.StorageLowWatermark,
+		Order:         minIoConfig.EvictionPolicy,
+	}
+	if quota.LowWatermark == 0 {
+		quota.LowWatermark = defaultLowWatermark
+	}
+	if quota.Order == "" {
+		quota.Order = EvictOldest
+	}
+
+	return Settings{Default: defaultRule, Rules: rules, Quota: quota}, nil
+}
+
+// parseRules converts the configured retention policies
+func parseRules(policies []isConfigMgr.RetentionPolicy) ([]*Rule, error) {
+	rules := make([]*Rule, 0, len(policies))
+	for i, policy := range policies {
+		if policy.Topic == "" && policy.Prefix == "" && policy.Match == "" {
+			return nil, fmt.Errorf("retention policy %d needs a topic, a prefix or a match", i)
+		}
+
+		name := fmt.Sprintf("topic=%s,prefix=%s,match=%s", policy.Topic, policy.Prefix, policy.Match)
+		rule, err := NewRule(name, policy.RetentionTime, policy.PollInterval)
+		if err != nil {
+			return nil, fmt.Errorf("retention policy %s: %v", name, err)
+		}
+		rule.Topic = policy.Topic
+		rule.Prefix = policy.Prefix
+		if policy.Match != "" {
+			if rule.Match, err = match.Compile(policy.Match); err != nil {
+				return nil, fmt.Errorf("retention policy %d: %v", i, err)
+			}
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
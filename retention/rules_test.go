@@ -0,0 +1,187 @@
+/*
+Copyright (c) 2021 Intel Corporation
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package retention
+
+import (
+	common "IEdgeInsights/ImageStore/common"
+	imagestore "IEdgeInsights/ImageStore/go/imagestore"
+	isConfigMgr "IEdgeInsights/ImageStore/isconfigmgr"
+	"strings"
+	"testing"
+	"time"
+)
+
+// objectKeys returns the keys of the objects, in order
+func objectKeys(objects []common.ObjectInfo) string {
+	keys := make([]string, len(objects))
+	for i, obj := range objects {
+		keys[i] = obj.Key
+	}
+	return strings.Join(keys, ",")
+}
+
+func TestSelectEvictions(t *testing.T) {
+	start := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	topics := map[string]string{"a": "camera1", "b": "camera2", "c": "camera1", "d": "camera2", "e": "camera1"}
+	var objects []common.ObjectInfo
+	for i, key := range []string{"a", "b", "c", "d", "e"} {
+		objects = append(objects, common.ObjectInfo{Key: key, Size: 100, LastModified: start.Add(time.Duration(i) * time.Minute)})
+	}
+	lastAccess := func(obj common.ObjectInfo) time.Time {
+		return obj.LastModified
+	}
+	topicOf := func(key string) string {
+		return topics[key]
+	}
+	pinned := map[string]bool{"a": true}
+	evictable := func(key string) bool {
+		return !pinned[key]
+	}
+
+	tests := []struct {
+		name  string
+		quota Quota
+		want  string
+	}{
+		{"under quota", Quota{MaxBytes: 500, LowWatermark: 0.5}, ""},
+		// The pinned oldest object is kept, down to 200 bytes
+		{"storage quota", Quota{MaxBytes: 400, LowWatermark: 0.5}, "b,c,d"},
+		{"topic quota", Quota{TopicMaxBytes: map[string]int64{"camera1": 200}, LowWatermark: 1}, "c"},
+		// The topic evictions count towards the storage quota
+		{"both quotas", Quota{MaxBytes: 400, TopicMaxBytes: map[string]int64{"camera1": 200}, LowWatermark: 1}, "c"},
+		{"topic then storage quota", Quota{MaxBytes: 300, TopicMaxBytes: map[string]int64{"camera1": 200}, LowWatermark: 1}, "c,b"},
+	}
+	for _, test := range tests {
+		candidates := append([]common.ObjectInfo(nil), objects...)
+		evicted := test.quota.selectEvictions(candidates, lastAccess, topicOf, evictable)
+		if got := objectKeys(evicted); got != test.want {
+			t.Errorf("%s: evicted %s, expected %s", test.name, got, test.want)
+		}
+	}
+}
+
+func TestLRUEviction(t *testing.T) {
+	clock := newFakeClock()
+	storage := newMemoryStorage(clock.Now)
+	for _, key := range []string{"a", "b", "c", "d"} {
+		store(storage, key, 100, "camera", nil)
+		clock.mutex.Lock()
+		clock.now = clock.now.Add(time.Minute)
+		clock.mutex.Unlock()
+	}
+	// The oldest object was read last
+	tracker := imagestore.NewReadTracker()
+	tracker.Touch("a")
+
+	engine := New(Config{
+		Storage: storage,
+		Clock:   clock,
+		Tracker: tracker,
+		Settings: Settings{
+			Default: newTestRule(t, "default", "-1", "1m"),
+			Quota:   Quota{MaxBytes: 300, LowWatermark: 0.75, Order: EvictLRU},
+		},
+	})
+	engine.Start()
+	defer engine.Stop()
+
+	engine.Report(nil)
+	expectKeys(t, storage, "a", "d")
+	if _, ok := tracker.LastRead("b"); ok {
+		t.Errorf("Evicted object still tracked")
+	}
+}
+
+func TestNewSettingsQuota(t *testing.T) {
+	minIoConfig := isConfigMgr.Minio{RetentionTime: "-1", RetentionPollInterval: "1m", MaxStorageBytes: 1000}
+	settings, err := NewSettings(minIoConfig, isConfigMgr.Configuration{TopicMaxStorageBytes: map[string]int64{"camera": 100}})
+	if err != nil {
+		t.Fatalf("NewSettings failed: %v", err)
+	}
+	quota := settings.Quota
+	if quota.MaxBytes != 1000 || quota.TopicMaxBytes["camera"] != 100 || quota.LowWatermark != defaultLowWatermark || quota.Order != EvictOldest {
+		t.Errorf("Unexpected quota %+v", quota)
+	}
+	if !settings.enabled() {
+		t.Errorf("Quota without retention time not enabled")
+	}
+
+	minIoConfig.StorageLowWatermark = 0.5
+	minIoConfig.EvictionPolicy = EvictLRU
+	if settings, err = NewSettings(minIoConfig, isConfigMgr.Configuration{}); err != nil {
+		t.Fatalf("NewSettings failed: %v", err)
+	}
+	if settings.Quota.LowWatermark != 0.5 || settings.Quota.Order != EvictLRU {
+		t.Errorf("Unexpected quota %+v", settings.Quota)
+	}
+}
+
+func TestParseRules(t *testing.T) {
+	rules, err := parseRules([]isConfigMgr.RetentionPolicy{
+		{Topic: "camera", RetentionTime: "2h", PollInterval: "1m"},
+		{Prefix: "keep/", RetentionTime: "-1", PollInterval: "10m"},
+		{Match: "score > 0.5", RetentionTime: "30m", PollInterval: "1m"},
+	})
+	if err != nil {
+		t.Fatalf("parseRules failed: %v", err)
+	}
+	if len(rules) != 3 || rules[0].Topic != "camera" || rules[0].RetentionTime != 2*time.Hour ||
+		rules[1].Prefix != "keep/" || rules[1].RetentionTime != 0 || rules[1].PollInterval != 10*time.Minute ||
+		rules[2].Match == nil {
+		t.Errorf("Unexpected rules %+v", rules)
+	}
+
+	invalid := []isConfigMgr.RetentionPolicy{
+		{RetentionTime: "1h", PollInterval: "1m"},
+		{Topic: "camera", RetentionTime: "an hour", PollInterval: "1m"},
+		{Topic: "camera", RetentionTime: "1h", PollInterval: "0s"},
+		{Match: "score >", RetentionTime: "1h", PollInterval: "1m"},
+	}
+	for _, policy := range invalid {
+		if _, err := parseRules([]isConfigMgr.RetentionPolicy{policy}); err == nil {
+			t.Errorf("Invalid policy %+v accepted", policy)
+		}
+	}
+}
+
+func TestRuleMatches(t *testing.T) {
+	rule := &Rule{Topic: "camera", Prefix: "line1/"}
+	tests := []struct {
+		key   string
+		topic string
+		want  bool
+	}{
+		{"line1/frame", "camera", true},
+		{"line2/frame", "camera", false},
+		{"line1/frame", "results", false},
+		{"line1/frame", "", false},
+	}
+	for _, test := range tests {
+		if got := rule.matches(test.key, test.topic, nil); got != test.want {
+			t.Errorf("Rule matched %s of topic %q: %v, expected %v", test.key, test.topic, got, test.want)
+		}
+	}
+	if !(&Rule{}).matches("frame", "", nil) {
+		t.Errorf("Rule without selector not matching")
+	}
+}
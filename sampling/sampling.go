@@ -0,0 +1,130 @@
+/*
+Copyright (c) 2021 Intel Corporation
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+// Package sampling decides which of the frames received on a topic are
+// stored, to keep every Nth frame, cap the frame rate or keep only changes
+package sampling
+
+import (
+	match "IEdgeInsights/ImageStore/match"
+	"encoding/json"
+	"time"
+)
+
+// Reasons a frame is sampled out
+const (
+	ReasonUnchanged = "unchanged"
+	ReasonEveryNth  = "every_nth"
+	ReasonRate      = "rate"
+)
+
+// Policy holds the sampling settings of a topic
+type Policy struct {
+	// EveryNth stores one frame out of EveryNth, 0 or 1 stores them all
+	EveryNth int
+	// MaxFPS caps the stored frames per second, 0 for no limit
+	MaxFPS float64
+	// OnChange stores a frame only when one of these metadata fields
+	// changed since the last stored frame, empty to store them all
+	OnChange []string
+	// Bypass stores the matching frames regardless of the sampling, without
+	// counting them for EveryNth and MaxFPS, may be nil
+	Bypass *match.Expression
+}
+
+// Sampler applies a sampling policy to the frames of a topic, in the order
+// they are received. A Sampler is not safe for concurrent use.
+type Sampler struct {
+	policy     Policy
+	interval   time.Duration
+	count      int
+	lastStored time.Time
+	lastValues map[string]string
+}
+
+// NewSampler - function to create the sampler of a policy
+func NewSampler(policy Policy) *Sampler {
+	sampler := &Sampler{policy: policy}
+	if policy.MaxFPS > 0 {
+		sampler.interval = time.Duration(float64(time.Second) / policy.MaxFPS)
+	}
+	return sampler
+}
+
+// Sample reports whether a frame is stored.
+//
+// Parameters:
+// 1. frame : map[string]interface{}
+//    Refers to the metadata the frame was published with.
+// 2. now : time.Time
+//    Refers to the time the frame was received.
+//
+// Returns:
+// 1. bool
+//    Returns true if the frame is stored.
+// 2. string
+//    Returns why the frame is sampled out, ReasonUnchanged, ReasonEveryNth
+//    or ReasonRate, empty if it is stored.
+// 3. bool
+//    Returns true if the frame is stored because it matched Bypass.
+func (sampler *Sampler) Sample(frame map[string]interface{}, now time.Time) (bool, string, bool) {
+	if sampler.policy.Bypass != nil && sampler.policy.Bypass.Match(frame) {
+		return true, "", true
+	}
+
+	var values map[string]string
+	if len(sampler.policy.OnChange) > 0 {
+		values = make(map[string]string, len(sampler.policy.OnChange))
+		changed := sampler.lastValues == nil
+		for _, field := range sampler.policy.OnChange {
+			encoded, _ := json.Marshal(frame[field])
+			values[field] = string(encoded)
+			if values[field] != sampler.lastValues[field] {
+				changed = true
+			}
+		}
+		if !changed {
+			return false, ReasonUnchanged, false
+		}
+	}
+
+	if sampler.policy.EveryNth > 1 {
+		sampler.count++
+		if (sampler.count-1)%sampler.policy.EveryNth != 0 {
+			return false, ReasonEveryNth, false
+		}
+	}
+
+	// A 1% tolerance keeps frames arriving marginally early, e.g. 30 fps
+	// capped at 10 fps, from lowering the rate further
+	if sampler.interval > 0 && !sampler.lastStored.IsZero() && now.Sub(sampler.lastStored) < sampler.interval-sampler.interval/100 {
+		return false, ReasonRate, false
+	}
+
+	// The state only follows the stored frames, so a change sampled out by
+	// the frame rate is stored with the next frame
+	sampler.lastStored = now
+	if values != nil {
+		sampler.lastValues = values
+	}
+	return true, "", false
+}
@@ -0,0 +1,95 @@
+/*
+Copyright (c) 2021 Intel Corporation
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package sampling
+
+import (
+	match "IEdgeInsights/ImageStore/match"
+	"testing"
+	"time"
+)
+
+// sample runs the frames through the sampler, one every frameInterval, and
+// returns which were stored
+func sample(sampler *Sampler, frames []map[string]interface{}, frameInterval time.Duration) []bool {
+	now := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	stored := make([]bool, len(frames))
+	for i, frame := range frames {
+		stored[i], _, _ = sampler.Sample(frame, now)
+		now = now.Add(frameInterval)
+	}
+	return stored
+}
+
+func frames(count int) []map[string]interface{} {
+	frames := make([]map[string]interface{}, count)
+	for i := range frames {
+		frames[i] = map[string]interface{}{}
+	}
+	return frames
+}
+
+func expectStored(t *testing.T, stored []bool, expected ...bool) {
+	t.Helper()
+	for i := range expected {
+		if stored[i] != expected[i] {
+			t.Errorf("Stored frames %v, expected %v", stored, expected)
+			return
+		}
+	}
+}
+
+func TestEveryNth(t *testing.T) {
+	stored := sample(NewSampler(Policy{EveryNth: 3}), frames(7), time.Millisecond)
+	expectStored(t, stored, true, false, false, true, false, false, true)
+}
+
+func TestMaxFPS(t *testing.T) {
+	// 30 fps capped at 10 fps
+	stored := sample(NewSampler(Policy{MaxFPS: 10}), frames(7), time.Second/30)
+	expectStored(t, stored, true, false, false, true, false, false, true)
+}
+
+func TestOnChangeAndBypass(t *testing.T) {
+	bypass, err := match.Compile("len(defects) > 0")
+	if err != nil {
+		t.Fatalf("Failed to compile expression: %v", err)
+	}
+	sampler := NewSampler(Policy{OnChange: []string{"class"}, MaxFPS: 1, Bypass: bypass})
+
+	input := []map[string]interface{}{
+		{"class": "ok"},
+		{"class": "ok"},
+		{"class": "ok", "defects": []interface{}{"scratch"}},
+		// Changed but over the frame rate, stored with the next frame
+		{"class": "nok"},
+		{"class": "nok"},
+		{"class": "nok"},
+	}
+	stored := sample(sampler, input, 300*time.Millisecond)
+	expectStored(t, stored, true, false, true, false, true, false)
+
+	_, reason, bypassed := sampler.Sample(map[string]interface{}{"class": "nok"}, time.Now())
+	if reason != ReasonUnchanged || bypassed {
+		t.Errorf("Unchanged frame sampled out by %q, bypassed %v", reason, bypassed)
+	}
+}
@@ -0,0 +1,337 @@
+/*
+Copyright (c) 2021 Intel Corporation
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+// Package spool keeps the writes which failed on the object store in a local
+// directory, one file per write, until they are replayed.
+package spool
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	isConfigMgr "IEdgeInsights/ImageStore/isconfigmgr"
+)
+
+// ErrFull is returned by Put when the spool limits are reached
+var ErrFull = errors.New("spool is full")
+
+// Suffix of the spool files, the temporary files being left out
+const (
+	entrySuffix = ".spool"
+	tempSuffix  = ".tmp"
+)
+
+// DeadLetterDir is the subdirectory of the spool keeping the entries which
+// can never be written, for inspection
+const DeadLetterDir = "dead-letter"
+
+// Defaults of the spool config
+const (
+	defaultPath       = "/data/.imagestore/spool"
+	defaultMaxBytes   = 1024 * 1024 * 1024
+	defaultMinBackoff = "1s"
+	defaultMaxBackoff = "1m"
+)
+
+// Entry is a spooled write
+type Entry struct {
+	Key      string            `json:"key"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+	Data     []byte            `json:"-"`
+}
+
+// Spool is a directory of spooled writes, limited in entries and in bytes,
+// read back oldest first
+type Spool struct {
+	mutex      sync.Mutex
+	dir        string
+	maxBytes   int64
+	maxEntries int
+	names      []string // oldest first
+	sizes      map[string]int64
+	bytes      int64
+	seq        int64
+	notify     chan struct{}
+}
+
+// Open - function to open or create the spool in dir. The entries left by
+// a previous run are kept, so they are replayed after a restart.
+//
+// Parameters:
+// 1. dir : string
+//    Refers to the spool directory, created if needed.
+// 2. maxBytes : int64
+//    Refers to the size of the spool files above which writes are rejected.
+// 3. maxEntries : int
+//    Refers to the number of entries above which writes are rejected, 0
+//    for no limit.
+//
+// Returns:
+// 1. *Spool
+//    Returns the spool.
+// 2. error
+//    Returns an error object if the directory can not be read.
+func Open(dir string, maxBytes int64, maxEntries int) (*Spool, error) {
+	if maxBytes <= 0 || maxEntries < 0 {
+		return nil, fmt.Errorf("invalid spool limits: maxBytes %d, maxEntries %d", maxBytes, maxEntries)
+	}
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return nil, err
+	}
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	spool := &Spool{
+		dir:        dir,
+		maxBytes:   maxBytes,
+		maxEntries: maxEntries,
+		sizes:      make(map[string]int64),
+		notify:     make(chan struct{}, 1),
+	}
+	for _, file := range files {
+		name := file.Name()
+		if strings.HasSuffix(name, tempSuffix) {
+			// Interrupted before it was complete
+			os.Remove(filepath.Join(dir, name))
+			continue
+		}
+		if file.IsDir() || !strings.HasSuffix(name, entrySuffix) {
+			continue
+		}
+		spool.names = append(spool.names, name)
+		spool.sizes[name] = file.Size()
+		spool.bytes += file.Size()
+	}
+	// The names start with the zero padded spool time
+	sort.Strings(spool.names)
+	return spool, nil
+}
+
+// FromConfig - function to open the spool of the spool config, the missing
+// values taking their defaults: "/data/.imagestore/spool" limited to 1 GiB,
+// replayed with a backoff from 1s to 1m.
+//
+// Returns:
+// 1. *Spool
+//    Returns the spool.
+// 2. time.Duration
+//    Returns the first replay backoff.
+// 3. time.Duration
+//    Returns the largest replay backoff.
+// 4. error
+//    Returns an error object if the config is invalid or the directory can
+//    not be read.
+func FromConfig(config *isConfigMgr.Spool) (*Spool, time.Duration, time.Duration, error) {
+	path := config.Path
+	if path == "" {
+		path = defaultPath
+	}
+	maxBytes := config.MaxBytes
+	if maxBytes == 0 {
+		maxBytes = defaultMaxBytes
+	}
+
+	minBackoffStr := config.MinBackoff
+	if minBackoffStr == "" {
+		minBackoffStr = defaultMinBackoff
+	}
+	maxBackoffStr := config.MaxBackoff
+	if maxBackoffStr == "" {
+		maxBackoffStr = defaultMaxBackoff
+	}
+	minBackoff, err := time.ParseDuration(minBackoffStr)
+	if err != nil {
+		return nil, 0, 0, fmt.Errorf("minBackoff is invalid: %v", err)
+	}
+	maxBackoff, err := time.ParseDuration(maxBackoffStr)
+	if err != nil {
+		return nil, 0, 0, fmt.Errorf("maxBackoff is invalid: %v", err)
+	}
+	if minBackoff <= 0 || minBackoff > maxBackoff {
+		return nil, 0, 0, errors.New("minBackoff must be positive and not exceed maxBackoff")
+	}
+
+	spool, err := Open(path, maxBytes, config.MaxEntries)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	return spool, minBackoff, maxBackoff, nil
+}
+
+// Put - function to add an entry, synced to disk before returning
+func (spool *Spool) Put(entry Entry) error {
+	header, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	size := int64(len(header) + 1 + len(entry.Data))
+
+	spool.mutex.Lock()
+	defer spool.mutex.Unlock()
+	if spool.bytes+size > spool.maxBytes || (spool.maxEntries > 0 && len(spool.names) >= spool.maxEntries) {
+		return ErrFull
+	}
+
+	spool.seq++
+	name := fmt.Sprintf("%020d-%06d%s", time.Now().UnixNano(), spool.seq%1000000, entrySuffix)
+	if err := spool.write(name, header, entry.Data); err != nil {
+		return err
+	}
+	spool.names = append(spool.names, name)
+	spool.sizes[name] = size
+	spool.bytes += size
+
+	select {
+	case spool.notify <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// write - function to write an entry to a temporary file renamed once synced
+func (spool *Spool) write(name string, header []byte, data []byte) error {
+	temp := filepath.Join(spool.dir, name+tempSuffix)
+	file, err := os.OpenFile(temp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0640)
+	if err != nil {
+		return err
+	}
+	writer := bufio.NewWriter(file)
+	writer.Write(header)
+	writer.WriteByte('\n')
+	writer.Write(data)
+	if err = writer.Flush(); err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(temp, filepath.Join(spool.dir, name))
+	}
+	if err != nil {
+		os.Remove(temp)
+		return err
+	}
+	// The rename is only durable once the directory is synced
+	return syncDir(spool.dir)
+}
+
+// syncDir - function to sync a directory, persisting the renames in it
+func syncDir(dir string) error {
+	file, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	err = file.Sync()
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// Oldest - function to read the oldest entry, false if the spool is empty.
+// The entry stays in the spool until it is removed. An error is returned
+// with the name of an entry which can not be read, to be removed.
+func (spool *Spool) Oldest() (string, Entry, bool, error) {
+	spool.mutex.Lock()
+	if len(spool.names) == 0 {
+		spool.mutex.Unlock()
+		return "", Entry{}, false, nil
+	}
+	name := spool.names[0]
+	spool.mutex.Unlock()
+
+	var entry Entry
+	content, err := ioutil.ReadFile(filepath.Join(spool.dir, name))
+	if err != nil {
+		return name, entry, true, err
+	}
+	newline := bytes.IndexByte(content, '\n')
+	if newline < 0 {
+		return name, entry, true, errors.New("spool entry " + name + " has no header")
+	}
+	if err := json.Unmarshal(content[:newline], &entry); err != nil {
+		return name, entry, true, fmt.Errorf("spool entry %s has an invalid header: %v", name, err)
+	}
+	entry.Data = content[newline+1:]
+	return name, entry, true, nil
+}
+
+// Remove - function to remove a replayed entry
+func (spool *Spool) Remove(name string) error {
+	spool.mutex.Lock()
+	defer spool.mutex.Unlock()
+
+	for i, spooled := range spool.names {
+		if spooled == name {
+			spool.names = append(spool.names[:i], spool.names[i+1:]...)
+			spool.bytes -= spool.sizes[name]
+			delete(spool.sizes, name)
+			break
+		}
+	}
+	err := os.Remove(filepath.Join(spool.dir, name))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// DeadLetter - function to move an entry which can never be replayed to the
+// dead-letter directory of the spool, out of the replayed entries
+func (spool *Spool) DeadLetter(name string) error {
+	deadLetter := filepath.Join(spool.dir, DeadLetterDir)
+	if err := os.MkdirAll(deadLetter, 0750); err != nil {
+		return err
+	}
+	if err := os.Rename(filepath.Join(spool.dir, name), filepath.Join(deadLetter, name)); err != nil {
+		return err
+	}
+	if err := syncDir(deadLetter); err != nil {
+		return err
+	}
+	return spool.Remove(name)
+}
+
+// Len - function to get the number of entries and bytes spooled
+func (spool *Spool) Len() (int, int64) {
+	spool.mutex.Lock()
+	defer spool.mutex.Unlock()
+	return len(spool.names), spool.bytes
+}
+
+// Notify - function to get the channel signaled when an entry is added
+func (spool *Spool) Notify() <-chan struct{} {
+	return spool.notify
+}
@@ -0,0 +1,163 @@
+/*
+Copyright (c) 2021 Intel Corporation
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package spool
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	isConfigMgr "IEdgeInsights/ImageStore/isconfigmgr"
+)
+
+func TestSpoolReplayOrder(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	spool, err := Open(dir, 1<<20, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"a", "b"} {
+		entry := Entry{Key: key, Metadata: map[string]string{"Topic": "camera1"}, Data: []byte("data of " + key)}
+		if err := spool.Put(entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The entries survive a restart
+	spool, err = Open(dir, 1<<20, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"a", "b"} {
+		name, entry, ok, err := spool.Oldest()
+		if !ok || err != nil {
+			t.Fatalf("Oldest() = %v, %v, want entry %s", ok, err, key)
+		}
+		if entry.Key != key || entry.Metadata["Topic"] != "camera1" || !bytes.Equal(entry.Data, []byte("data of "+key)) {
+			t.Errorf("unexpected entry %+v, want %s", entry, key)
+		}
+		if err := spool.Remove(name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if entries, size := spool.Len(); entries != 0 || size != 0 {
+		t.Errorf("Len() = %d, %d after removing every entry", entries, size)
+	}
+	if _, _, ok, _ := spool.Oldest(); ok {
+		t.Errorf("Oldest() found an entry in an empty spool")
+	}
+}
+
+func TestSpoolLimits(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	spool, err := Open(dir, 1<<20, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	spool.Put(Entry{Key: "a"})
+	spool.Put(Entry{Key: "b"})
+	if err := spool.Put(Entry{Key: "c"}); err != ErrFull {
+		t.Errorf("Put() over maxEntries = %v, want ErrFull", err)
+	}
+
+	spool, err = Open(dir, 64, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := spool.Put(Entry{Key: "d", Data: make([]byte, 64)}); err != ErrFull {
+		t.Errorf("Put() over maxBytes = %v, want ErrFull", err)
+	}
+}
+
+func TestSpoolDeadLetter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	spool, err := Open(dir, 1<<20, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	spool.Put(Entry{Key: "invalid", Data: []byte("data")})
+	spool.Put(Entry{Key: "valid", Data: []byte("data")})
+	name, _, _, _ := spool.Oldest()
+	if err := spool.DeadLetter(name); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, DeadLetterDir, name)); err != nil {
+		t.Errorf("Dead letter entry missing: %v", err)
+	}
+
+	// The dead letters are not replayed, even after a restart
+	spool, err = Open(dir, 1<<20, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entries, _ := spool.Len(); entries != 1 {
+		t.Errorf("Len() = %d after dead lettering, want 1", entries)
+	}
+	if _, entry, _, _ := spool.Oldest(); entry.Key != "valid" {
+		t.Errorf("Oldest() = %s, want valid", entry.Key)
+	}
+}
+
+func TestFromConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	spool, minBackoff, maxBackoff, err := FromConfig(&isConfigMgr.Spool{Path: dir})
+	if err != nil {
+		t.Fatalf("FromConfig() failed: %v", err)
+	}
+	if spool.maxBytes != defaultMaxBytes || minBackoff != time.Second || maxBackoff != time.Minute {
+		t.Errorf("Limited to %d bytes with backoff %v to %v, expected the defaults", spool.maxBytes, minBackoff, maxBackoff)
+	}
+
+	for _, config := range []isConfigMgr.Spool{
+		{Path: dir, MinBackoff: "soon"},
+		{Path: dir, MinBackoff: "1m", MaxBackoff: "1s"},
+		{Path: dir, MaxBytes: -1},
+	} {
+		if _, _, _, err := FromConfig(&config); err == nil {
+			t.Errorf("FromConfig(%+v) accepted", config)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+/*
+Copyright (c) 2021 Intel Corporation
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package submanager
+
+import (
+	ingestQueue "IEdgeInsights/ImageStore/ingestqueue"
+	isConfigMgr "IEdgeInsights/ImageStore/isconfigmgr"
+	match "IEdgeInsights/ImageStore/match"
+	sampling "IEdgeInsights/ImageStore/sampling"
+	"fmt"
+)
+
+// IngestPoliciesFromConfig - function to create the filters, samplers,
+// queues and key templates of the topics from their ingest policies. Only
+// these fields of the IngestConfig of every topic are set, each may be nil
+// or empty.
+func IngestPoliciesFromConfig(policies map[string]isConfigMgr.IngestPolicy) (map[string]IngestConfig, error) {
+	ingests := make(map[string]IngestConfig, len(policies))
+	for topic, policy := range policies {
+		var ingest IngestConfig
+		var err error
+		if policy.Filter != "" {
+			if ingest.Filter, err = match.Compile(policy.Filter); err != nil {
+				return nil, fmt.Errorf("ingest filter of topic %s: %v", topic, err)
+			}
+		}
+
+		if policy.EveryNth > 1 || policy.MaxFPS > 0 || len(policy.OnChange) > 0 {
+			samplingPolicy := sampling.Policy{
+				EveryNth: policy.EveryNth,
+				MaxFPS:   policy.MaxFPS,
+				OnChange: policy.OnChange,
+			}
+			if policy.Bypass != "" {
+				if samplingPolicy.Bypass, err = match.Compile(policy.Bypass); err != nil {
+					return nil, fmt.Errorf("ingest policy of topic %s: %v", topic, err)
+				}
+			}
+			ingest.Sampler = sampling.NewSampler(samplingPolicy)
+		}
+
+		if policy.Queue != nil {
+			dropPolicy := policy.Queue.DropPolicy
+			if dropPolicy == "" {
+				dropPolicy = ingestQueue.Block
+			}
+			if ingest.Queue, err = ingestQueue.NewQueue(policy.Queue.MaxFrames, policy.Queue.MaxBytes, dropPolicy); err != nil {
+				return nil, fmt.Errorf("ingest queue of topic %s: %v", topic, err)
+			}
+		}
+
+		if policy.KeyTemplate != "" {
+			if err = ValidateKeyTemplate(policy.KeyTemplate); err != nil {
+				return nil, fmt.Errorf("ingest policy of topic %s: %v", topic, err)
+			}
+			ingest.KeyTemplate = policy.KeyTemplate
+		}
+		ingests[topic] = ingest
+	}
+	return ingests, nil
+}
+
+// RegIngestPolicies - function to set the filter, sampler, queue and key
+// template of every topic of policies
+func (subMgr *SubManager) RegIngestPolicies(policies map[string]IngestConfig) {
+	for topic, policy := range policies {
+		if policy.Filter != nil {
+			subMgr.RegFilter(topic, policy.Filter)
+		}
+		if policy.Sampler != nil {
+			subMgr.RegSampler(topic, policy.Sampler)
+		}
+		if policy.Queue != nil {
+			subMgr.RegQueue(topic, policy.Queue)
+		}
+		if policy.KeyTemplate != "" {
+			subMgr.RegKeyTemplate(topic, policy.KeyTemplate)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+/*
+Copyright (c) 2021 Intel Corporation
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package submanager
+
+import (
+	isConfigMgr "IEdgeInsights/ImageStore/isconfigmgr"
+	"testing"
+)
+
+func TestIngestPoliciesFromConfig(t *testing.T) {
+	ingests, err := IngestPoliciesFromConfig(map[string]isConfigMgr.IngestPolicy{
+		"camera1": {Filter: `len(defects) > 0`},
+		"camera2": {EveryNth: 2},
+		"camera3": {Queue: &isConfigMgr.IngestQueue{MaxFrames: 10}, KeyTemplate: "{topic}/{img_handle}"},
+	})
+	if err != nil {
+		t.Fatalf("IngestPoliciesFromConfig failed: %v", err)
+	}
+	if ingests["camera1"].Filter == nil || ingests["camera1"].Sampler != nil {
+		t.Errorf("Filter only policy not creating a filter alone")
+	}
+	if ingests["camera2"].Filter != nil || ingests["camera2"].Sampler == nil {
+		t.Errorf("Sampling policy created filter %v and sampler %v", ingests["camera2"].Filter, ingests["camera2"].Sampler)
+	}
+	if ingests["camera3"].Queue == nil || ingests["camera3"].KeyTemplate != "{topic}/{img_handle}" {
+		t.Errorf("Queue and key template not created: %+v", ingests["camera3"])
+	}
+
+	for _, policy := range []isConfigMgr.IngestPolicy{
+		{Filter: `len(defects) >`},
+		{EveryNth: 2, Bypass: `score >`},
+		{Queue: &isConfigMgr.IngestQueue{DropPolicy: "drop_all"}},
+		{KeyTemplate: "{topic}"},
+	} {
+		if _, err := IngestPoliciesFromConfig(map[string]isConfigMgr.IngestPolicy{"camera1": policy}); err == nil {
+			t.Errorf("Invalid policy %+v accepted", policy)
+		}
+	}
+}
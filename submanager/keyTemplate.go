@@ -0,0 +1,112 @@
+/*
+Copyright (c) 2021 Intel Corporation
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package submanager
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// keyPlaceholder matches the placeholders of a key template
+var keyPlaceholder = regexp.MustCompile(`\{([^{}]*)\}`)
+
+// metadataPrefix is the prefix of the placeholders of the frame metadata
+const metadataPrefix = "metadata."
+
+// unknownKeyValue replaces the placeholders of missing metadata fields
+const unknownKeyValue = "unknown"
+
+// ValidateKeyTemplate - function to check a key template only uses the known
+// placeholders and includes the image handle, keeping the keys unique
+//
+// Parameters:
+// 1. template : string
+//    Refers to the key template, e.g. {topic}/{date}/{img_handle}.
+//
+// Returns:
+// 1. error
+//    Returns an error object if the template is invalid.
+func ValidateKeyTemplate(template string) error {
+	if !strings.Contains(template, "{img_handle}") {
+		return errors.New("key template " + template + " must contain {img_handle}")
+	}
+	for _, match := range keyPlaceholder.FindAllStringSubmatch(template, -1) {
+		switch name := match[1]; {
+		case name == "topic", name == "date", name == "img_handle":
+		case strings.HasPrefix(name, metadataPrefix) && len(name) > len(metadataPrefix):
+		default:
+			return fmt.Errorf("unknown placeholder {%s} in key template %s", name, template)
+		}
+	}
+	return nil
+}
+
+// RenderKey - function to build the object key of an image handle from a key
+// template. A metadata field which is missing is rendered as "unknown", and
+// slashes in the values are replaced so they do not add levels to the key.
+//
+// Parameters:
+// 1. template : string
+//    Refers to the key template, the handle itself if empty.
+// 2. topic : string
+//    Refers to the topic the frame was received from.
+// 3. imgHandle : string
+//    Refers to the image handle of the blob.
+// 4. metadata : map[string]interface{}
+//    Refers to the metadata of the frame.
+// 5. captured : time.Time
+//    Refers to the capture time of the frame, the current time if zero.
+//
+// Returns:
+// 1. string
+//    Returns the object key.
+func RenderKey(template string, topic string, imgHandle string, metadata map[string]interface{}, captured time.Time) string {
+	if template == "" {
+		return imgHandle
+	}
+	if captured.IsZero() {
+		captured = time.Now()
+	}
+
+	return keyPlaceholder.ReplaceAllStringFunc(template, func(placeholder string) string {
+		var value string
+		switch name := placeholder[1 : len(placeholder)-1]; name {
+		case "topic":
+			value = topic
+		case "date":
+			value = captured.UTC().Format("2006-01-02")
+		case "img_handle":
+			value = imgHandle
+		default:
+			field, ok := metadata[strings.TrimPrefix(name, metadataPrefix)]
+			if !ok || field == nil {
+				return unknownKeyValue
+			}
+			value = fmt.Sprint(field)
+		}
+		return strings.Replace(value, "/", "_", -1)
+	})
+}
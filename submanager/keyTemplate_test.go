@@ -0,0 +1,61 @@
+/*
+Copyright (c) 2021 Intel Corporation
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package submanager
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRenderKey(t *testing.T) {
+	captured := time.Date(2021, 3, 4, 23, 30, 0, 0, time.FixedZone("", -2*3600))
+	metadata := map[string]interface{}{"camera_id": "line/1", "count": 3}
+
+	tests := []struct {
+		template string
+		want     string
+	}{
+		{"", "handle"},
+		{"{topic}/{date}/{img_handle}", "camera1/2021-03-05/handle"},
+		{"{metadata.camera_id}/{metadata.count}/{img_handle}", "line_1/3/handle"},
+		{"{metadata.missing}/{img_handle}", "unknown/handle"},
+	}
+	for _, test := range tests {
+		if got := RenderKey(test.template, "camera1", "handle", metadata, captured); got != test.want {
+			t.Errorf("RenderKey(%q) = %q, want %q", test.template, got, test.want)
+		}
+	}
+}
+
+func TestValidateKeyTemplate(t *testing.T) {
+	for _, template := range []string{"{topic}/{date}/{img_handle}", "{metadata.camera_id}/{img_handle}"} {
+		if err := ValidateKeyTemplate(template); err != nil {
+			t.Errorf("ValidateKeyTemplate(%q) failed: %v", template, err)
+		}
+	}
+	for _, template := range []string{"{topic}/{date}", "{camera}/{img_handle}", "{metadata.}/{img_handle}"} {
+		if err := ValidateKeyTemplate(template); err == nil {
+			t.Errorf("ValidateKeyTemplate(%q) succeeded", template)
+		}
+	}
+}
@@ -25,17 +25,104 @@ package submanager
 import (
 	eiimsgbus "EIIMessageBus/eiimsgbus"
 	common "IEdgeInsights/ImageStore/common"
+	ingestQueue "IEdgeInsights/ImageStore/ingestqueue"
+	match "IEdgeInsights/ImageStore/match"
+	metrics "IEdgeInsights/ImageStore/metrics"
+	sampling "IEdgeInsights/ImageStore/sampling"
 	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/golang/glog"
 )
 
-// SubManager - SubManager of type struct
+// SubManager - SubManager of type struct. The topics can be subscribed and
+// unsubscribed while receiving, the mutex guards the subscriptions.
 type SubManager struct {
+	mutex       sync.Mutex
 	subscribers map[string]*eiimsgbus.Subscriber
-	clientMap   map[string]*eiimsgbus.MsgbusClient
+	// Closes the subscriber and the message bus client of every connected
+	// topic
+	connections map[string]func()
 	subConfig   map[string]interface{}
 	writers     map[string]common.Writer
+	ingest      IngestConfig
+	samplers    map[string]*sampling.Sampler
+	filters     map[string]*match.Expression
+	queues      map[string]*ingestQueue.Queue
+	templates   map[string]string
+
+	// Message bus configs of the subscriber interfaces by name, and the
+	// interface of every subscribed topic
+	interfaces      map[string]map[string]interface{}
+	interfaceNames  []string
+	topicInterfaces map[string]string
+	// Closed to stop receiving from a topic
+	stops map[string]chan struct{}
+	// Closed once the receiving goroutine of a topic exited
+	receivers map[string]chan struct{}
+	// Health of the subscriber of every subscribed topic
+	health      map[string]*SubscriberHealth
+	supervision Supervision
+	// Topics whose queue is drained, the drain outlives an unsubscribe so
+	// the queued frames are still stored
+	draining map[string]bool
+	// Set by ReceiveFromAll, the topics subscribed afterwards are received
+	// right away
+	receiving bool
+	// Creates the writer of a topic without a registered writer, may be nil
+	newWriter func(topic string) (common.Writer, error)
+	// Creates the subscriber of a topic and its closer, replaced by the
+	// tests
+	dial func(subConfig map[string]interface{}, topic string) (*eiimsgbus.Subscriber, func(), error)
+}
+
+// Subscription - an active subscription, its ingest stats and health
+type Subscription struct {
+	Topic     string
+	Interface string
+	// Stats holds the per topic ingest counters and gauges
+	Stats  map[string]int64
+	Health HealthStatus
+}
+
+// Per topic metrics reported with the subscriptions, the metric name being
+// the stat name followed by "/" and the topic
+var subscriptionStats = []string{
+	"received_frames",
+	"stored_frames",
+	"store_failed_frames",
+	"filtered_out_frames",
+	"sampled_out_frames",
+	"ingest_dropped_frames",
+	"ingest_queue_frames",
+	"ingest_queue_bytes",
+}
+
+// IngestConfig - how the received frames are stored
+type IngestConfig struct {
+	// CaptureTimestampKey is the attribute of the frame metadata holding
+	// the capture time of the frame
+	CaptureTimestampKey string
+	// BlobHandleFormat is the handle of the additional blobs of a
+	// multi-blob frame, with {img_handle} and {index} placeholders
+	BlobHandleFormat string
+	// Filter rejects the frames of the topic whose metadata does not match,
+	// nil to accept them all
+	Filter *match.Expression
+	// Queue buffers the frames of the topic until they are stored by a
+	// separate goroutine, nil to store them from the receiving goroutine
+	Queue *ingestQueue.Queue
+	// Sampler selects the frames of the topic which are stored, nil to
+	// store them all
+	Sampler *sampling.Sampler
+	// KeyTemplate builds the object keys of the frames of the topic from
+	// their image handles, empty to store them under the handles
+	KeyTemplate string
 }
 
 // NewSubManager - function to initialize a new SubManager
@@ -48,67 +135,370 @@ func NewSubManager() *SubManager {
 func (subMgr *SubManager) init() {
 	subMgr.writers = make(map[string]common.Writer)
 	subMgr.subscribers = make(map[string]*eiimsgbus.Subscriber)
-	subMgr.clientMap = make(map[string]*eiimsgbus.MsgbusClient)
+	subMgr.samplers = make(map[string]*sampling.Sampler)
+	subMgr.filters = make(map[string]*match.Expression)
+	subMgr.queues = make(map[string]*ingestQueue.Queue)
+	subMgr.templates = make(map[string]string)
+	subMgr.interfaces = make(map[string]map[string]interface{})
+	subMgr.topicInterfaces = make(map[string]string)
+	subMgr.stops = make(map[string]chan struct{})
+	subMgr.receivers = make(map[string]chan struct{})
+	subMgr.health = make(map[string]*SubscriberHealth)
+	subMgr.supervision = DefaultSupervision()
+	subMgr.draining = make(map[string]bool)
+	subMgr.connections = make(map[string]func())
+	subMgr.dial = dialMsgbus
+	subMgr.ingest = IngestConfig{
+		CaptureTimestampKey: common.DefaultCaptureTimestampKey,
+		BlobHandleFormat:    common.DefaultBlobHandleFormat,
+	}
 }
 
 func (subMgr *SubManager) close() {
 	glog.Infof("-- Closing message bus context-- \n")
-	for _, client := range subMgr.clientMap {
-		client.Close()
+	for _, closeConnection := range subMgr.connections {
+		closeConnection()
 	}
 }
 
 // RegWriterInterface - RegWriterInterface function
 func (subMgr *SubManager) RegWriterInterface(name string, writer common.Writer) {
+	subMgr.mutex.Lock()
+	defer subMgr.mutex.Unlock()
 	subMgr.writers[name] = writer
 }
 
+// SetSupervision - function to set when the subscribers are considered dead
+// and how they are recreated, for the topics subscribed afterwards
+func (subMgr *SubManager) SetSupervision(supervision Supervision) {
+	subMgr.mutex.Lock()
+	defer subMgr.mutex.Unlock()
+	subMgr.supervision = supervision
+}
+
+// SetWriterFactory - function to set how the writer of a subscribed topic
+// without a registered writer is created
+func (subMgr *SubManager) SetWriterFactory(newWriter func(topic string) (common.Writer, error)) {
+	subMgr.mutex.Lock()
+	defer subMgr.mutex.Unlock()
+	subMgr.newWriter = newWriter
+}
+
+// SetIngestConfig - function to set how the received frames are stored
+func (subMgr *SubManager) SetIngestConfig(config IngestConfig) {
+	subMgr.mutex.Lock()
+	defer subMgr.mutex.Unlock()
+	subMgr.ingest = config
+}
+
+// RegFilter - function to set the filter the metadata of the frames of a
+// topic must match to be stored
+func (subMgr *SubManager) RegFilter(topic string, filter *match.Expression) {
+	subMgr.mutex.Lock()
+	defer subMgr.mutex.Unlock()
+	subMgr.filters[topic] = filter
+}
+
+// RegQueue - function to set the queue the frames of a topic are buffered
+// in until they are stored
+func (subMgr *SubManager) RegQueue(topic string, queue *ingestQueue.Queue) {
+	subMgr.mutex.Lock()
+	defer subMgr.mutex.Unlock()
+	subMgr.queues[topic] = queue
+}
+
+// RegSampler - function to set the sampler of the frames of a topic
+func (subMgr *SubManager) RegSampler(topic string, sampler *sampling.Sampler) {
+	subMgr.mutex.Lock()
+	defer subMgr.mutex.Unlock()
+	subMgr.samplers[topic] = sampler
+}
+
+// RegKeyTemplate - function to set the key template of the frames of a topic
+func (subMgr *SubManager) RegKeyTemplate(topic string, template string) {
+	subMgr.mutex.Lock()
+	defer subMgr.mutex.Unlock()
+	subMgr.templates[topic] = template
+}
+
 // RegSubscriberList - RegSubscriberList function
 func (subMgr *SubManager) RegSubscriberList(subConfig map[string]interface{}) {
 	subMgr.subConfig = subConfig
 }
 
 // StartAllSubscribers - function to create subscription object for all the topics
-// in topics array
-func (subMgr *SubManager) StartAllSubscribers(topics []string, subConfig map[string]interface{}) error {
+// in topics array. It is called once for every subscriber interface, with
+// its own name and message bus config. A topic can only be subscribed once.
+// The topics whose subscriber can not be created are still subscribed, the
+// subscriber is recreated with backoff once receiving. The topics without a
+// writer are not subscribed. The first error is returned.
+func (subMgr *SubManager) StartAllSubscribers(name string, topics []string, subConfig map[string]interface{}) error {
+	subMgr.mutex.Lock()
+	defer subMgr.mutex.Unlock()
 
+	if _, ok := subMgr.interfaces[name]; !ok {
+		subMgr.interfaceNames = append(subMgr.interfaceNames, name)
+	}
+	subMgr.interfaces[name] = subConfig
 	glog.Infof("-- subscribe to topics : %v\n", topics)
+	var firstErr error
 	for _, topic := range topics {
-		client, err := eiimsgbus.NewMsgbusClient(subConfig)
-		if err != nil {
-			glog.Infof("-- Error initializing message bus context: %v\n", err)
-			errorMessage := "-- Error initializing message bus context: " + err.Error()
-			return errors.New(errorMessage)
+		if other, ok := subMgr.topicInterfaces[topic]; ok {
+			if firstErr == nil {
+				firstErr = errors.New("-- Topic " + topic + " is already subscribed by interface " + other)
+			}
+			continue
+		}
+		if err := subMgr.createWriter(topic); err != nil {
+			glog.Errorf("Not subscribing to topic %s: %v", topic, err)
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
 		}
 
-		subMgr.clientMap[topic] = client
-		subscriber, err := client.NewSubscriber(topic)
+		health := NewSubscriberHealth(topic, subMgr.supervision)
+		subscriber, closeConnection, err := subMgr.connect(topic, name)
 		if err != nil {
-			glog.Infof("-- Error subscribing to topic: %v\n", err)
-			return err
+			health.setHealthy(false, err.Error())
+			if firstErr == nil {
+				firstErr = err
+			}
+		} else {
+			subMgr.connections[topic] = closeConnection
+			subMgr.subscribers[topic] = subscriber
+		}
+		subMgr.topicInterfaces[topic] = name
+		subMgr.health[topic] = health
+	}
+
+	return firstErr
+}
+
+// createWriter - function to create the writer of a topic with the writer
+// factory, unless one is registered, the caller holding the mutex
+func (subMgr *SubManager) createWriter(topic string) error {
+	if _, ok := subMgr.writers[topic]; ok {
+		return nil
+	}
+	if subMgr.newWriter == nil {
+		return errors.New("-- No writer for topic " + topic)
+	}
+	writer, err := subMgr.newWriter(topic)
+	if err != nil {
+		return err
+	}
+	subMgr.writers[topic] = writer
+	return nil
+}
+
+// connect - function to create the subscriber of a topic on the named
+// subscriber interface, and the function closing it
+func (subMgr *SubManager) connect(topic string, name string) (*eiimsgbus.Subscriber, func(), error) {
+	subConfig, ok := subMgr.interfaces[name]
+	if !ok {
+		return nil, nil, errors.New("-- Unknown subscriber interface " + name)
+	}
+	return subMgr.dial(subConfig, topic)
+}
+
+// dialMsgbus - function to create the message bus client and the subscriber
+// of a topic, the returned function closing both
+func dialMsgbus(subConfig map[string]interface{}, topic string) (*eiimsgbus.Subscriber, func(), error) {
+	client, err := eiimsgbus.NewMsgbusClient(subConfig)
+	if err != nil {
+		glog.Infof("-- Error initializing message bus context: %v\n", err)
+		errorMessage := "-- Error initializing message bus context: " + err.Error()
+		return nil, nil, errors.New(errorMessage)
+	}
+
+	subscriber, err := client.NewSubscriber(topic)
+	if err != nil {
+		glog.Infof("-- Error subscribing to topic: %v\n", err)
+		client.Close()
+		return nil, nil, err
+	}
+	closeConnection := func() {
+		subscriber.Close()
+		client.Close()
+	}
+	return subscriber, closeConnection, nil
+}
+
+// disconnect - function to close the subscriber and the message bus client
+// of a topic, if any, the caller holding the mutex
+func (subMgr *SubManager) disconnect(topic string) {
+	if closeConnection, ok := subMgr.connections[topic]; ok {
+		closeConnection()
+		delete(subMgr.connections, topic)
+	}
+	delete(subMgr.subscribers, topic)
+}
+
+// Subscribe - function to subscribe to a topic at runtime on the named
+// subscriber interface, the first one if name is empty. The frames of the
+// topic are received right away if ReceiveFromAll was called.
+func (subMgr *SubManager) Subscribe(topic string, name string) error {
+	subMgr.mutex.Lock()
+	defer subMgr.mutex.Unlock()
+
+	if name == "" {
+		if len(subMgr.interfaceNames) == 0 {
+			return errors.New("-- No subscriber interface configured")
 		}
+		name = subMgr.interfaceNames[0]
+	}
+	if _, ok := subMgr.interfaces[name]; !ok {
+		return errors.New("-- Unknown subscriber interface " + name)
+	}
+	if other, ok := subMgr.topicInterfaces[topic]; ok {
+		return errors.New("-- Topic " + topic + " is already subscribed by interface " + other)
+	}
 
-		subMgr.subscribers[topic] = subscriber
+	if err := subMgr.createWriter(topic); err != nil {
+		return err
 	}
 
+	subscriber, closeConnection, err := subMgr.connect(topic, name)
+	if err != nil {
+		return err
+	}
+	subMgr.connections[topic] = closeConnection
+	subMgr.subscribers[topic] = subscriber
+	subMgr.topicInterfaces[topic] = name
+	subMgr.health[topic] = NewSubscriberHealth(topic, subMgr.supervision)
+	glog.Infof("-- Subscribed to topic %s on interface %s", topic, name)
+	if subMgr.receiving {
+		subMgr.receive(topic)
+	}
 	return nil
 }
 
+// Unsubscribe - function to stop receiving from a topic at runtime. The
+// frames already queued for the topic are still stored. A topic subscribed
+// again is received once the receiver of the earlier subscription exited.
+func (subMgr *SubManager) Unsubscribe(topic string) error {
+	subMgr.mutex.Lock()
+	defer subMgr.mutex.Unlock()
+
+	if _, ok := subMgr.topicInterfaces[topic]; !ok {
+		return errors.New("-- Topic " + topic + " is not subscribed")
+	}
+	if stop, ok := subMgr.stops[topic]; ok {
+		close(stop)
+		delete(subMgr.stops, topic)
+	}
+	subMgr.disconnect(topic)
+
+	delete(subMgr.topicInterfaces, topic)
+	delete(subMgr.health, topic)
+	glog.Infof("-- Unsubscribed from topic %s", topic)
+	return nil
+}
+
+// Subscriptions - function to get the active subscriptions sorted by topic,
+// with their ingest stats and health
+func (subMgr *SubManager) Subscriptions() []Subscription {
+	subMgr.mutex.Lock()
+	defer subMgr.mutex.Unlock()
+
+	subscriptions := make([]Subscription, 0, len(subMgr.topicInterfaces))
+	for topic, name := range subMgr.topicInterfaces {
+		stats := make(map[string]int64, len(subscriptionStats))
+		for _, stat := range subscriptionStats {
+			stats[stat] = metrics.Get(stat + "/" + topic)
+		}
+		subscriptions = append(subscriptions, Subscription{
+			Topic:     topic,
+			Interface: name,
+			Stats:     stats,
+			Health:    subMgr.health[topic].Status(),
+		})
+	}
+	sort.Slice(subscriptions, func(i, j int) bool {
+		return subscriptions[i].Topic < subscriptions[j].Topic
+	})
+	return subscriptions
+}
+
 // ReceiveFromAll - function to start new go routine which receives a frame from the given subscription
 // topic and writes it to a storage
 func (subMgr *SubManager) ReceiveFromAll() {
-	for topicName, subscriber := range subMgr.subscribers {
-		go Receive(topicName, subMgr.writers[topicName], subscriber)
+	subMgr.mutex.Lock()
+	defer subMgr.mutex.Unlock()
+
+	subMgr.receiving = true
+	for topicName := range subMgr.topicInterfaces {
+		subMgr.receive(topicName)
+	}
+}
+
+// receive - function to start receiving from a subscribed topic under
+// supervision, the caller holding the mutex
+func (subMgr *SubManager) receive(topicName string) {
+	config := subMgr.ingest
+	config.Filter = subMgr.filters[topicName]
+	config.Sampler = subMgr.samplers[topicName]
+	config.Queue = subMgr.queues[topicName]
+	config.KeyTemplate = subMgr.templates[topicName]
+	if config.Queue != nil && !subMgr.draining[topicName] {
+		subMgr.draining[topicName] = true
+		go drain(topicName, subMgr.writers[topicName], config.Queue)
 	}
+
+	stop := make(chan struct{})
+	subMgr.stops[topicName] = stop
+	previous, done := subMgr.receivers[topicName], make(chan struct{})
+	subMgr.receivers[topicName] = done
+	go func(writer common.Writer, health *SubscriberHealth) {
+		defer close(done)
+		// The receiver of an earlier subscription of the topic shares its
+		// sampler and queue, which it may still be using after the stop
+		if previous != nil {
+			<-previous
+		}
+		subMgr.supervise(topicName, writer, config, health, stop)
+	}(subMgr.writers[topicName], subMgr.health[topicName])
 }
 
-// Receive - function to receive image for given topic name and put it into storage
-func Receive(topicName string, writer common.Writer, subscriber *eiimsgbus.Subscriber) {
+// Receive - function to receive image for given topic name and put it into storage.
+// Every blob of a multi-blob frame is stored, the first one under the image
+// handle and the others under handles derived from it. It returns nil once
+// stop is closed, or an error once health finds the subscriber dead:
+// too many consecutive errors or no message for the idle timeout.
+func Receive(topicName string, writer common.Writer, subscriber *eiimsgbus.Subscriber, config IngestConfig, stop <-chan struct{}, health *SubscriberHealth) error {
+	captureKey := config.CaptureTimestampKey
+
+	var idle <-chan time.Time
+	idleTimeout := health.supervision.IdleTimeout
+	var idleTimer *time.Timer
+	if idleTimeout > 0 {
+		idleTimer = time.NewTimer(idleTimeout)
+		defer idleTimer.Stop()
+		idle = idleTimer.C
+	}
 
 	for {
 		select {
+		case <-stop:
+			return nil
+
+		case <-idle:
+			return fmt.Errorf("no message for %v", idleTimeout)
+
 		case msg := <-subscriber.MessageChannel:
 			glog.Infof("\n-- Received Message: %v\n", msg.Data)
+			metrics.Add("received_frames/"+topicName, 1)
+			health.received(time.Now())
+			if idleTimer != nil {
+				if !idleTimer.Stop() {
+					select {
+					case <-idleTimer.C:
+					default:
+					}
+				}
+				idleTimer.Reset(idleTimeout)
+			}
 			imgHandle, ok := msg.Data[common.ImageHandle].(string)
 			if ok == false {
 				errMessage := "Missing image handle for topic " + topicName
@@ -116,15 +506,62 @@ func Receive(topicName string, writer common.Writer, subscriber *eiimsgbus.Subsc
 				continue
 			}
 
-			if msg.Blob != nil {
-				_, err := writer.Store(msg.Blob[0], imgHandle)
+			// Rejected frames are only counted, as most frames of a busy
+			// topic may be rejected
+			if config.Filter != nil && !config.Filter.Match(msg.Data) {
+				metrics.Add("filtered_out_frames", 1)
+				metrics.Add("filtered_out_frames/"+topicName, 1)
+				continue
+			}
+
+			if config.Sampler != nil {
+				stored, reason, bypassed := config.Sampler.Sample(msg.Data, time.Now())
+				if !stored {
+					glog.V(2).Infof("Sampled out handle %s from topic %s: %s", imgHandle, topicName, reason)
+					metrics.Add("sampled_out_frames", 1)
+					metrics.Add("sampled_out_frames_"+reason, 1)
+					metrics.Add("sampled_out_frames/"+topicName, 1)
+					continue
+				}
+				if bypassed {
+					metrics.Add("sampling_bypassed_frames", 1)
+				}
+			}
 
-				if err != nil {
-					errMessage := "Error In storing the image %s from topic %s & Error %s"
-					glog.Errorf(errMessage, msg.Data[common.ImageHandle], topicName, err)
-				} else {
-					glog.Infof("Image with handle %s stored successfully", imgHandle)
+			var captured time.Time
+			if value, ok := msg.Data[captureKey]; ok {
+				if captured, ok = captureTime(value); !ok {
+					glog.V(1).Infof("Ignoring invalid %s %v of handle %s", captureKey, value, imgHandle)
 				}
+			}
+
+			if len(msg.Blob) > 0 {
+				handles := BlobHandles(config.BlobHandleFormat, imgHandle, len(msg.Blob))
+				keys := make([]string, len(handles))
+				for i, handle := range handles {
+					keys[i] = RenderKey(config.KeyTemplate, topicName, handle, msg.Data, captured)
+				}
+				frame := &ingestQueue.Frame{
+					Blobs:    msg.Blob,
+					Handles:  handles,
+					Keys:     keys,
+					Metadata: msg.Data,
+					Captured: captured,
+				}
+				if config.Queue == nil {
+					storeFrame(topicName, writer, frame)
+					continue
+				}
+
+				for _, dropped := range config.Queue.Push(frame) {
+					glog.V(1).Infof("Ingest queue of topic %s full, dropped handle %s", topicName, dropped.Handles[0])
+					metrics.Add("ingest_dropped_frames", 1)
+					metrics.Add("ingest_dropped_bytes", dropped.Size())
+					metrics.Add("ingest_dropped_frames/"+topicName, 1)
+				}
+				queuedFrames, queuedBytes := config.Queue.Len()
+				metrics.Set("ingest_queue_frames/"+topicName, int64(queuedFrames))
+				metrics.Set("ingest_queue_bytes/"+topicName, queuedBytes)
 			} else {
 				errMessage := "Empty image for handle %s from topic %s"
 				glog.Errorf(errMessage, msg.Data[common.ImageHandle], topicName)
@@ -135,14 +572,104 @@ func Receive(topicName string, writer common.Writer, subscriber *eiimsgbus.Subsc
 			if err != nil {
 				errMessage := "Error while receiving from topic: %s, Error: %s"
 				glog.Infof(errMessage, topicName, err)
+				if health.failed(err) {
+					return fmt.Errorf("%d consecutive receive errors, last: %v", health.supervision.MaxErrors, err)
+				}
 			}
 		}
 	}
 }
 
+// drain - function to store the frames of the ingest queue of a topic until
+// the queue is closed
+func drain(topicName string, writer common.Writer, queue *ingestQueue.Queue) {
+	for {
+		frame, ok := queue.Pop()
+		if !ok {
+			return
+		}
+		storeFrame(topicName, writer, frame)
+
+		queuedFrames, queuedBytes := queue.Len()
+		metrics.Set("ingest_queue_frames/"+topicName, int64(queuedFrames))
+		metrics.Set("ingest_queue_bytes/"+topicName, queuedBytes)
+	}
+}
+
+// storeFrame - function to store every blob of a received frame
+func storeFrame(topicName string, writer common.Writer, frame *ingestQueue.Frame) {
+	imgHandle := frame.Handles[0]
+	keys := frame.Keys
+	if keys == nil {
+		keys = frame.Handles
+	}
+	_, err := writer.StoreFrames(frame.Blobs, keys, frame.Handles, frame.Metadata, frame.Captured)
+
+	if err != nil {
+		errMessage := "Error In storing the image %s from topic %s & Error %s"
+		glog.Errorf(errMessage, imgHandle, topicName, err)
+		metrics.Add("store_failed_frames/"+topicName, 1)
+	} else {
+		glog.Infof("Image with handle %s stored successfully", imgHandle)
+		metrics.Add("stored_frames/"+topicName, 1)
+	}
+}
+
+// BlobHandles - function to derive the handles of the blobs of a multi-blob
+// frame, the first blob keeping the image handle
+func BlobHandles(format string, imgHandle string, count int) []string {
+	handles := make([]string, count)
+	handles[0] = imgHandle
+	for i := 1; i < count; i++ {
+		replacer := strings.NewReplacer("{img_handle}", imgHandle, "{index}", strconv.Itoa(i))
+		handles[i] = replacer.Replace(format)
+	}
+	return handles
+}
+
+// captureTime converts a capture timestamp of the frame metadata, either an
+// RFC 3339 string or a Unix time in seconds, milliseconds, microseconds or
+// nanoseconds, told apart by magnitude
+func captureTime(value interface{}) (time.Time, bool) {
+	var number float64
+	switch v := value.(type) {
+	case string:
+		captured, err := time.Parse(time.RFC3339Nano, v)
+		return captured, err == nil
+	case float64:
+		number = v
+	case int64:
+		number = float64(v)
+	case int:
+		number = float64(v)
+	default:
+		return time.Time{}, false
+	}
+	if number <= 0 {
+		return time.Time{}, false
+	}
+
+	switch {
+	case number >= 1e17:
+		return time.Unix(0, int64(number)), true
+	case number >= 1e14:
+		return time.Unix(0, int64(number*1e3)), true
+	case number >= 1e11:
+		return time.Unix(0, int64(number*1e6)), true
+	default:
+		return time.Unix(0, int64(number*1e9)), true
+	}
+}
+
 // StopAllSubscribers - function to close all subscriber objects
 func (subMgr *SubManager) StopAllSubscribers() {
-	for _, sub := range subMgr.subscribers {
-		sub.Close()
+	subMgr.mutex.Lock()
+	defer subMgr.mutex.Unlock()
+	for topic := range subMgr.topicInterfaces {
+		if stop, ok := subMgr.stops[topic]; ok {
+			close(stop)
+			delete(subMgr.stops, topic)
+		}
+		subMgr.disconnect(topic)
 	}
 }
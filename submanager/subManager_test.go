@@ -0,0 +1,459 @@
+/*
+Copyright (c) 2021 Intel Corporation
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package submanager
+
+import (
+	eiimsgbus "EIIMessageBus/eiimsgbus"
+	types "EIIMessageBus/pkg/types"
+	common "IEdgeInsights/ImageStore/common"
+	match "IEdgeInsights/ImageStore/match"
+	metrics "IEdgeInsights/ImageStore/metrics"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeBus creates in-memory subscribers, counting the connections made and
+// closed by topic
+type fakeBus struct {
+	mutex       sync.Mutex
+	failing     map[string]bool
+	dials       map[string]int
+	closes      map[string]int
+	configs     map[string]map[string]interface{}
+	subscribers map[string]*eiimsgbus.Subscriber
+}
+
+func newFakeBus() *fakeBus {
+	return &fakeBus{
+		failing:     make(map[string]bool),
+		dials:       make(map[string]int),
+		closes:      make(map[string]int),
+		configs:     make(map[string]map[string]interface{}),
+		subscribers: make(map[string]*eiimsgbus.Subscriber),
+	}
+}
+
+func (bus *fakeBus) dial(subConfig map[string]interface{}, topic string) (*eiimsgbus.Subscriber, func(), error) {
+	bus.mutex.Lock()
+	defer bus.mutex.Unlock()
+	bus.dials[topic]++
+	bus.configs[topic] = subConfig
+	if bus.failing[topic] {
+		return nil, nil, errors.New("connection refused")
+	}
+	subscriber := &eiimsgbus.Subscriber{
+		MessageChannel: make(chan *types.MsgEnvelope, 1),
+		ErrorChannel:   make(chan error, 1),
+	}
+	bus.subscribers[topic] = subscriber
+	closeConnection := func() {
+		bus.mutex.Lock()
+		defer bus.mutex.Unlock()
+		bus.closes[topic]++
+	}
+	return subscriber, closeConnection, nil
+}
+
+func (bus *fakeBus) setFailing(topic string, failing bool) {
+	bus.mutex.Lock()
+	defer bus.mutex.Unlock()
+	bus.failing[topic] = failing
+}
+
+func (bus *fakeBus) stats(topic string) (int, int) {
+	bus.mutex.Lock()
+	defer bus.mutex.Unlock()
+	return bus.dials[topic], bus.closes[topic]
+}
+
+func (bus *fakeBus) config(topic string) map[string]interface{} {
+	bus.mutex.Lock()
+	defer bus.mutex.Unlock()
+	return bus.configs[topic]
+}
+
+func (bus *fakeBus) subscriber(topic string) *eiimsgbus.Subscriber {
+	bus.mutex.Lock()
+	defer bus.mutex.Unlock()
+	return bus.subscribers[topic]
+}
+
+// fakeWriter counts the stored frames
+type fakeWriter struct {
+	mutex  sync.Mutex
+	frames int
+}
+
+func (writer *fakeWriter) Store(value []byte, keyname string) (string, error) {
+	return keyname, nil
+}
+
+func (writer *fakeWriter) StoreFrame(value []byte, keyname string, frameMetadata map[string]interface{}, captured time.Time) (string, error) {
+	return keyname, nil
+}
+
+func (writer *fakeWriter) StoreFrames(values [][]byte, keynames []string, handles []string, frameMetadata map[string]interface{}, captured time.Time) ([]string, error) {
+	writer.mutex.Lock()
+	defer writer.mutex.Unlock()
+	writer.frames++
+	return keynames, nil
+}
+
+func (writer *fakeWriter) stored() int {
+	writer.mutex.Lock()
+	defer writer.mutex.Unlock()
+	return writer.frames
+}
+
+// newTestSubManager returns a SubManager connecting through bus, whose
+// writer factory fails for the topics named "invalid"
+func newTestSubManager(bus *fakeBus, writer common.Writer) *SubManager {
+	subMgr := NewSubManager()
+	subMgr.dial = bus.dial
+	subMgr.SetWriterFactory(func(topic string) (common.Writer, error) {
+		if topic == "invalid" {
+			return nil, errors.New("no storage for topic " + topic)
+		}
+		return writer, nil
+	})
+	return subMgr
+}
+
+// subscribedTopics returns the subscribed topics and their interfaces
+func subscribedTopics(subMgr *SubManager) string {
+	var topics []string
+	for _, subscription := range subMgr.Subscriptions() {
+		topics = append(topics, subscription.Topic+"@"+subscription.Interface)
+	}
+	return strings.Join(topics, ",")
+}
+
+// waitFor polls condition for up to a second
+func waitFor(condition func() bool) bool {
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+		if condition() {
+			return true
+		}
+	}
+	return condition()
+}
+
+func TestDuplicateTopics(t *testing.T) {
+	bus := newFakeBus()
+	subMgr := newTestSubManager(bus, &fakeWriter{})
+
+	if err := subMgr.StartAllSubscribers("first", []string{"camera1", "camera2"}, nil); err != nil {
+		t.Fatalf("StartAllSubscribers failed: %v", err)
+	}
+	if err := subMgr.StartAllSubscribers("second", []string{"camera2", "camera3"}, nil); err == nil {
+		t.Errorf("Topic subscribed by two interfaces")
+	}
+	if err := subMgr.Subscribe("camera1", "second"); err == nil {
+		t.Errorf("Topic subscribed twice")
+	}
+	if got := subscribedTopics(subMgr); got != "camera1@first,camera2@first,camera3@second" {
+		t.Errorf("Subscribed to %s", got)
+	}
+	if dials, _ := bus.stats("camera2"); dials != 1 {
+		t.Errorf("Duplicate topic connected %d times", dials)
+	}
+}
+
+func TestSubscriberInterfaces(t *testing.T) {
+	bus := newFakeBus()
+	subMgr := newTestSubManager(bus, &fakeWriter{})
+	first := map[string]interface{}{"type": "zmq_tcp", "name": "first"}
+	second := map[string]interface{}{"type": "zmq_ipc", "name": "second"}
+
+	// The second interface is started even though the first one failed
+	bus.setFailing("camera1", true)
+	if err := subMgr.StartAllSubscribers("first", []string{"camera1", "camera2"}, first); err == nil {
+		t.Errorf("Failed subscriber not reported")
+	}
+	if err := subMgr.StartAllSubscribers("second", []string{"camera3"}, second); err != nil {
+		t.Fatalf("StartAllSubscribers failed: %v", err)
+	}
+	if got := subscribedTopics(subMgr); got != "camera1@first,camera2@first,camera3@second" {
+		t.Errorf("Subscribed to %s", got)
+	}
+
+	// Every topic connects with the message bus config of its interface
+	for topic, config := range map[string]map[string]interface{}{"camera2": first, "camera3": second} {
+		if name := bus.config(topic)["name"]; name != config["name"] {
+			t.Errorf("Topic %s connected with the config of interface %v", topic, name)
+		}
+	}
+	if err := subMgr.Subscribe("camera4", "second"); err != nil {
+		t.Fatalf("Subscribe failed: %v", err)
+	}
+	if name := bus.config("camera4")["name"]; name != "second" {
+		t.Errorf("Topic subscribed at runtime connected with the config of interface %v", name)
+	}
+	for _, subscription := range subMgr.Subscriptions() {
+		if healthy := subscription.Health.Healthy; healthy == (subscription.Topic == "camera1") {
+			t.Errorf("Topic %s healthy %v", subscription.Topic, healthy)
+		}
+	}
+}
+
+func TestUnknownInterface(t *testing.T) {
+	bus := newFakeBus()
+	subMgr := newTestSubManager(bus, &fakeWriter{})
+
+	if err := subMgr.Subscribe("camera1", ""); err == nil {
+		t.Errorf("Subscribed without any subscriber interface")
+	}
+	if err := subMgr.StartAllSubscribers("first", []string{"camera1"}, nil); err != nil {
+		t.Fatalf("StartAllSubscribers failed: %v", err)
+	}
+	if err := subMgr.Subscribe("camera2", "second"); err == nil {
+		t.Errorf("Subscribed on an unknown interface")
+	}
+	if err := subMgr.Subscribe("camera2", ""); err != nil {
+		t.Errorf("Subscribe on the first interface failed: %v", err)
+	}
+	if got := subscribedTopics(subMgr); got != "camera1@first,camera2@first" {
+		t.Errorf("Subscribed to %s", got)
+	}
+}
+
+func TestWriterFailure(t *testing.T) {
+	bus := newFakeBus()
+	subMgr := newTestSubManager(bus, &fakeWriter{})
+
+	if err := subMgr.StartAllSubscribers("first", []string{"invalid", "camera1"}, nil); err == nil {
+		t.Errorf("Topic without writer subscribed")
+	}
+	if err := subMgr.Subscribe("invalid", ""); err == nil {
+		t.Errorf("Topic without writer subscribed at runtime")
+	}
+	if got := subscribedTopics(subMgr); got != "camera1@first" {
+		t.Errorf("Subscribed to %s", got)
+	}
+	if dials, _ := bus.stats("invalid"); dials != 0 {
+		t.Errorf("Topic without writer connected %d times", dials)
+	}
+}
+
+func TestUnsubscribeSupervised(t *testing.T) {
+	bus := newFakeBus()
+	writer := &fakeWriter{}
+	subMgr := newTestSubManager(bus, writer)
+	subMgr.SetSupervision(Supervision{MaxErrors: 1, MinBackoff: 5 * time.Millisecond, MaxBackoff: 10 * time.Millisecond})
+
+	// camera1 can not connect and is being recreated with backoff
+	bus.setFailing("camera1", true)
+	if err := subMgr.StartAllSubscribers("first", []string{"camera1", "camera2"}, nil); err == nil {
+		t.Errorf("Failed subscriber not reported")
+	}
+	subMgr.ReceiveFromAll()
+	if !waitFor(func() bool { dials, _ := bus.stats("camera1"); return dials >= 3 }) {
+		t.Fatalf("Failed subscriber not recreated")
+	}
+	if err := subMgr.Unsubscribe("camera1"); err != nil {
+		t.Fatalf("Unsubscribe failed: %v", err)
+	}
+	dials, _ := bus.stats("camera1")
+	bus.setFailing("camera1", false)
+	time.Sleep(50 * time.Millisecond)
+	if after, _ := bus.stats("camera1"); after != dials {
+		t.Errorf("Unsubscribed topic reconnected %d times", after-dials)
+	}
+
+	// camera2 is received until unsubscribed
+	subscriber := bus.subscriber("camera2")
+	frame := &types.MsgEnvelope{Data: map[string]interface{}{common.ImageHandle: "frame"}, Blob: [][]byte{[]byte("image")}}
+	subscriber.MessageChannel <- frame
+	if !waitFor(func() bool { return writer.stored() == 1 }) {
+		t.Fatalf("Frame not stored")
+	}
+	if err := subMgr.Unsubscribe("camera2"); err != nil {
+		t.Fatalf("Unsubscribe failed: %v", err)
+	}
+	if _, closes := bus.stats("camera2"); closes != 1 {
+		t.Errorf("Subscriber closed %d times", closes)
+	}
+	subscriber.MessageChannel <- frame
+	subscriber.ErrorChannel <- errors.New("connection lost")
+	time.Sleep(50 * time.Millisecond)
+	if writer.stored() != 1 {
+		t.Errorf("Frame of an unsubscribed topic stored")
+	}
+	if dials, _ := bus.stats("camera2"); dials != 1 {
+		t.Errorf("Unsubscribed topic reconnected")
+	}
+	if got := subscribedTopics(subMgr); got != "" {
+		t.Errorf("Still subscribed to %s", got)
+	}
+}
+
+// blockingWriter blocks the stores until released, recording the most
+// stores running at once
+type blockingWriter struct {
+	fakeWriter
+	release chan struct{}
+	active  int
+	most    int
+}
+
+func (writer *blockingWriter) StoreFrames(values [][]byte, keynames []string, handles []string, frameMetadata map[string]interface{}, captured time.Time) ([]string, error) {
+	writer.mutex.Lock()
+	writer.frames++
+	writer.active++
+	if writer.active > writer.most {
+		writer.most = writer.active
+	}
+	writer.mutex.Unlock()
+
+	<-writer.release
+	writer.mutex.Lock()
+	writer.active--
+	writer.mutex.Unlock()
+	return keynames, nil
+}
+
+func TestResubscribeWaitsForReceiver(t *testing.T) {
+	bus := newFakeBus()
+	writer := &blockingWriter{release: make(chan struct{})}
+	subMgr := newTestSubManager(bus, writer)
+	if err := subMgr.StartAllSubscribers("first", []string{"camera1"}, nil); err != nil {
+		t.Fatalf("StartAllSubscribers failed: %v", err)
+	}
+	subMgr.ReceiveFromAll()
+	defer subMgr.StopAllSubscribers()
+
+	// The receiver of the first subscription is stuck storing a frame
+	frame := &types.MsgEnvelope{Data: map[string]interface{}{common.ImageHandle: "frame"}, Blob: [][]byte{[]byte("image")}}
+	bus.subscriber("camera1").MessageChannel <- frame
+	if !waitFor(func() bool { return writer.stored() == 1 }) {
+		t.Fatalf("Frame not stored")
+	}
+	if err := subMgr.Unsubscribe("camera1"); err != nil {
+		t.Fatalf("Unsubscribe failed: %v", err)
+	}
+	if err := subMgr.Subscribe("camera1", ""); err != nil {
+		t.Fatalf("Subscribe failed: %v", err)
+	}
+
+	// so the new subscription is only received once it exited
+	bus.subscriber("camera1").MessageChannel <- frame
+	time.Sleep(50 * time.Millisecond)
+	if stored := writer.stored(); stored != 1 {
+		t.Errorf("New subscription received while the earlier one was running, stored %d frames", stored)
+	}
+	close(writer.release)
+	if !waitFor(func() bool { return writer.stored() == 2 }) {
+		t.Fatalf("Frame of the new subscription not stored")
+	}
+	writer.mutex.Lock()
+	defer writer.mutex.Unlock()
+	if writer.most != 1 {
+		t.Errorf("%d receivers of the topic stored at once", writer.most)
+	}
+}
+
+func TestBlobHandles(t *testing.T) {
+	tests := []struct {
+		format string
+		count  int
+		want   string
+	}{
+		{common.DefaultBlobHandleFormat, 1, "frame"},
+		{common.DefaultBlobHandleFormat, 3, "frame,frame_1,frame_2"},
+		{"{img_handle}-annotated-{index}", 2, "frame,frame-annotated-1"},
+	}
+	for _, test := range tests {
+		if got := BlobHandles(test.format, "frame", test.count); strings.Join(got, ",") != test.want {
+			t.Errorf("BlobHandles(%q, %d) = %v, want %s", test.format, test.count, got, test.want)
+		}
+	}
+}
+
+func TestReceiveFilter(t *testing.T) {
+	filter, err := match.Compile(`len(defects) > 0 && score >= 0.5`)
+	if err != nil {
+		t.Fatalf("Compile failed: %v", err)
+	}
+	config := IngestConfig{BlobHandleFormat: common.DefaultBlobHandleFormat, Filter: filter}
+	subscriber := &eiimsgbus.Subscriber{
+		MessageChannel: make(chan *types.MsgEnvelope, 4),
+		ErrorChannel:   make(chan error),
+	}
+	for _, data := range []map[string]interface{}{
+		{"defects": []interface{}{"scratch"}, "score": 0.75},
+		{"defects": []interface{}{}, "score": 0.75},
+		{"score": 0.75},
+		{"defects": []interface{}{"scratch"}, "score": 0.25},
+	} {
+		data[common.ImageHandle] = "frame"
+		subscriber.MessageChannel <- &types.MsgEnvelope{Data: data, Blob: [][]byte{[]byte("image")}}
+	}
+
+	received, filtered := metrics.Get("received_frames/filtered"), metrics.Get("filtered_out_frames/filtered")
+	writer := &fakeWriter{}
+	stop := make(chan struct{})
+	done := make(chan error)
+	go func() {
+		done <- Receive("filtered", writer, subscriber, config, stop, NewSubscriberHealth("filtered", DefaultSupervision()))
+	}()
+	ok := waitFor(func() bool { return metrics.Get("received_frames/filtered") == received+4 })
+	close(stop)
+	if err := <-done; err != nil || !ok {
+		t.Fatalf("Receive failed: %v", err)
+	}
+	if stored := writer.stored(); stored != 1 {
+		t.Errorf("Stored %d frames, expected the matching one", stored)
+	}
+	if filtered = metrics.Get("filtered_out_frames/filtered") - filtered; filtered != 3 {
+		t.Errorf("Filtered out %d frames, expected 3", filtered)
+	}
+}
+
+func TestRegFilter(t *testing.T) {
+	bus := newFakeBus()
+	writer := &fakeWriter{}
+	subMgr := newTestSubManager(bus, writer)
+	filter, err := match.Compile(`class == "scratch"`)
+	if err != nil {
+		t.Fatalf("Compile failed: %v", err)
+	}
+	subMgr.RegFilter("camera1", filter)
+	filtered := metrics.Get("filtered_out_frames/camera1")
+	if err := subMgr.StartAllSubscribers("first", []string{"camera1", "camera2"}, nil); err != nil {
+		t.Fatalf("StartAllSubscribers failed: %v", err)
+	}
+	subMgr.ReceiveFromAll()
+	defer subMgr.StopAllSubscribers()
+
+	// Only the frames of the filtered topic are filtered
+	for _, topic := range []string{"camera1", "camera2"} {
+		frame := &types.MsgEnvelope{Data: map[string]interface{}{common.ImageHandle: topic, "class": "dent"}, Blob: [][]byte{[]byte("image")}}
+		bus.subscriber(topic).MessageChannel <- frame
+	}
+	if !waitFor(func() bool { return writer.stored() == 1 && metrics.Get("filtered_out_frames/camera1") == filtered+1 }) {
+		t.Errorf("Stored %d frames and filtered out %d, expected 1 of each", writer.stored(), metrics.Get("filtered_out_frames/camera1")-filtered)
+	}
+}
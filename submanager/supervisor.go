@@ -0,0 +1,275 @@
+/*
+Copyright (c) 2021 Intel Corporation
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package submanager
+
+import (
+	eiimsgbus "EIIMessageBus/eiimsgbus"
+	common "IEdgeInsights/ImageStore/common"
+	isConfigMgr "IEdgeInsights/ImageStore/isconfigmgr"
+	metrics "IEdgeInsights/ImageStore/metrics"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// Supervision - when a subscriber is considered dead and how it is recreated
+type Supervision struct {
+	// IdleTimeout is the time without any message after which the
+	// subscriber is recreated, 0 to never consider a quiet topic dead
+	IdleTimeout time.Duration
+	// MaxErrors is the number of consecutive receive errors after which the
+	// subscriber is recreated
+	MaxErrors int
+	// MinBackoff and MaxBackoff bound the wait before recreating the
+	// subscriber, doubled after every reconnect which did not bring any
+	// message
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+}
+
+// DefaultSupervision - function to get the supervision used if none is set
+func DefaultSupervision() Supervision {
+	return Supervision{
+		MaxErrors:  5,
+		MinBackoff: time.Second,
+		MaxBackoff: time.Minute,
+	}
+}
+
+// SupervisionFromConfig - function to create the supervision of the
+// subscriber supervision config, the missing values taken from the default
+// supervision
+func SupervisionFromConfig(config *isConfigMgr.SubscriberSupervision) (Supervision, error) {
+	supervision := DefaultSupervision()
+	durations := []struct {
+		name  string
+		value string
+		dest  *time.Duration
+	}{
+		{"idleTimeout", config.IdleTimeout, &supervision.IdleTimeout},
+		{"minBackoff", config.MinBackoff, &supervision.MinBackoff},
+		{"maxBackoff", config.MaxBackoff, &supervision.MaxBackoff},
+	}
+	for _, duration := range durations {
+		if duration.value == "" {
+			continue
+		}
+		parsed, err := time.ParseDuration(duration.value)
+		if err != nil {
+			return supervision, fmt.Errorf("%s is invalid: %v", duration.name, err)
+		}
+		if parsed <= 0 {
+			return supervision, fmt.Errorf("%s must be positive", duration.name)
+		}
+		*duration.dest = parsed
+	}
+
+	if config.MaxErrors < 0 {
+		return supervision, errors.New("maxErrors must be positive")
+	}
+	if config.MaxErrors > 0 {
+		supervision.MaxErrors = config.MaxErrors
+	}
+	if supervision.MinBackoff > supervision.MaxBackoff {
+		return supervision, errors.New("minBackoff must not exceed maxBackoff")
+	}
+	return supervision, nil
+}
+
+// errUnsubscribed stops the supervision of an unsubscribed topic
+var errUnsubscribed = errors.New("topic unsubscribed")
+
+// HealthStatus - the health of the subscriber of a topic
+type HealthStatus struct {
+	Healthy           bool
+	LastMessage       time.Time // zero if no message was received
+	ConsecutiveErrors int
+	LastError         string
+	Reconnects        int64
+}
+
+// SubscriberHealth - tracks the messages and errors of the subscriber of a
+// topic, updated by Receive and by the supervisor
+type SubscriberHealth struct {
+	mutex       sync.Mutex
+	topic       string
+	supervision Supervision
+	status      HealthStatus
+}
+
+// NewSubscriberHealth - function to create the health of the subscriber of
+// a topic, healthy until it fails
+func NewSubscriberHealth(topic string, supervision Supervision) *SubscriberHealth {
+	health := &SubscriberHealth{topic: topic, supervision: supervision}
+	health.setHealthy(true, "")
+	return health
+}
+
+// Status - function to get a copy of the health status
+func (health *SubscriberHealth) Status() HealthStatus {
+	health.mutex.Lock()
+	defer health.mutex.Unlock()
+	return health.status
+}
+
+// received - function to record a message, resetting the errors
+func (health *SubscriberHealth) received(now time.Time) {
+	health.mutex.Lock()
+	defer health.mutex.Unlock()
+	health.status.LastMessage = now
+	health.status.ConsecutiveErrors = 0
+}
+
+// failed - function to record a receive error, returning true once the
+// subscriber had too many consecutive errors
+func (health *SubscriberHealth) failed(err error) bool {
+	health.mutex.Lock()
+	defer health.mutex.Unlock()
+	health.status.ConsecutiveErrors++
+	health.status.LastError = err.Error()
+	return health.status.ConsecutiveErrors >= health.supervision.MaxErrors
+}
+
+// receivedSince - function to check whether a message was received after t
+func (health *SubscriberHealth) receivedSince(t time.Time) bool {
+	health.mutex.Lock()
+	defer health.mutex.Unlock()
+	return health.status.LastMessage.After(t)
+}
+
+func (health *SubscriberHealth) setHealthy(healthy bool, reason string) {
+	health.mutex.Lock()
+	defer health.mutex.Unlock()
+	health.status.Healthy = healthy
+	if reason != "" {
+		health.status.LastError = reason
+	}
+	var gauge int64
+	if healthy {
+		gauge = 1
+	}
+	metrics.Set("subscriber_healthy/"+health.topic, gauge)
+}
+
+func (health *SubscriberHealth) reconnected() {
+	health.mutex.Lock()
+	health.status.Reconnects++
+	health.status.ConsecutiveErrors = 0
+	health.mutex.Unlock()
+
+	health.setHealthy(true, "")
+	metrics.Add("subscriber_reconnects", 1)
+	metrics.Add("subscriber_reconnects/"+health.topic, 1)
+}
+
+// nextBackoff - function to double the backoff, up to the maximum
+func (supervision Supervision) nextBackoff(backoff time.Duration) time.Duration {
+	backoff *= 2
+	if backoff > supervision.MaxBackoff {
+		backoff = supervision.MaxBackoff
+	}
+	return backoff
+}
+
+// supervise - function to receive from a topic until stop is closed. The
+// subscriber is recreated with backoff whenever Receive finds it dead, or
+// if it could not be created at startup.
+func (subMgr *SubManager) supervise(topic string, writer common.Writer, config IngestConfig, health *SubscriberHealth, stop <-chan struct{}) {
+	supervision := health.supervision
+	backoff := supervision.MinBackoff
+	connected := time.Now()
+	for {
+		if subscriber, ok := subMgr.subscriberOf(topic, stop); ok {
+			err := Receive(topic, writer, subscriber, config, stop, health)
+			if err == nil {
+				return
+			}
+			glog.Errorf("Subscriber of topic %s is dead, recreating it: %v", topic, err)
+			health.setHealthy(false, err.Error())
+			if health.receivedSince(connected) {
+				backoff = supervision.MinBackoff
+			}
+		}
+
+		for {
+			select {
+			case <-stop:
+				return
+			case <-time.After(backoff):
+			}
+			backoff = supervision.nextBackoff(backoff)
+
+			err := subMgr.reconnect(topic, stop)
+			if err == errUnsubscribed {
+				return
+			}
+			if err == nil {
+				break
+			}
+			glog.Errorf("Failed to recreate the subscriber of topic %s, retrying in %v: %v", topic, backoff, err)
+		}
+		glog.Infof("-- Recreated the subscriber of topic %s", topic)
+		health.reconnected()
+		connected = time.Now()
+	}
+}
+
+// subscriberOf - function to get the subscriber of a topic, false if it has
+// to be recreated or the topic was unsubscribed
+func (subMgr *SubManager) subscriberOf(topic string, stop <-chan struct{}) (*eiimsgbus.Subscriber, bool) {
+	subMgr.mutex.Lock()
+	defer subMgr.mutex.Unlock()
+	select {
+	case <-stop:
+		return nil, false
+	default:
+	}
+	subscriber := subMgr.subscribers[topic]
+	return subscriber, subscriber != nil
+}
+
+// reconnect - function to replace the message bus client and subscriber of a
+// topic
+func (subMgr *SubManager) reconnect(topic string, stop <-chan struct{}) error {
+	subMgr.mutex.Lock()
+	defer subMgr.mutex.Unlock()
+
+	// Unsubscribe closes stop while holding the mutex
+	select {
+	case <-stop:
+		return errUnsubscribed
+	default:
+	}
+
+	subMgr.disconnect(topic)
+	subscriber, closeConnection, err := subMgr.connect(topic, subMgr.topicInterfaces[topic])
+	if err != nil {
+		return err
+	}
+	subMgr.connections[topic] = closeConnection
+	subMgr.subscribers[topic] = subscriber
+	return nil
+}
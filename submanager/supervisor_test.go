@@ -0,0 +1,93 @@
+/*
+Copyright (c) 2021 Intel Corporation
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package submanager
+
+import (
+	isConfigMgr "IEdgeInsights/ImageStore/isconfigmgr"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestSubscriberHealth(t *testing.T) {
+	supervision := Supervision{MaxErrors: 3, MinBackoff: time.Second, MaxBackoff: 5 * time.Second}
+	health := NewSubscriberHealth("camera1", supervision)
+
+	err := errors.New("receive failed")
+	if health.failed(err) || health.failed(err) {
+		t.Fatalf("subscriber dead before %d errors", supervision.MaxErrors)
+	}
+	health.received(time.Now())
+	if health.failed(err) || health.failed(err) {
+		t.Fatalf("errors not reset by a message")
+	}
+	if !health.failed(err) {
+		t.Fatalf("subscriber alive after %d consecutive errors", supervision.MaxErrors)
+	}
+
+	health.setHealthy(false, "dead")
+	health.reconnected()
+	status := health.Status()
+	if !status.Healthy || status.Reconnects != 1 || status.ConsecutiveErrors != 0 || status.LastError != "dead" {
+		t.Errorf("unexpected status after reconnect %+v", status)
+	}
+}
+
+func TestNextBackoff(t *testing.T) {
+	supervision := Supervision{MinBackoff: time.Second, MaxBackoff: 5 * time.Second}
+	backoff := supervision.MinBackoff
+	var got []time.Duration
+	for i := 0; i < 4; i++ {
+		backoff = supervision.nextBackoff(backoff)
+		got = append(got, backoff)
+	}
+	want := []time.Duration{2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("backoff %d = %v, want %v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestSupervisionFromConfig(t *testing.T) {
+	supervision, err := SupervisionFromConfig(&isConfigMgr.SubscriberSupervision{IdleTimeout: "30s", MaxBackoff: "2m"})
+	if err != nil {
+		t.Fatalf("SupervisionFromConfig failed: %v", err)
+	}
+	defaults := DefaultSupervision()
+	if supervision.IdleTimeout != 30*time.Second || supervision.MaxBackoff != 2*time.Minute ||
+		supervision.MinBackoff != defaults.MinBackoff || supervision.MaxErrors != defaults.MaxErrors {
+		t.Errorf("unexpected supervision %+v", supervision)
+	}
+
+	for _, config := range []isConfigMgr.SubscriberSupervision{
+		{IdleTimeout: "soon"},
+		{MinBackoff: "0s"},
+		{MaxErrors: -1},
+		{MinBackoff: "2m", MaxBackoff: "1m"},
+	} {
+		if _, err := SupervisionFromConfig(&config); err == nil {
+			t.Errorf("invalid supervision config %+v accepted", config)
+		}
+	}
+}